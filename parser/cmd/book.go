@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/parser"
+	"github.com/terratensor/library/parser/internal/preview"
+	"github.com/terratensor/library/parser/internal/state"
+	"github.com/terratensor/library/parser/internal/storage/manticore"
+)
+
+// runReindex переиндексирует один файл на месте и обновляет строки авторов,
+// категорий и заголовков: добавляет недостающие и удаляет те, на которые
+// больше не ссылается ни один параграф
+func runReindex(ctx context.Context, args []string) error {
+	fs := newFlagSet("reindex")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: reindex [flags] <path>")
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	logger := setupLogger(cfg.Env)
+
+	storage, closeStorage, err := newStorage(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+	st, err := openState(cfg)
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		defer st.Close()
+	}
+
+	// Метаданные прежней версии книги нужно прочитать до удаления её параграфов:
+	// по UUID из состояния и по UUID текущего содержимого файла. Имя файла
+	// для этого не годится, его могут носить книги в других папках.
+	refs := newRefs(cfg, logger)
+	path := fs.Arg(0)
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	hash, err := book.Fingerprint(abs)
+	if err != nil {
+		return err
+	}
+	ids := []string{book.SourceUUID(hash).String()}
+	if st != nil {
+		rec, ok, err := st.Get(abs)
+		if err != nil {
+			return err
+		}
+		if ok && rec.SourceUUID != "" && rec.SourceUUID != ids[0] {
+			ids = append(ids, rec.SourceUUID)
+		}
+	}
+	for _, id := range ids {
+		if err := refs.load(ctx, "source_uuid", id); err != nil {
+			return err
+		}
+	}
+
+	prs := parser.NewParser(cfg, storage)
+	res, err := prs.Reindex(ctx, st, path)
+	if err != nil {
+		return err
+	}
+	if err := refs.sync(ctx, res.SourceUUID.String()); err != nil {
+		return err
+	}
+
+	logger.Info("file reindexed",
+		slog.String("path", path),
+		slog.String("source_uuid", res.SourceUUID.String()),
+		slog.Int("chunks", res.Chunks),
+		slog.Int("failed", res.Failed))
+	reportPartial(prs, cfg, logger)
+	return nil
+}
+
+// runDelete удаляет книгу из индекса по UUID, имени исходного файла или пути к нему
+// и удаляет строки авторов, категорий и заголовков, на которые больше не ссылается
+// ни один параграф
+func runDelete(ctx context.Context, args []string) error {
+	fs := newFlagSet("delete")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: delete [flags] <uuid|source|path>")
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	logger := setupLogger(cfg.Env)
+
+	storage, closeStorage, err := newStorage(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+	st, err := openState(cfg)
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		defer st.Close()
+	}
+
+	target := fs.Arg(0)
+	book, err := resolveBook(st, target)
+	if err != nil {
+		return err
+	}
+
+	refs := newRefs(cfg, logger)
+	if book.id != uuid.Nil {
+		if err := refs.load(ctx, "source_uuid", book.id.String()); err != nil {
+			return err
+		}
+		if err := storage.DeleteBook(ctx, book.id); err != nil {
+			return err
+		}
+	} else {
+		if err := refs.load(ctx, "source", target); err != nil {
+			return err
+		}
+		if err := storage.DeleteSource(ctx, target); err != nil {
+			return err
+		}
+	}
+	if err := refs.sync(ctx, ""); err != nil {
+		return err
+	}
+
+	// Забываем о файле в состоянии, иначе инкрементальный запуск посчитает его проиндексированным
+	if st != nil {
+		var matched []string
+		err := st.ForEach(func(rec state.Record) error {
+			if rec.Path == book.path || (book.id != uuid.Nil && rec.SourceUUID == book.id.String()) ||
+				filepath.Base(rec.Path) == target {
+				matched = append(matched, rec.Path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, path := range matched {
+			if err := st.Delete(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.Info("book deleted", slog.String("target", target))
+	return nil
+}
+
+// bookTarget книга, указанная в аргументе команды delete
+type bookTarget struct {
+	id   uuid.UUID // uuid.Nil, если книга указана только именем файла
+	path string    // абсолютный путь, если указан существующий файл
+}
+
+// resolveBook разбирает аргумент команды delete: UUID книги, путь к существующему
+// файлу (UUID берётся из состояния или вычисляется по содержимому) или имя файла
+func resolveBook(st *state.Store, target string) (bookTarget, error) {
+	if id, err := uuid.Parse(target); err == nil {
+		return bookTarget{id: id}, nil
+	}
+
+	info, err := os.Stat(target)
+	if err != nil || info.IsDir() {
+		return bookTarget{}, nil
+	}
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return bookTarget{}, err
+	}
+	if st != nil {
+		rec, ok, err := st.Get(abs)
+		if err != nil {
+			return bookTarget{}, err
+		}
+		if ok && rec.SourceUUID != "" {
+			if id, err := uuid.Parse(rec.SourceUUID); err == nil {
+				return bookTarget{id: id, path: abs}, nil
+			}
+		}
+	}
+	hash, err := book.Fingerprint(abs)
+	if err != nil {
+		return bookTarget{}, err
+	}
+	return bookTarget{id: book.SourceUUID(hash), path: abs}, nil
+}
+
+// bookRefs строки авторов, категорий и заголовков, затронутые изменением книги.
+// Для файлового хранилища ничего не делает: проверить ссылки можно только в Manticore.
+type bookRefs struct {
+	client *manticore.Client
+	old    []entry.BookRef
+	logger *slog.Logger
+}
+
+func newRefs(cfg *config.Config, logger *slog.Logger) *bookRefs {
+	r := &bookRefs{logger: logger}
+	if cfg.Storage.Type == "" || cfg.Storage.Type == "manticore" {
+		r.client = manticore.Connect(&cfg.Manticore)
+	}
+	return r
+}
+
+// load запоминает метаданные параграфов книги до её удаления
+func (r *bookRefs) load(ctx context.Context, field, value string) error {
+	if r.client == nil {
+		return nil
+	}
+	refs, err := r.client.BookRefs(ctx, field, value)
+	if err != nil {
+		return err
+	}
+	r.old = append(r.old, refs...)
+	return nil
+}
+
+// sync добавляет строки для новой версии книги sourceUUID (пусто — книга удалена)
+// и удаляет строки прежней версии, на которые больше нет ссылок
+func (r *bookRefs) sync(ctx context.Context, sourceUUID string) error {
+	if r.client == nil {
+		return nil
+	}
+	added := 0
+	if sourceUUID != "" {
+		refs, err := r.client.BookRefs(ctx, "source_uuid", sourceUUID)
+		if err != nil {
+			return err
+		}
+		if added, err = r.client.AddRefs(ctx, refs); err != nil {
+			return err
+		}
+	}
+	removed, err := r.client.CollectGarbage(ctx, r.old)
+	if err != nil {
+		return err
+	}
+	if added > 0 || removed > 0 {
+		r.logger.Info("metadata tables updated", slog.Int("added", added), slog.Int("removed", removed))
+	}
+	return nil
+}
+
+// runPreview показывает разбиение файла на параграфы без записи в хранилище.
+// Параграфы выводятся в stdout, сводка и гистограмма размеров — в stderr.
+func runPreview(ctx context.Context, args []string) error {
+	fs := newFlagSet("preview")
+	format := fs.String("format", "jsonl", "формат вывода: jsonl или markdown")
+	histogram := fs.Bool("histogram", true, "вывести гистограмму размеров параграфов")
+	bucket := fs.Int("bucket", 250, "ширина корзины гистограммы в символах")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: preview [flags] <file>")
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+
+	// Хранилище не нужно, параграфы только выводятся
+	prs := parser.NewParser(cfg, nil)
+	titleList, entries, err := prs.Preview(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "jsonl":
+		err = preview.WriteJSONL(os.Stdout, entries)
+	case "markdown", "md":
+		err = preview.WriteMarkdown(os.Stdout, titleList, entries)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *histogram {
+		sizes := preview.Sizes{Min: cfg.MinParSize, Opt: cfg.OptParSize, Max: cfg.MaxParSize}
+		return preview.WriteHistogram(os.Stderr, entries, sizes, *bucket)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/terratensor/library/parser/internal/checkpoint"
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/lib/logger/sl"
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/parser"
+	"github.com/terratensor/library/parser/internal/state"
+	"github.com/terratensor/library/parser/internal/utils"
+	"github.com/terratensor/library/parser/internal/workerpool"
+)
+
+// runIndex индексирует весь том или tar-архив
+func runIndex(ctx context.Context, args []string) error {
+	fs := newFlagSet("index")
+	resume := fs.Bool("resume", false, "продолжить обработку tar-архива с последней контрольной точки")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+
+	logger := setupLogger(cfg.Env)
+	logger.Debug("logger debug mode enabled")
+
+	_, err = indexVolume(ctx, cfg, *resume, logger)
+	return err
+}
+
+// indexVolume индексирует том или tar-архив из cfg.Volume в таблицы из конфига
+// и возвращает парсер с итогами обработки
+func indexVolume(ctx context.Context, cfg *config.Config, resume bool, logger *slog.Logger) (*parser.Parser, error) {
+	// Инициализация хранилища
+	storage, closeStorage, err := newStorage(ctx, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStorage()
+
+	// Инициализация парсера
+	prs := parser.NewParser(cfg, storage)
+
+	// Обработка tar-архивов
+	if isTarArchive(cfg.Volume) {
+		if err := processTarArchive(ctx, prs, cfg, resume, logger); err != nil {
+			return nil, fmt.Errorf("error processing tar archive: %w", err)
+		}
+		reportPartial(prs, cfg, logger)
+		reportUnmapped(prs.UnmappedGenres(), logger)
+		saveReport(prs.Report(), cfg, logger)
+		log.Println("all files done")
+		return prs, nil
+	}
+
+	// Обработка обычных файлов
+	files, paths, err := findFiles(cfg.Volume)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+
+	// Инкрементальная индексация по локальному файлу состояния
+	st, err := openState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if st != nil {
+		defer st.Close()
+		// Новые книги сравниваются по содержимому и с проиндексированными раньше
+		loaded, err := prs.LoadSignatures(st)
+		if err != nil {
+			logger.Error("error loading content signatures", sl.Err(err))
+		}
+		logger.Info("content signatures loaded", slog.Int("books", loaded))
+	}
+
+	var allTask []*workerpool.Task
+	for n, file := range files {
+		task := workerpool.NewTask(func(data interface{}) error {
+			fileData := data.(struct {
+				file os.DirEntry
+				path string
+				n    int
+			})
+			fmt.Printf("Processing file %v\n", fileData.file.Name())
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			var err error
+			if st != nil {
+				_, err = prs.ParseIncremental(ctx, st, fileData.file, filepath.Dir(fileData.path))
+			} else {
+				_, err = prs.Parse(ctx, fileData.file, filepath.Dir(fileData.path))
+			}
+			if err != nil {
+				logger.Error("error processing file",
+					slog.String("filename", fileData.file.Name()),
+					sl.Err(err))
+				return err
+			}
+			return nil
+		}, struct {
+			file os.DirEntry
+			path string
+			n    int
+		}{file, paths[n], n})
+		allTask = append(allTask, task)
+	}
+	defer utils.Duration(utils.Track("Обработка завершена за "))
+	pool := workerpool.NewPool(allTask, cfg.Concurrency)
+	pool.Run()
+
+	// Удаляем из индекса книги, файлы которых исчезли из тома
+	if st != nil && ctx.Err() == nil {
+		seen := make(map[string]struct{}, len(paths))
+		for _, path := range paths {
+			if abs, err := filepath.Abs(path); err == nil {
+				seen[abs] = struct{}{}
+			}
+		}
+		purged, err := prs.PurgeMissing(ctx, st, cfg.Volume, seen)
+		if err != nil {
+			logger.Error("error purging missing files", sl.Err(err))
+		}
+		logger.Info("incremental indexing completed", slog.Int("purged", purged))
+	}
+
+	reportPartial(prs, cfg, logger)
+	reportUnmapped(prs.UnmappedGenres(), logger)
+	saveReport(prs.Report(), cfg, logger)
+	log.Println("all files done")
+	return prs, nil
+}
+
+// reportPartial выводит книги, часть параграфов которых не удалось записать
+func reportPartial(prs *parser.Parser, cfg *config.Config, logger *slog.Logger) {
+	partial := prs.PartialBooks()
+	if len(partial) == 0 {
+		return
+	}
+	for _, res := range partial {
+		logger.Warn("book indexed partially",
+			slog.String("source", res.Source),
+			slog.String("source_uuid", res.SourceUUID.String()),
+			slog.Int("chunks", res.Chunks),
+			slog.Int("failed", res.Failed))
+	}
+	logger.Warn("some books were indexed partially, run replay to resend failed batches",
+		slog.Int("books", len(partial)),
+		slog.String("dead_letter_path", cfg.DeadLetterPath))
+}
+
+// openState открывает файл состояния, если он задан в конфиге
+func openState(cfg *config.Config) (*state.Store, error) {
+	if cfg.StatePath == "" {
+		return nil, nil
+	}
+	st, err := state.Open(cfg.StatePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening state database: %w", err)
+	}
+	return st, nil
+}
+
+func isTarArchive(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".tar" || ext == ".tar.gz"
+}
+
+func processTarArchive(ctx context.Context, prs *parser.Parser, cfg *config.Config, resume bool, logger *slog.Logger) error {
+	file, err := os.Open(cfg.Volume)
+	if err != nil {
+		return fmt.Errorf("error opening tar file: %v", err)
+	}
+	defer file.Close()
+
+	var cp *checkpoint.Checkpoint
+	if cfg.CheckpointPath != "" {
+		archive, err := filepath.Abs(cfg.Volume)
+		if err != nil {
+			return err
+		}
+		if resume {
+			cp, err = checkpoint.Load(cfg.CheckpointPath, archive)
+			if err != nil {
+				return err
+			}
+			logger.Info("resuming tar archive",
+				slog.Int64("offset", cp.Offset),
+				slog.Int("completed", len(cp.Completed)),
+				slog.Int("pending", len(cp.Pending)))
+		} else {
+			cp = checkpoint.New(cfg.CheckpointPath, archive)
+		}
+	} else if resume {
+		return fmt.Errorf("--resume requires checkpoint_path in config")
+	}
+
+	return prs.ProcessTar(ctx, file, cfg.Concurrency, cp)
+}
+
+// Функция для рекурсивного поиска всех файлов книг в директории и поддиректориях
+// (кроме исключений и файлов метаданных)
+func findFiles(rootDir string) ([]os.DirEntry, []string, error) {
+	var files []os.DirEntry
+	var paths []string
+
+	err := filepath.WalkDir(rootDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Пропускаем директории, файлы из исключений (например, .gitignore)
+		// и файлы метаданных: они читаются вместе с книгами
+		if !d.IsDir() && d.Name() != ".gitignore" && !book.IsMetadataFile(d.Name()) {
+			files = append(files, d)
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return files, paths, nil
+}
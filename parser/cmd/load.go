@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/terratensor/library/parser/internal/storage/ndjson"
+	"github.com/terratensor/library/parser/internal/utils"
+)
+
+// runLoad загружает в Manticore выгрузку, записанную файловым хранилищем
+func runLoad(ctx context.Context, args []string) error {
+	fs := newFlagSet("load")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: load [flags] <file|dir>")
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	logger := setupLogger(cfg.Env)
+
+	files, err := ndjson.Files(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no ndjson files found in %s", fs.Arg(0))
+	}
+
+	client, err := newManticore(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	defer utils.Duration(utils.Track("Загрузка завершена за "))
+	var total int
+	for _, file := range files {
+		n := 0
+		err := ndjson.ReadLines(file, cfg.BatchSize, func(lines [][]byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := client.BulkLines(ctx, "load", lines); err != nil {
+				return err
+			}
+			n += len(lines)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error loading %s after %d lines: %w", file, n, err)
+		}
+		total += n
+		logger.Info("file loaded", slog.String("file", file), slog.Int("lines", n))
+	}
+
+	logger.Info("load completed", slog.Int("files", len(files)), slog.Int("lines", total))
+	return nil
+}
@@ -2,22 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
 	"log/slog"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strings"
 
 	"github.com/terratensor/library/parser/internal/config"
 	"github.com/terratensor/library/parser/internal/lib/logger/handlers/slogpretty"
 	"github.com/terratensor/library/parser/internal/lib/logger/sl"
 	"github.com/terratensor/library/parser/internal/library/entry"
-	"github.com/terratensor/library/parser/internal/metadata"
-	"github.com/terratensor/library/parser/internal/parser"
 	"github.com/terratensor/library/parser/internal/storage/manticore"
-	"github.com/terratensor/library/parser/internal/utils"
-	"github.com/terratensor/library/parser/internal/workerpool"
+	"github.com/terratensor/library/parser/internal/storage/ndjson"
 )
 
 const (
@@ -26,111 +24,219 @@ const (
 	envProd  = "prod"
 )
 
+// command подкоманда парсера
+type command struct {
+	name    string
+	args    string // описание позиционных аргументов для справки
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"index", "", "индексация тома или tar-архива", runIndex},
+		{"metadata", "", "обработка только метаданных (авторы, категории, заголовки)", runMetadata},
+		{"reindex", "<path>", "переиндексация одного файла", runReindex},
+		{"delete", "<uuid|source>", "удаление книги из индекса по UUID или имени файла", runDelete},
+		{"replay", "", "повторная отправка пакетов из очереди недоставленных", runReplay},
+		{"load", "<path>", "загрузка NDJSON-выгрузки файлового хранилища в Manticore", runLoad},
+		{"rebuild", "", "полная переиндексация в новую версию таблиц с переключением псевдонима", runRebuild},
+		{"rollback", "[version]", "переключение псевдонима на предыдущую версию таблиц", runRollback},
+		{"migrate", "", "проверка и миграция схемы таблиц Manticore", runMigrate},
+		{"stats", "", "статистика индекса и файла состояния", runStats},
+		{"verify", "", "сверка файла состояния с индексом", runVerify},
+		{"mapping", "[value...]", "правила сопоставления жанров и папок: сработавшие и несопоставленные значения", runMapping},
+		{"preview", "<file>", "предпросмотр разбиения файла на параграфы без записи в хранилище", runPreview},
+	}
+}
+
 func main() {
-	// Чтение конфиг-файла
-	cfg := config.MustLoad()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	logger := setupLogger(cfg.Env)
-	logger = logger.With(slog.String("env", cfg.Env)) // к каждому сообщению будет добавляться поле с информацией о текущем окружении
+	args := os.Args[1:]
 
-	logger.Debug("logger debug mode enabled")
+	// Без подкоманды сохраняем прежнее поведение: режим выбирается по metadata_only
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if err := runDefault(ctx, args); err != nil {
+			fatal("", err)
+		}
+		return
+	}
 
-	// Инициализация мета-процессора
-	metaCfg := metadata.Config{
-		GenresMapPath:  cfg.GenresMapPath,
-		FoldersMapPath: cfg.FoldersMapPath,
-		LogFilePath:    "metadata_errors.log",
-		Logger:         logger,
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
 	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(ctx, args[1:]); err != nil {
+				fatal(name, err)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-	metaProcessor, err := metadata.NewProcessor(metaCfg)
+// runDefault выбирает режим работы по полю metadata_only конфига
+func runDefault(ctx context.Context, args []string) error {
+	fs := newFlagSet("")
+	fs.Bool("resume", false, "продолжить обработку tar-архива с последней контрольной точки")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
 	if err != nil {
-		logger.Error("failed to initialize metadata processor", sl.Err(err))
-		os.Exit(1)
+		return err
+	}
+	if cfg.MetadataOnly {
+		return runMetadata(ctx, metadataArgs(fs))
 	}
-	defer metaProcessor.Close()
+	return runIndex(ctx, args)
+}
 
-	logger.Debug("initializing manticore client",
-		slog.String("index", cfg.Manticore.Index),
-		slog.String("host", cfg.Manticore.Host),
-		slog.String("port", cfg.Manticore.Port),
-	)
+// metadataArgs возвращает разобранные аргументы без флага resume,
+// которого у команды metadata нет
+func metadataArgs(fs *flag.FlagSet) []string {
+	var args []string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "resume" {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return append(args, fs.Args()...)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: library-parser <command> [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %-15s %s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nКаждая команда принимает -config (по умолчанию $%s)\n", config.ConfigPathEnv)
+	fmt.Fprintf(os.Stderr, "и флаги, переопределяющие поля конфига, например -batch_size=1000 -manticore.index=library.\n")
+	fmt.Fprintf(os.Stderr, "Без команды режим выбирается по metadata_only из конфига.\n")
+}
+
+func fatal(name string, err error) {
+	if name != "" {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	os.Exit(1)
+}
 
-	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+// newFlagSet создаёт набор флагов подкоманды с флагами переопределения конфига
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.String("config", os.Getenv(config.ConfigPathEnv), "путь до конфиг-файла")
+	config.RegisterFlags(fs)
+	return fs
+}
 
-	// Инициализация хранилища
-	manticoreClient, err := manticore.New(ctx, &cfg.Manticore)
+// loadConfig читает конфиг по флагу -config и применяет переопределения из флагов.
+// Вызывается после fs.Parse.
+func loadConfig(fs *flag.FlagSet) (*config.Config, error) {
+	path := fs.Lookup("config").Value.String()
+	if path == "" {
+		return nil, fmt.Errorf("config path is not set: use -config or %s", config.ConfigPathEnv)
+	}
+	cfg, err := config.Load(path)
 	if err != nil {
-		logger.Error("error creating manticore client", sl.Err(err))
-		os.Exit(1)
+		return nil, err
+	}
+	if err := config.ApplyFlags(fs, cfg); err != nil {
+		return nil, err
 	}
-	storage := entry.New(manticoreClient)
+	return cfg, nil
+}
 
-	// Инициализация парсера
-	prs := parser.NewParser(cfg, storage)
+// newStorage создаёт хранилище, выбранное в конфиге (storage.type). В режиме
+// writer.mode: async документы пишутся асинхронно общими пакетами.
+// Возвращаемую функцию нужно вызвать по завершении работы: она дописывает
+// очередь и буферы файлового хранилища.
+func newStorage(ctx context.Context, cfg *config.Config, logger *slog.Logger) (*entry.Entries, func(), error) {
+	backend, closeBackend, err := newBackend(ctx, cfg, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch cfg.Writer.Mode {
+	case "sync":
+		return entry.New(backend, cfg.Manticore.Index, cfg.Manticore.Version, cfg.Manticore.LanguageCodes(), cfg.BatchSize), closeBackend, nil
+	case "", "async":
+	default:
+		closeBackend()
+		return nil, nil, fmt.Errorf("unknown writer mode %q", cfg.Writer.Mode)
+	}
 
-	// Режим только метаданные
-	if cfg.MetadataOnly {
-		if err := processMetadata(ctx, metaProcessor, prs, cfg, logger); err != nil {
-			logger.Error("error processing metadata", sl.Err(err))
-			os.Exit(1)
-		}
-		return
+	store, closeWriter := newAsyncStorage(backend, cfg, logger)
+	closeAll := func() {
+		closeWriter()
+		closeBackend()
 	}
-	// Новый код для обработки tar-архивов
-	if isTarArchive(cfg.Volume) {
-		if err := processTarArchive(ctx, prs, cfg, logger); err != nil {
-			logger.Error("error processing tar archive", sl.Err(err))
-			os.Exit(1)
+	return entry.New(store, cfg.Manticore.Index, cfg.Manticore.Version, cfg.Manticore.LanguageCodes(), cfg.BatchSize), closeAll, nil
+}
+
+// newBackend создаёт бэкенд хранилища по storage.type
+func newBackend(ctx context.Context, cfg *config.Config, logger *slog.Logger) (entry.StorageInterface, func(), error) {
+	switch cfg.Storage.Type {
+	case "", "manticore":
+		client, err := newManticore(ctx, cfg, logger)
+		if err != nil {
+			return nil, nil, err
 		}
-	} else {
-		// Старый код для обработки обычных файлов
-		files, paths, err := findFiles(cfg.Volume)
+		return client, func() {}, nil
+	case "file":
+		sink, err := ndjson.New(&cfg.Storage.File, cfg.Manticore.Index, cfg.Manticore.LanguageCodes())
 		if err != nil {
-			logger.Error("error reading directory", sl.Err(err))
-			os.Exit(1)
+			return nil, nil, fmt.Errorf("error creating file storage: %w", err)
 		}
-
-		var allTask []*workerpool.Task
-		for n, file := range files {
-			task := workerpool.NewTask(func(data interface{}) error {
-				fileData := data.(struct {
-					file os.DirEntry
-					path string
-					n    int
-				})
-				fmt.Printf("Processing file %v\n", fileData.file.Name())
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-				}
-				err = prs.Parse(ctx, fileData.file, filepath.Dir(fileData.path))
-				if err != nil {
-					logger.Error("error processing file",
-						slog.String("filename", fileData.file.Name()),
-						sl.Err(err))
-					return err
-				}
-				return nil
-			}, struct {
-				file os.DirEntry
-				path string
-				n    int
-			}{file, paths[n], n})
-			allTask = append(allTask, task)
+		logger.Info("writing bulk documents to files", slog.String("dir", cfg.Storage.File.Dir))
+		closeSink := func() {
+			if err := sink.Close(); err != nil {
+				logger.Error("error closing file storage", sl.Err(err))
+			}
 		}
-		defer utils.Duration(utils.Track("Обработка завершена за "))
-		pool := workerpool.NewPool(allTask, cfg.Concurrency)
-		pool.Run()
+		return sink, closeSink, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage type %q", cfg.Storage.Type)
 	}
+}
+
+// newManticore подключается к Manticore. Если manticore.index — псевдоним,
+// созданный командой rebuild, запись идёт в таблицы текущей версии,
+// в том числе в таблицы каталога этой версии.
+func newManticore(ctx context.Context, cfg *config.Config, logger *slog.Logger) (*manticore.Client, error) {
+	logger.Debug("initializing manticore client",
+		slog.String("index", cfg.Manticore.Index),
+		slog.String("host", cfg.Manticore.Host),
+		slog.String("port", cfg.Manticore.Port),
+	)
 
-	// Сохраняем все модели перед выходом
-	// if err := prs.StoreModels(ctx, metaProcessor); err != nil {
-	// 	logger.Error("error storing models", sl.Err(err))
-	// }
+	tables, err := manticore.Connect(&cfg.Manticore).AliasTables(ctx, cfg.Manticore.Index)
+	if err != nil && !errors.Is(err, manticore.ErrNotAlias) {
+		return nil, fmt.Errorf("error resolving manticore index: %w", err)
+	}
+	if len(tables) > 0 {
+		logger.Debug("writing to live version of index",
+			slog.String("alias", cfg.Manticore.Index),
+			slog.String("table", tables[0]))
+		cfg.Manticore.Version = strings.TrimPrefix(tables[0], cfg.Manticore.Index)
+		cfg.Manticore.Index = tables[0]
+	}
 
-	log.Println("all files done")
+	manticoreClient, err := manticore.New(ctx, &cfg.Manticore, entry.Schemas(cfg.Manticore.Index, cfg.Manticore.Version, cfg.Manticore.LanguageCodes()))
+	if err != nil {
+		return nil, fmt.Errorf("error creating manticore client: %w", err)
+	}
+	return manticoreClient, nil
 }
 
 func setupLogger(env string) *slog.Logger {
@@ -147,8 +253,13 @@ func setupLogger(env string) *slog.Logger {
 		logger = slog.New(
 			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
 		)
+	default:
+		logger = slog.New(
+			slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
+		)
 	}
-	return logger
+	// к каждому сообщению будет добавляться поле с информацией о текущем окружении
+	return logger.With(slog.String("env", env))
 }
 
 func setupPrettySlog() *slog.Logger {
@@ -162,82 +273,3 @@ func setupPrettySlog() *slog.Logger {
 
 	return slog.New(handler)
 }
-
-// Новые вспомогательные функции
-func isTarArchive(path string) bool {
-	ext := filepath.Ext(path)
-	return ext == ".tar" || ext == ".tar.gz"
-}
-
-func processTarArchive(ctx context.Context, prs *parser.Parser, cfg *config.Config, logger *slog.Logger) error {
-	file, err := os.Open(cfg.Volume)
-	if err != nil {
-		return fmt.Errorf("error opening tar file: %v", err)
-	}
-	defer file.Close()
-
-	return prs.ProcessTar(ctx, file, cfg.Concurrency)
-}
-
-// Функция для рекурсивного поиска всех файлов в директории и поддиректориях (кроме исключений)
-func findFiles(rootDir string) ([]os.DirEntry, []string, error) {
-	var files []os.DirEntry
-	var paths []string
-
-	err := filepath.WalkDir(rootDir, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Пропускаем директории и файлы из исключений (например, .gitignore)
-		if !d.IsDir() && d.Name() != ".gitignore" {
-			files = append(files, d)
-			paths = append(paths, path)
-		}
-		return nil
-	})
-
-	if err != nil {
-		return nil, nil, err
-	}
-
-	return files, paths, nil
-}
-
-func processMetadata(ctx context.Context, metaProcessor *metadata.Processor, prs *parser.Parser, cfg *config.Config, logger *slog.Logger) error {
-	logger.Info("running in metadata-only mode")
-
-	files, paths, err := findFiles(cfg.Volume)
-	if err != nil {
-		logger.Error("error reading directory", sl.Err(err))
-		os.Exit(1)
-	}
-
-	for n, file := range files {
-		if err := prs.ProcessMetadataOnly(ctx, metaProcessor, file, filepath.Dir(paths[n])); err != nil {
-			logger.Error("error processing file metadata",
-				slog.String("filename", file.Name()),
-				sl.Err(err))
-		}
-	}
-
-	// Сохраняем отчеты
-	if err := metaProcessor.SaveDuplicatesReport("duplicates_report.txt"); err != nil {
-		logger.Error("failed to save duplicates report", sl.Err(err))
-	}
-
-	// Сохраняем модели в базу
-	if err := prs.StoreModels(ctx, metaProcessor); err != nil {
-		logger.Error("failed to store models", sl.Err(err))
-		os.Exit(1)
-	}
-
-	report := metaProcessor.GenerateReport()
-	logger.Info("metadata processing completed",
-		slog.Int("files_processed", len(report.Entries)),
-		slog.Int("authors", len(metaProcessor.GetAuthors())),
-		slog.Int("categories", len(metaProcessor.GetCategories())),
-		slog.Int("titles", len(metaProcessor.GetTitles())),
-		slog.Int("duplicates_found", len(report.Duplicates)))
-	return nil
-}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/library/mapping"
+)
+
+// runMapping показывает, какое правило сопоставления срабатывает для значений
+// из аргументов, а без аргументов — для жанров и папок всех файлов тома
+func runMapping(ctx context.Context, args []string) error {
+	fs := newFlagSet("mapping")
+	folders := fs.Bool("folders", false, "сопоставлять значения аргументов с правилами папок, а не жанров")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+
+	genreRules, err := mapping.LoadCSV(cfg.GenresMapPath)
+	if err != nil {
+		return err
+	}
+	folderRules, err := mapping.LoadYAML(cfg.FoldersMapPath)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		rules := genreRules
+		if *folders {
+			rules = folderRules
+		}
+		for _, value := range fs.Args() {
+			printMatch(rules.Map(value))
+		}
+		return nil
+	}
+
+	_, paths, err := findFiles(cfg.Volume)
+	if err != nil {
+		return fmt.Errorf("error reading directory: %w", err)
+	}
+	for _, path := range paths {
+		book.NewTitleList(path, genreRules, folderRules, nil)
+	}
+	fmt.Printf("files: %d\n", len(paths))
+	printFired("genre rules", genreRules)
+	printFired("folder rules", folderRules)
+	printUnmapped("unmapped genres", genreRules.Unmapped())
+	printUnmapped("unmapped folders", folderRules.Unmapped())
+	return nil
+}
+
+func printMatch(m mapping.Match) {
+	if m.Rule == nil {
+		fmt.Printf("%s: no rule\n", m.Value)
+		return
+	}
+	fmt.Printf("%s → %s (%s)\n", m.Value, strings.Join(m.Path, " "+mapping.PathSeparator+" "), m.Rule)
+}
+
+func printFired(title string, m *mapping.Mapper) {
+	fired := m.Fired()
+	rules := make([]*mapping.Rule, 0, len(fired))
+	for r := range fired {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if fired[rules[i]] != fired[rules[j]] {
+			return fired[rules[i]] > fired[rules[j]]
+		}
+		return rules[i].Source < rules[j].Source
+	})
+	fmt.Printf("%s fired: %d\n", title, len(rules))
+	for _, r := range rules {
+		fmt.Printf("  %6d  %s → %s\n", fired[r], r, r.Target)
+	}
+}
+
+func printUnmapped(title string, unmapped map[string]int) {
+	fmt.Printf("%s: %d\n", title, len(unmapped))
+	for _, v := range sortedByCount(unmapped) {
+		fmt.Printf("  %6d  %s\n", unmapped[v], v)
+	}
+}
+
+// reportUnmapped выводит в лог жанры, для которых не нашлось правила сопоставления
+func reportUnmapped(unmapped map[string]int, logger *slog.Logger) {
+	if len(unmapped) == 0 {
+		return
+	}
+	for _, genre := range sortedByCount(unmapped) {
+		logger.Warn("unmapped genre", slog.String("genre", genre), slog.Int("books", unmapped[genre]))
+	}
+	logger.Warn("some genres have no mapping rule, add them to genres_map_path",
+		slog.Int("genres", len(unmapped)))
+}
+
+// sortedByCount возвращает ключи по убыванию количества, при равенстве — по алфавиту
+func sortedByCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
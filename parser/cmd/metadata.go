@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/terratensor/library/parser/internal/lib/logger/sl"
+	"github.com/terratensor/library/parser/internal/metadata"
+	"github.com/terratensor/library/parser/internal/parser"
+	"github.com/terratensor/library/parser/internal/report"
+)
+
+// runMetadata обрабатывает только имена файлов и заполняет таблицы авторов,
+// категорий и заголовков без полного парсинга содержимого
+func runMetadata(ctx context.Context, args []string) error {
+	fs := newFlagSet("metadata")
+	lintReport := fs.String("lint", "filename_report.csv", "отчёт об именах файлов не по шаблону: CSV или JSON по расширению")
+	apply := fs.Bool("apply", false, "переименовать файлы не по шаблону в предложенные имена")
+	undoLog := fs.String("undo-log", "renames_undo.csv", "журнал переименований для отмены")
+	undo := fs.Bool("undo", false, "вернуть прежние имена файлов по журналу -undo-log и завершить работу")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+
+	logger := setupLogger(cfg.Env)
+	if *undo {
+		_, err := metadata.UndoRenames(*undoLog, logger)
+		return err
+	}
+	logger.Info("running in metadata-only mode")
+
+	// Инициализация мета-процессора
+	metaCfg := metadata.Config{
+		GenresMapPath:   cfg.GenresMapPath,
+		FoldersMapPath:  cfg.FoldersMapPath,
+		AliasesPath:     cfg.AliasesPath,
+		TitleSimilarity: cfg.Duplicates.TitleSimilarity,
+		LogFilePath:     "metadata_errors.log",
+		Logger:          logger,
+	}
+
+	metaProcessor, err := metadata.NewProcessor(metaCfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize metadata processor: %w", err)
+	}
+	defer metaProcessor.Close()
+
+	storage, closeStorage, err := newStorage(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+	prs := parser.NewParser(cfg, storage)
+
+	files, paths, err := findFiles(cfg.Volume)
+	if err != nil {
+		return fmt.Errorf("error reading directory: %w", err)
+	}
+
+	var failures []report.FileError
+	for n, file := range files {
+		if err := prs.ProcessMetadataOnly(ctx, metaProcessor, file, filepath.Dir(paths[n])); err != nil {
+			logger.Error("error processing file metadata",
+				slog.String("filename", file.Name()),
+				sl.Err(err))
+			failures = append(failures, report.FileError{Path: paths[n], Error: err.Error()})
+		}
+	}
+
+	// Сохраняем отчеты
+	r := metadataReport(metaProcessor, cfg, failures)
+	saveReport(r, cfg, logger)
+	if err := metaProcessor.SaveLintReport(*lintReport); err != nil {
+		logger.Error("failed to save filename lint report", sl.Err(err))
+	}
+
+	// Сохраняем модели в базу
+	if err := prs.StoreModels(ctx, metaProcessor); err != nil {
+		return fmt.Errorf("failed to store models: %w", err)
+	}
+
+	reportUnmapped(metaProcessor.UnmappedGenres(), logger)
+
+	if *apply {
+		if _, err := metaProcessor.ApplyRenames(*undoLog); err != nil {
+			return fmt.Errorf("failed to rename files: %w", err)
+		}
+	}
+
+	logger.Info("metadata processing completed",
+		slog.Int("files_processed", r.Summary.Books),
+		slog.Int("authors", len(metaProcessor.GetAuthors())),
+		slog.Int("categories", len(metaProcessor.GetCategories())),
+		slog.Int("titles", len(metaProcessor.GetTitles())),
+		slog.Int("duplicates_found", r.Summary.Duplicates),
+		slog.Int("nonconforming_filenames", r.Summary.InvalidFilenames))
+	return nil
+}
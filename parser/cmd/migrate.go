@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/storage/manticore"
+)
+
+// runMigrate сравнивает таблицы Manticore с ожидаемыми схемами и применяет миграции.
+// С -dry-run только выводит план.
+func runMigrate(ctx context.Context, args []string) error {
+	fs := newFlagSet("migrate")
+	dryRun := fs.Bool("dry-run", false, "только показать план миграции")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+
+	client := manticore.Connect(&cfg.Manticore)
+	plan, err := client.PlanMigrations(ctx, entry.Schemas(cfg.Manticore.Index, cfg.Manticore.Version, cfg.Manticore.LanguageCodes()))
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(plan.Versions))
+	for name := range plan.Versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("applied  %s version %d\n", name, plan.Versions[name])
+	}
+	for _, schema := range plan.Create {
+		fmt.Printf("create   %s version %d\n", schema.Name, schema.Version)
+	}
+	for _, change := range plan.Alter {
+		fmt.Printf("alter    %s\n", change.SQL())
+	}
+	for _, extra := range plan.Extra {
+		fmt.Printf("extra    %s\n", extra)
+	}
+	for _, problem := range plan.Incompatible {
+		fmt.Printf("conflict %s\n", problem)
+	}
+
+	if plan.Empty() {
+		fmt.Println("schema is up to date")
+		return nil
+	}
+	if *dryRun {
+		if len(plan.Incompatible) > 0 {
+			return fmt.Errorf("%d incompatible differences, see above", len(plan.Incompatible))
+		}
+		return nil
+	}
+	if err := client.Migrate(ctx, plan); err != nil {
+		return err
+	}
+	fmt.Println("migration applied")
+	return nil
+}
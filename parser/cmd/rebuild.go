@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/lib/logger/sl"
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/parser"
+	"github.com/terratensor/library/parser/internal/state"
+	"github.com/terratensor/library/parser/internal/storage/manticore"
+)
+
+// runRebuild полностью переиндексирует том в новую версию таблиц <index>_v<N>
+// и таблиц каталога <таблица>_v<N>, сверяет количество параграфов каждой книги
+// с записанным парсером и переключает на неё псевдонимы manticore.index и таблиц
+// каталога. Предыдущие версии остаются для отката.
+func runRebuild(ctx context.Context, args []string) error {
+	fs := newFlagSet("rebuild")
+	resume := fs.Bool("resume", false, "продолжить построение последней непереключённой версии")
+	noSwitch := fs.Bool("no-switch", false, "построить и проверить версию, не переключая псевдоним")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	logger := setupLogger(cfg.Env)
+
+	if cfg.Storage.Type != "" && cfg.Storage.Type != "manticore" {
+		return fmt.Errorf("rebuild requires storage.type manticore")
+	}
+
+	alias := cfg.Manticore.Index
+	client := manticore.Connect(&cfg.Manticore)
+	live, err := client.AliasTables(ctx, alias)
+	if err != nil && !errors.Is(err, manticore.ErrNotAlias) {
+		return err
+	}
+	if !*noSwitch {
+		if err := checkAliases(ctx, client, cfg); err != nil {
+			return err
+		}
+	}
+
+	versions, err := client.Versions(ctx, alias)
+	if err != nil {
+		return err
+	}
+	version := 1
+	if len(versions) > 0 {
+		version = versions[len(versions)-1] + 1
+	}
+	if *resume {
+		if len(versions) == 0 {
+			return fmt.Errorf("no version of %s to resume", alias)
+		}
+		version = versions[len(versions)-1]
+		if len(live) > 0 && live[0] == manticore.VersionTable(alias, version) {
+			return fmt.Errorf("version %d of %s is already live, nothing to resume", version, alias)
+		}
+	}
+
+	// Версия строится со своими файлами состояния и контрольной точки,
+	// текущие нужны псевдониму до переключения
+	table := manticore.VersionTable(alias, version)
+	build := *cfg
+	build.Manticore.Index = table
+	build.Manticore.Version = manticore.VersionSuffix(version)
+	build.StatePath = versionPath(cfg.StatePath, table)
+	build.CheckpointPath = versionPath(cfg.CheckpointPath, table)
+
+	logger.Info("building new version of index", slog.String("alias", alias), slog.String("table", table))
+	prs, err := indexVolume(ctx, &build, *resume, logger)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("rebuild of %s interrupted, continue with -resume: %w", table, ctx.Err())
+	}
+
+	if err := verifyBuild(ctx, &build, prs, *resume); err != nil {
+		return fmt.Errorf("%s is not switched: %w", table, err)
+	}
+	logger.Info("new version verified", slog.String("table", table))
+	if *noSwitch {
+		return nil
+	}
+
+	if err := switchVersion(ctx, client, cfg, live, version, logger); err != nil {
+		return err
+	}
+	return pruneVersions(ctx, client, cfg, version, logger)
+}
+
+// runRollback переключает псевдоним на предыдущую или указанную версию таблиц
+func runRollback(ctx context.Context, args []string) error {
+	fs := newFlagSet("rollback")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	logger := setupLogger(cfg.Env)
+
+	alias := cfg.Manticore.Index
+	client := manticore.Connect(&cfg.Manticore)
+	live, err := client.AliasTables(ctx, alias)
+	if err != nil {
+		return err
+	}
+	if len(live) == 0 {
+		return fmt.Errorf("%s is not an alias, nothing to roll back", alias)
+	}
+	versions, err := client.Versions(ctx, alias)
+	if err != nil {
+		return err
+	}
+
+	target := 0
+	if fs.NArg() > 0 {
+		target, err = strconv.Atoi(fs.Arg(0))
+		if err != nil || !slices.Contains(versions, target) {
+			return fmt.Errorf("version %q of %s not found, available: %v", fs.Arg(0), alias, versions)
+		}
+	} else {
+		// Ближайшая версия, предшествующая текущей
+		for _, v := range versions {
+			if manticore.VersionTable(alias, v) == live[0] {
+				break
+			}
+			target = v
+		}
+		if target == 0 {
+			return fmt.Errorf("no version of %s older than %s", alias, live[0])
+		}
+	}
+
+	return switchVersion(ctx, client, cfg, live, target, logger)
+}
+
+// verifyBuild сверяет количество параграфов каждой книги в таблицах версии
+// с количеством, записанным парсером. При продолжении построения книги прошлых
+// запусков берутся из файла состояния версии, а без него лишние книги в индексе
+// не проверяются.
+func verifyBuild(ctx context.Context, cfg *config.Config, prs *parser.Parser, resume bool) error {
+	if partial := prs.PartialBooks(); len(partial) > 0 {
+		return fmt.Errorf("%d books indexed partially, run replay and rebuild -resume", len(partial))
+	}
+
+	written := make(map[string]int)
+	st, err := openState(cfg)
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		err := st.ForEach(func(rec state.Record) error {
+			if rec.Status == state.StatusDone {
+				written[rec.SourceUUID] = rec.Chunks
+			}
+			return nil
+		})
+		st.Close()
+		if err != nil {
+			return err
+		}
+	}
+	for id, n := range prs.WrittenChunks() {
+		written[id] = n
+	}
+	checkUnexpected := !resume || st != nil
+
+	client := manticore.Connect(&cfg.Manticore)
+	counts, err := client.ChunkCounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	for id, n := range written {
+		if n > 0 && counts[id] != int64(n) {
+			fmt.Printf("mismatch %s: parsed %d chunks, index %d chunks\n", id, n, counts[id])
+			problems++
+		}
+	}
+	for id, n := range counts {
+		if _, ok := written[id]; !ok && checkUnexpected {
+			fmt.Printf("unexpected %s: %d chunks in index, not parsed by this build\n", id, n)
+			problems++
+		}
+	}
+	fmt.Printf("verified %d books of %s, %d problems\n", len(written), cfg.Manticore.Index, problems)
+	if problems > 0 {
+		return fmt.Errorf("verification failed: %d problems", problems)
+	}
+	return nil
+}
+
+// checkAliases проверяет, что manticore.index и таблицы каталога можно заменить
+// псевдонимами: обычную таблицу с тем же именем заменить нельзя
+func checkAliases(ctx context.Context, client *manticore.Client, cfg *config.Config) error {
+	var regular []string
+	for _, alias := range append([]string{cfg.Manticore.Index}, entry.CatalogueTables("")...) {
+		if _, err := client.AliasTables(ctx, alias); errors.Is(err, manticore.ErrNotAlias) {
+			regular = append(regular, alias)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(regular) > 0 {
+		return fmt.Errorf("%s are regular tables and cannot be replaced by aliases: "+
+			"point readers at a new manticore.index name or drop the tables after backup", strings.Join(regular, ", "))
+	}
+	return nil
+}
+
+// versionAliases возвращает псевдонимы версии version и таблицы, на которые они
+// указывают: manticore.index — на таблицы параграфов, таблицы каталога — на свои
+// таблицы этой версии
+func versionAliases(cfg *config.Config, version int) map[string][]string {
+	alias := cfg.Manticore.Index
+	suffix := manticore.VersionSuffix(version)
+	aliases := map[string][]string{
+		alias: entry.ContentTables(alias+suffix, cfg.Manticore.LanguageCodes()),
+	}
+	for _, name := range entry.CatalogueTables("") {
+		aliases[name] = []string{name + suffix}
+	}
+	return aliases
+}
+
+// switchVersion переключает псевдонимы на таблицы версии version и меняет местами
+// файлы состояния, чтобы инкрементальная индексация продолжала текущую версию
+func switchVersion(ctx context.Context, client *manticore.Client, cfg *config.Config, live []string, version int, logger *slog.Logger) error {
+	alias := cfg.Manticore.Index
+	table := manticore.VersionTable(alias, version)
+	if err := client.SwitchAliases(ctx, versionAliases(cfg, version)); err != nil {
+		return err
+	}
+	logger.Info("aliases switched", slog.String("alias", alias), slog.String("table", table))
+
+	if cfg.StatePath == "" {
+		return nil
+	}
+	if len(live) > 0 {
+		if err := renameIfExists(cfg.StatePath, versionPath(cfg.StatePath, live[0])); err != nil {
+			return err
+		}
+	}
+	return renameIfExists(versionPath(cfg.StatePath, table), cfg.StatePath)
+}
+
+// pruneVersions удаляет версии старше manticore.keep_versions последних
+func pruneVersions(ctx context.Context, client *manticore.Client, cfg *config.Config, live int, logger *slog.Logger) error {
+	alias := cfg.Manticore.Index
+	versions, err := client.Versions(ctx, alias)
+	if err != nil {
+		return err
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	keep := max(cfg.Manticore.KeepVersions, 1)
+	kept := 0
+	for _, v := range versions {
+		if v > live {
+			continue // строится другим запуском
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		table := manticore.VersionTable(alias, v)
+		tables := append(entry.ContentTables(table, cfg.Manticore.LanguageCodes()), entry.CatalogueTables(manticore.VersionSuffix(v))...)
+		for _, t := range tables {
+			if err := client.DropTable(ctx, t); err != nil {
+				return err
+			}
+		}
+		for _, path := range []string{versionPath(cfg.StatePath, table), versionPath(cfg.CheckpointPath, table)} {
+			if path != "" {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					logger.Error("error removing file of dropped version", slog.String("path", path), sl.Err(err))
+				}
+			}
+		}
+		logger.Info("old version dropped", slog.String("table", table))
+	}
+	return nil
+}
+
+// versionPath возвращает путь файла состояния или контрольной точки версии table
+func versionPath(path, table string) string {
+	if path == "" {
+		return ""
+	}
+	return path + "." + table
+}
+
+func renameIfExists(from, to string) error {
+	if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error moving state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/terratensor/library/parser/internal/deadletter"
+	"github.com/terratensor/library/parser/internal/lib/logger/sl"
+	"github.com/terratensor/library/parser/internal/state"
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// runReplay повторно отправляет в хранилище пакеты из очереди недоставленных.
+// Успешно отправленные записи удаляются, у остальных сохраняются только
+// отклонённые документы и последняя ошибка.
+func runReplay(ctx context.Context, args []string) error {
+	fs := newFlagSet("replay")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	logger := setupLogger(cfg.Env)
+
+	if cfg.DeadLetterPath == "" {
+		return fmt.Errorf("replay requires dead_letter_path in config")
+	}
+	queue, err := deadletter.Open(cfg.DeadLetterPath)
+	if err != nil {
+		return err
+	}
+	files, err := queue.List()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		logger.Info("dead letter queue is empty", slog.String("dir", cfg.DeadLetterPath))
+		return nil
+	}
+
+	entries, closeStorage, err := newStorage(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	st, err := openState(cfg)
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		defer st.Close()
+	}
+
+	stats, err := replay(ctx, queue, files, entries, st, logger)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("replay completed",
+		slog.Int("replayed", stats.replayed),
+		slog.Int("failed", stats.failed),
+		slog.Int("stale", stats.stale))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if stats.failed > 0 {
+		return fmt.Errorf("%d dead letter records could not be replayed", stats.failed)
+	}
+	return nil
+}
+
+// replayStats итоги replay
+type replayStats struct {
+	replayed, failed, stale int
+}
+
+// replay отправляет записи files очереди в w. st может быть nil,
+// тогда устаревшие записи не определяются и состояние не обновляется.
+func replay(ctx context.Context, queue *deadletter.Queue, files []string, w storage.Writer, st *state.Store, logger *slog.Logger) (replayStats, error) {
+	var stats replayStats
+
+	// Книги по путям файлов. Для книг из tar-архива записей состояния нет,
+	// такие записи очереди не считаются устаревшими.
+	var paths map[string]string
+	if st != nil {
+		paths = make(map[string]string)
+		err := st.ForEach(func(rec state.Record) error {
+			paths[rec.Path] = rec.SourceUUID
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	touched := make(map[string]struct{}) // книги, пакеты которых отправлялись
+	pending := make(map[string]struct{}) // книги, у которых остались неотправленные пакеты
+	for _, path := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		rec, err := queue.Load(path)
+		if err != nil {
+			logger.Error("error loading dead letter record", sl.Err(err))
+			stats.failed++
+			continue
+		}
+		id := rec.Book.SourceUUID
+
+		// Файл книги изменился, её параграфы уже заменены новой версией
+		if current, ok := paths[rec.Book.Path]; ok && rec.Book.Path != "" && current != id {
+			logger.Info("dropping stale dead letter record", slog.String("file", path), slog.String("source", rec.Book.Source))
+			if err := queue.Remove(path); err != nil {
+				return stats, err
+			}
+			stats.stale++
+			continue
+		}
+
+		touched[id] = struct{}{}
+		if err := w.Write(ctx, rec.Documents()); err != nil {
+			logger.Error("error replaying dead letter record",
+				slog.String("file", path),
+				slog.String("source", rec.Book.Source),
+				sl.Err(err))
+			var werr *storage.WriteError
+			if errors.As(err, &werr) {
+				if err := rec.SetDocuments(werr.Docs); err != nil {
+					return stats, err
+				}
+			}
+			rec.Attempts++
+			rec.Error = err.Error()
+			if err := queue.Update(path, rec); err != nil {
+				return stats, err
+			}
+			pending[id] = struct{}{}
+			stats.failed++
+			continue
+		}
+
+		if err := queue.Remove(path); err != nil {
+			return stats, err
+		}
+		stats.replayed++
+	}
+
+	// Книги, все пакеты которых отправлены, теперь проиндексированы полностью.
+	// После отмены часть записей не загружена, и их книги неизвестны, поэтому
+	// состояние не меняется: следующий запуск отметит книги сам.
+	if st != nil && ctx.Err() == nil {
+		var done []state.Record
+		err := st.ForEach(func(rec state.Record) error {
+			_, ok := touched[rec.SourceUUID]
+			_, left := pending[rec.SourceUUID]
+			if ok && !left && rec.Status == state.StatusPartial {
+				done = append(done, rec)
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+		for _, rec := range done {
+			rec.Status = state.StatusDone
+			rec.Error = ""
+			rec.IndexedAt = time.Now().Unix()
+			if err := st.Put(rec); err != nil {
+				return stats, err
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/deadletter"
+	"github.com/terratensor/library/parser/internal/state"
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// countWriter считает записанные документы по таблицам
+type countWriter map[string]int
+
+func (w countWriter) Write(ctx context.Context, docs []storage.Document) error {
+	for _, d := range docs {
+		w[d.Table]++
+	}
+	return nil
+}
+
+func TestReplayKeepsTarRecords(t *testing.T) {
+	queue, err := deadletter.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := state.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	volumeBook := filepath.Join(t.TempDir(), "Жанр_Автор — Книга.docx")
+	if err := st.Put(state.Record{Path: volumeBook, SourceUUID: "new", Status: state.StatusPartial}); err != nil {
+		t.Fatal(err)
+	}
+
+	books := []deadletter.Book{
+		{SourceUUID: "tar", Source: "Жанр_Автор — Из архива.docx", Path: "Жанр_Автор — Из архива.docx"}, // нет в состоянии
+		{SourceUUID: "old", Source: "Жанр_Автор — Книга.docx", Path: volumeBook},                        // прежняя версия файла
+		{SourceUUID: "new", Source: "Жанр_Автор — Книга.docx", Path: volumeBook},
+		{SourceUUID: "legacy", Source: "Жанр_Автор — Старая запись.docx"}, // запись без пути
+	}
+	for _, b := range books {
+		id := int64(1)
+		docs := []storage.Document{{Table: "library_" + b.SourceUUID, ID: &id, Doc: map[string]string{"content": "текст"}}}
+		rec, err := deadletter.NewRecord(b, docs, errors.New("timeout"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := queue.Put(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := queue.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := countWriter{}
+	stats, err := replay(context.Background(), queue, files, w, st, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.replayed != 3 || stats.stale != 1 || stats.failed != 0 {
+		t.Errorf("stats = %+v, want 3 replayed and 1 stale", stats)
+	}
+	for _, table := range []string{"library_tar", "library_new", "library_legacy"} {
+		if w[table] != 1 {
+			t.Errorf("%s: %d documents written, want 1", table, w[table])
+		}
+	}
+	if w["library_old"] != 0 {
+		t.Error("record of the previous file version was replayed")
+	}
+	if rec, _, err := st.Get(volumeBook); err != nil || rec.Status != state.StatusDone {
+		t.Errorf("volume book status %q, %v, want done", rec.Status, err)
+	}
+}
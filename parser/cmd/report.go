@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/lib/logger/sl"
+	"github.com/terratensor/library/parser/internal/metadata"
+	"github.com/terratensor/library/parser/internal/report"
+)
+
+// saveReport записывает отчёт о запуске в report_dir
+func saveReport(r *report.Report, cfg *config.Config, logger *slog.Logger) {
+	if err := report.Save(cfg.ReportDir, r, logger); err != nil {
+		logger.Error("failed to save report", sl.Err(err))
+	}
+}
+
+// metadataReport собирает отчёт команды metadata: статистики содержимого
+// в нём нет, только разобранные имена файлов
+func metadataReport(mp *metadata.Processor, cfg *config.Config, failures []report.FileError) *report.Report {
+	r := &report.Report{
+		Command:          "metadata",
+		Volume:           cfg.Volume,
+		Duplicates:       mp.Duplicates(),
+		UnmappedGenres:   report.Genres(mp.UnmappedGenres()),
+		InvalidFilenames: mp.Lints(),
+		Errors:           failures,
+	}
+	for path, tl := range mp.Entries() {
+		b := report.BookStats{
+			Path:   path,
+			Genre:  tl.Genre,
+			Author: tl.Author,
+			Title:  tl.Title,
+			Year:   tl.Year,
+			Volume: tl.Volume,
+			Format: strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."),
+		}
+		if info, err := os.Stat(path); err == nil {
+			b.FileSize = info.Size()
+		}
+		r.Books = append(r.Books, b)
+	}
+	return r
+}
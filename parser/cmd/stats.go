@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/state"
+)
+
+// runStats выводит статистику индекса и файла состояния
+func runStats(ctx context.Context, args []string) error {
+	fs := newFlagSet("stats")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	logger := setupLogger(cfg.Env)
+
+	client, err := newManticore(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	chunks, err := client.Count(ctx, strings.Join(client.ContentTables(), ", "), "")
+	if err != nil {
+		return err
+	}
+	books, err := client.CountBooks(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("index %s:\n", strings.Join(client.ContentTables(), ", "))
+	fmt.Printf("  books:  %d\n", books)
+	fmt.Printf("  chunks: %d\n", chunks)
+
+	for _, table := range entry.CatalogueTables(cfg.Manticore.Version) {
+		n, err := client.Count(ctx, table, "")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %s: %d\n", table, n)
+	}
+
+	st, err := openState(cfg)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return nil
+	}
+	defer st.Close()
+
+	statuses := make(map[string]int)
+	var files, stateChunks int
+	err = st.ForEach(func(rec state.Record) error {
+		files++
+		statuses[rec.Status]++
+		stateChunks += rec.Chunks
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("state %s:\n", cfg.StatePath)
+	fmt.Printf("  files:  %d\n", files)
+	fmt.Printf("  chunks: %d\n", stateChunks)
+	keys := make([]string, 0, len(statuses))
+	for status := range statuses {
+		keys = append(keys, status)
+	}
+	sort.Strings(keys)
+	for _, status := range keys {
+		fmt.Printf("  %s: %d\n", status, statuses[status])
+	}
+	return nil
+}
+
+// runVerify сверяет количество параграфов каждой книги в файле состояния и в индексе
+func runVerify(ctx context.Context, args []string) error {
+	fs := newFlagSet("verify")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	logger := setupLogger(cfg.Env)
+
+	st, err := openState(cfg)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return fmt.Errorf("verify requires state_path in config")
+	}
+	defer st.Close()
+
+	client, err := newManticore(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	var records []state.Record
+	err = st.ForEach(func(rec state.Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	counts, err := client.ChunkCounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	known := make(map[string]struct{}, len(records))
+	for _, rec := range records {
+		known[rec.SourceUUID] = struct{}{}
+		if rec.Status != state.StatusDone {
+			continue
+		}
+		if n := counts[rec.SourceUUID]; n != int64(rec.Chunks) {
+			fmt.Printf("mismatch %s: state %d chunks, index %d chunks (%s)\n", rec.SourceUUID, rec.Chunks, n, rec.Path)
+			problems++
+		}
+	}
+
+	orphans := make([]string, 0)
+	for id := range counts {
+		if _, ok := known[id]; !ok {
+			orphans = append(orphans, id)
+		}
+	}
+	sort.Strings(orphans)
+	for _, id := range orphans {
+		fmt.Printf("orphan %s: %d chunks in index, not in state\n", id, counts[id])
+		problems++
+	}
+
+	fmt.Printf("verified %d files, %d problems\n", len(records), problems)
+	if problems > 0 {
+		return fmt.Errorf("verification failed: %d problems", problems)
+	}
+	return nil
+}
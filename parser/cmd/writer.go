@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/lib/logger/sl"
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/storage/async"
+)
+
+// asyncStorage пишет документы через асинхронный writer, удаления выполняет напрямую
+type asyncStorage struct {
+	entry.Deleter
+	*async.Writer
+}
+
+// newAsyncStorage запускает асинхронный writer поверх бэкенда и периодически
+// пишет в лог его статистику. Возвращаемая функция дописывает очередь.
+func newAsyncStorage(backend entry.StorageInterface, cfg *config.Config, logger *slog.Logger) (entry.StorageInterface, func()) {
+	w := async.New(backend, &cfg.Writer, cfg.BatchSize)
+	logger.Debug("async writer started",
+		slog.Int("flushers", cfg.Writer.Flushers),
+		slog.Int("queue_size", cfg.Writer.QueueSize))
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Duration(max(cfg.Writer.StatsIntervalMs, 1000)) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				logWriterStats(logger, "writer stats", w.Stats())
+			}
+		}
+	}()
+
+	closeWriter := func() {
+		close(stop)
+		<-stopped
+		// Очередь дописывается и после отмены контекста команды, чтобы не потерять принятые параграфы
+		if err := w.Close(context.Background()); err != nil {
+			logger.Error("error draining async writer", sl.Err(err))
+		}
+		logWriterStats(logger, "writer drained", w.Stats())
+	}
+	return asyncStorage{Deleter: backend, Writer: w}, closeWriter
+}
+
+// Authors читает строки авторов из бэкенда напрямую, см. entry.AuthorReader
+func (s asyncStorage) Authors(ctx context.Context, ids []int64) ([]entry.Author, error) {
+	if r, ok := s.Deleter.(entry.AuthorReader); ok {
+		return r.Authors(ctx, ids)
+	}
+	return nil, nil
+}
+
+func logWriterStats(logger *slog.Logger, msg string, st async.Stats) {
+	logger.Info(msg,
+		slog.Int64("submitted", st.Submitted),
+		slog.Int64("written", st.Written),
+		slog.Int64("failed", st.Failed),
+		slog.Int64("batches", st.Batches),
+		slog.Int64("bytes", st.Bytes),
+		slog.Int("queued", st.Queued),
+		slog.Duration("flush_time", st.FlushTime))
+}
@@ -0,0 +1,218 @@
+// Package checkpoint хранит прогресс потоковой обработки tar-архива,
+// чтобы прерванный запуск можно было продолжить с места остановки.
+//
+// Члены архива обрабатываются параллельно и завершаются не по порядку, поэтому
+// сохраняется «водяная метка» Offset — смещение, до которого все члены обработаны,
+// а также завершённые члены за её пределами и начатые, но не завершённые члены.
+// Члены, обработка которых завершилась ошибкой, запоминаются отдельно и при
+// продолжении не повторяются.
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Member член tar-архива
+type Member struct {
+	Name       string `json:"name"`
+	Offset     int64  `json:"offset"` // смещение данных члена в архиве
+	End        int64  `json:"end"`    // смещение следующего заголовка
+	SourceUUID string `json:"source_uuid,omitempty"`
+}
+
+type Checkpoint struct {
+	Archive   string            `json:"archive"`
+	Offset    int64             `json:"offset"`    // все члены до этого смещения обработаны
+	Completed map[string]Member `json:"completed"` // завершённые члены после Offset
+	Pending   map[string]Member `json:"pending"`   // начатые, но не завершённые члены
+	Failed    map[string]Member `json:"failed"`    // члены, обработка которых завершилась ошибкой
+
+	path  string
+	mu    sync.Mutex
+	order []*item // члены после Offset в порядке следования в архиве
+}
+
+type item struct {
+	member Member
+	done   bool
+}
+
+// New создаёт пустую контрольную точку для архива
+func New(path, archive string) *Checkpoint {
+	return &Checkpoint{
+		Archive:   archive,
+		Completed: make(map[string]Member),
+		Pending:   make(map[string]Member),
+		Failed:    make(map[string]Member),
+		path:      path,
+	}
+}
+
+// Load читает контрольную точку из файла. Если файла нет или он относится
+// к другому архиву, возвращается пустая контрольная точка.
+func Load(path, archive string) (*Checkpoint, error) {
+	const op = "checkpoint.Load"
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(path, archive), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	cp := New(path, archive)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if cp.Archive != archive {
+		return New(path, archive), nil
+	}
+	if cp.Completed == nil {
+		cp.Completed = make(map[string]Member)
+	}
+	if cp.Pending == nil {
+		cp.Pending = make(map[string]Member)
+	}
+	if cp.Failed == nil {
+		cp.Failed = make(map[string]Member)
+	}
+	return cp, nil
+}
+
+// IsCompleted сообщает, что член уже обработан в одном из предыдущих запусков,
+// в том числе с ошибкой: повторная попытка завершилась бы так же
+func (cp *Checkpoint) IsCompleted(name string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if _, ok := cp.Failed[name]; ok {
+		return true
+	}
+	_, ok := cp.Completed[name]
+	return ok
+}
+
+// TakePending возвращает начатые, но не завершённые члены и забывает о них.
+// Вызывающий обязан удалить частично записанные данные этих книг.
+func (cp *Checkpoint) TakePending() []Member {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	members := make([]Member, 0, len(cp.Pending))
+	for _, m := range cp.Pending {
+		members = append(members, m)
+	}
+	cp.Pending = make(map[string]Member)
+	return members
+}
+
+// Skip отмечает член, который не требует обработки (не книга или уже обработан)
+func (cp *Checkpoint) Skip(m Member) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.order = append(cp.order, &item{member: m, done: true})
+	cp.advance()
+}
+
+// Begin отмечает начало обработки члена и сразу сохраняет контрольную точку,
+// чтобы после сбоя можно было удалить частично записанную книгу.
+func (cp *Checkpoint) Begin(m Member) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.order = append(cp.order, &item{member: m})
+	cp.Pending[m.Name] = m
+	return cp.save()
+}
+
+// Done отмечает успешное завершение обработки члена
+func (cp *Checkpoint) Done(name string) error {
+	return cp.finish(name, cp.Completed)
+}
+
+// Fail отмечает член, обработка которого завершилась ошибкой. Член считается
+// обработанным и при продолжении не повторяется, но остаётся в списке Failed.
+func (cp *Checkpoint) Fail(name string) error {
+	return cp.finish(name, cp.Failed)
+}
+
+// finish отмечает завершение обработки члена и запоминает его в list
+func (cp *Checkpoint) finish(name string, list map[string]Member) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for _, it := range cp.order {
+		if it.member.Name == name && !it.done {
+			it.done = true
+			list[name] = it.member
+			break
+		}
+	}
+	delete(cp.Pending, name)
+	cp.advance()
+	return cp.save()
+}
+
+// Save сохраняет контрольную точку
+func (cp *Checkpoint) Save() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	return cp.save()
+}
+
+// Remove удаляет файл контрольной точки после полной обработки архива
+func (cp *Checkpoint) Remove() error {
+	err := os.Remove(cp.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// advance сдвигает Offset за непрерывный префикс обработанных членов
+func (cp *Checkpoint) advance() {
+	n := 0
+	for ; n < len(cp.order) && cp.order[n].done; n++ {
+		cp.Offset = cp.order[n].member.End
+		delete(cp.Completed, cp.order[n].member.Name)
+	}
+	cp.order = cp.order[n:]
+}
+
+// save атомарно записывает контрольную точку через временный файл
+func (cp *Checkpoint) save() error {
+	const op = "checkpoint.save"
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tmp := cp.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := os.Rename(tmp, cp.path); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
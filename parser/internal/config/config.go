@@ -1,35 +1,112 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sort"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Env            string    `yaml:"env" env-default:"development"`
-	Concurrency    int       `yaml:"concurrency" env-default:"12"`
-	MetadataOnly   bool      `yaml:"metadata_only"` // только метаданные, будет обрабатывать только имена файлов и создавать записи в таблицах авторов, категорий и заголовков без полного парсинга содержимого.
-	Volume         string    `yaml:"volume" env-default:"./volume"`
-	GenresMapPath  string    `yaml:"genres_map_path" env-default:"./config/genres_map.csv"`
-	FoldersMapPath string    `yaml:"folders_map_path" env-default:"./config/folders_map.yaml"`
-	Manticore      Manticore `yaml:"manticore"`
-	BatchSize      int       `yaml:"batch_size" env-default:"3000"`
-	MinParSize     int       `yaml:"min_par_size" env-default:"300"`
-	OptParSize     int       `yaml:"opt_par_size" env-default:"1800"`
-	MaxParSize     int       `yaml:"max_par_size" env-default:"3500"`
-	BrokenDocxMode bool      `yaml:"broken_docx_mode" env-default:"false"`
-	PDFMode        bool      `yaml:"pdf_mode" env-default:"false"`
-	EPUBMode       bool      `yaml:"epub_mode" env-default:"false"`
-	Filters        Filters   `yaml:"filters"`
+	Env            string     `yaml:"env" env-default:"development"`
+	Concurrency    int        `yaml:"concurrency" env-default:"12"`
+	MetadataOnly   bool       `yaml:"metadata_only"` // только метаданные, будет обрабатывать только имена файлов и создавать записи в таблицах авторов, категорий и заголовков без полного парсинга содержимого.
+	Volume         string     `yaml:"volume" env-default:"./volume"`
+	GenresMapPath  string     `yaml:"genres_map_path" env-default:"./config/genres_map.csv"`
+	FoldersMapPath string     `yaml:"folders_map_path" env-default:"./config/folders_map.yaml"`
+	AliasesPath    string     `yaml:"aliases_path" env-default:"./config/aliases.yaml"` // псевдонимы и варианты написания имён авторов
+	Manticore      Manticore  `yaml:"manticore"`
+	Storage        Storage    `yaml:"storage"`
+	Writer         Writer     `yaml:"writer"`
+	BatchSize      int        `yaml:"batch_size" env-default:"3000"`
+	MinParSize     int        `yaml:"min_par_size" env-default:"300"`
+	OptParSize     int        `yaml:"opt_par_size" env-default:"1800"`
+	MaxParSize     int        `yaml:"max_par_size" env-default:"3500"`
+	BrokenDocxMode bool       `yaml:"broken_docx_mode" env-default:"false"`
+	PDFMode        bool       `yaml:"pdf_mode" env-default:"false"`
+	EPUBMode       bool       `yaml:"epub_mode" env-default:"false"`
+	Filters        Filters    `yaml:"filters"`
+	Duplicates     Duplicates `yaml:"duplicates"`
+	StatePath      string     `yaml:"state_path"`                         // файл состояния инкрементальной индексации, если пусто — каждый запуск обрабатывает весь том
+	CheckpointPath string     `yaml:"checkpoint_path"`                    // контрольная точка обработки tar-архива, если пусто — продолжение невозможно
+	DeadLetterPath string     `yaml:"dead_letter_path"`                   // каталог пакетов, которые не удалось записать, если пусто — они только логируются
+	ReportDir      string     `yaml:"report_dir" env-default:"./reports"` // каталог отчётов о запуске: JSON, CSV по разделам и HTML
 }
 
 type Manticore struct {
-	Engine string `yaml:"engine" env-default:"rowwise"`
-	Index  string `yaml:"index" env-default:"library"`
-	Host   string `yaml:"host" env-default:"localhost"`
-	Port   string `yaml:"port" env-default:"9312"`
+	Engine     string `yaml:"engine" env-default:"rowwise"`
+	Index      string `yaml:"index" env-default:"library"`
+	Host       string `yaml:"host" env-default:"localhost"`
+	Port       string `yaml:"port" env-default:"9312"`
+	MaxRetries int    `yaml:"max_retries" env-default:"10"` // повторы bulk-запроса при временных ошибках
+	Migrate    string `yaml:"migrate" env-default:"auto"`   // auto — применять миграции при запуске, check — только проверять схему
+	// KeepVersions сколько версий таблиц, построенных командой rebuild, хранить
+	// включая текущую, предыдущие нужны для отката командой rollback
+	KeepVersions int `yaml:"keep_versions" env-default:"2"`
+	// Version суффикс версии таблиц, построенной командой rebuild, например _v3.
+	// Его получают и таблицы каталога. Задаётся командами по псевдониму, не конфигом.
+	Version string `yaml:"-"`
+	// Settings параметры создания всех таблиц (morphology, charset_table, stopwords,
+	// wordforms, exceptions и т.д.) поверх значений по умолчанию
+	Settings TableSettings `yaml:"settings"`
+	// Tables параметры отдельных таблиц по имени, поверх Settings
+	Tables map[string]TableSettings `yaml:"tables"`
+	// Languages языки, параграфы которых пишутся в отдельные таблицы <index>_<язык>,
+	// и параметры этих таблиц поверх параметров основной
+	Languages map[string]TableSettings `yaml:"languages"`
+}
+
+// TableSettings параметры CREATE TABLE в синтаксисе Manticore, например
+// morphology: "lemmatize_ru_all, lemmatize_de_all"
+type TableSettings map[string]string
+
+// LanguageCodes возвращает языки с отдельными таблицами параграфов в порядке сортировки
+func (m *Manticore) LanguageCodes() []string {
+	codes := make([]string, 0, len(m.Languages))
+	for code := range m.Languages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Storage выбор хранилища, в которое пишутся документы
+type Storage struct {
+	Type string   `yaml:"type" env-default:"manticore"` // manticore или file
+	File FileSink `yaml:"file"`
+}
+
+// FileSink выгрузка bulk-документов в NDJSON-файлы без подключения к Manticore
+type FileSink struct {
+	Dir       string `yaml:"dir" env-default:"./export"`
+	Gzip      bool   `yaml:"gzip" env-default:"false"`
+	ShardSize int    `yaml:"shard_size" env-default:"0"` // документов в одном файле, 0 — без разбиения
+}
+
+// Writer асинхронная запись документов: парсеры ставят параграфы в очередь,
+// а отдельные горутины пишут их в хранилище пакетами из разных книг.
+// cleanenv подставляет env-default вместо нулевых значений, поэтому
+// синхронная запись включается режимом, а не нулём флашеров.
+type Writer struct {
+	Mode            string `yaml:"mode" env-default:"async"`              // async или sync — запись из каждого парсера
+	Flushers        int    `yaml:"flushers" env-default:"2"`              // параллельных запросов записи
+	QueueSize       int    `yaml:"queue_size" env-default:"20000"`        // документов в очереди, при заполнении парсеры ждут
+	BatchSize       int    `yaml:"batch_size" env-default:"0"`            // документов в пакете, 0 — как batch_size
+	BatchBytes      int    `yaml:"batch_bytes" env-default:"8388608"`     // байт в пакете
+	FlushIntervalMs int    `yaml:"flush_interval_ms" env-default:"1000"`  // неполный пакет отправляется не реже этого интервала
+	StatsIntervalMs int    `yaml:"stats_interval_ms" env-default:"30000"` // как часто писать в лог статистику записи
+}
+
+// Duplicates поиск дубликатов книг
+// Сходство содержимого оценивается по сигнатурам книг и параграфов, см. пакет similarity.
+type Duplicates struct {
+	TitleSimilarity    int    `yaml:"title_similarity" env-default:"85"`    // порог сходства названий в процентах, 100 — только совпадающие после нормализации
+	ContentSimilarity  int    `yaml:"content_similarity" env-default:"90"`  // порог сходства содержимого книг в процентах для отчёта о дубликатах
+	PartialContainment int    `yaml:"partial_containment" env-default:"50"` // доля параграфов меньшей книги в другой в процентах для отчёта о частичных копиях
+	Policy             string `yaml:"policy" env-default:"index"`           // index — индексировать все книги, skip — не индексировать почти точные копии
+	SkipSimilarity     int    `yaml:"skip_similarity" env-default:"98"`     // порог сходства содержимого в процентах для policy: skip
 }
 
 type Filters struct {
@@ -37,16 +114,29 @@ type Filters struct {
 	CutBase64Recursive bool `yaml:"cut_base64_recursive" env-default:"false"`
 }
 
+// ConfigPathEnv переменная окружения с путём до конфиг-файла по умолчанию
+const ConfigPathEnv = "LIBRARY_CONFIG_PATH"
+
 func MustLoad() *Config {
-	// Получаем путь до конфиг-файла из env-переменной CONFIG_PATH
-	configPath := os.Getenv("LIBRARY_CONFIG_PATH")
+	// Получаем путь до конфиг-файла из env-переменной LIBRARY_CONFIG_PATH
+	configPath := os.Getenv(ConfigPathEnv)
 	if configPath == "" {
 		log.Fatal("LIBRARY_CONFIG_PATH environment variable is not set")
 	}
 
+	cfg, err := Load(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return cfg
+}
+
+// Load читает конфиг-файл по указанному пути
+func Load(configPath string) (*Config, error) {
 	// Проверяем существование конфиг-файла
 	if _, err := os.Stat(configPath); err != nil {
-		log.Fatalf("error opening config file: %s", err)
+		return nil, fmt.Errorf("error opening config file: %s", err)
 	}
 
 	var cfg Config
@@ -54,8 +144,8 @@ func MustLoad() *Config {
 	// Читаем конфиг-файл и заполняем нашу структуру
 	err := cleanenv.ReadConfig(configPath, &cfg)
 	if err != nil {
-		log.Fatalf("error reading config file: %s", err)
+		return nil, fmt.Errorf("error reading config file: %s", err)
 	}
 
-	return &cfg
+	return &cfg, nil
 }
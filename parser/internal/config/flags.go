@@ -0,0 +1,103 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// fieldFlag флаг командной строки, переопределяющий поле Config.
+// Имя флага совпадает с путём поля в yaml, вложенные поля разделяются точкой,
+// например -batch_size или -manticore.index.
+type fieldFlag struct {
+	path  []int
+	kind  reflect.Kind
+	value string
+}
+
+func (f *fieldFlag) String() string { return f.value }
+
+func (f *fieldFlag) Set(s string) error {
+	if err := parseValue(f.kind, s, reflect.New(kindType(f.kind)).Elem()); err != nil {
+		return err
+	}
+	f.value = s
+	return nil
+}
+
+// IsBoolFlag позволяет писать -pdf_mode вместо -pdf_mode=true
+func (f *fieldFlag) IsBoolFlag() bool { return f.kind == reflect.Bool }
+
+// RegisterFlags регистрирует флаги для всех полей Config.
+// Значения применяются поверх прочитанного конфига функцией ApplyFlags.
+func RegisterFlags(fs *flag.FlagSet) {
+	walkFields(reflect.TypeOf(Config{}), nil, "", func(name string, path []int, kind reflect.Kind) {
+		fs.Var(&fieldFlag{path: path, kind: kind}, name, fmt.Sprintf("переопределяет %q из конфига", name))
+	})
+}
+
+// ApplyFlags записывает в cfg значения явно заданных флагов
+func ApplyFlags(fs *flag.FlagSet, cfg *Config) error {
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		ff, ok := f.Value.(*fieldFlag)
+		if !ok || err != nil {
+			return
+		}
+		field := reflect.ValueOf(cfg).Elem().FieldByIndex(ff.path)
+		if e := parseValue(ff.kind, ff.value, field); e != nil {
+			err = fmt.Errorf("flag -%s: %w", f.Name, e)
+		}
+	})
+	return err
+}
+
+func walkFields(t reflect.Type, index []int, prefix string, fn func(name string, path []int, kind reflect.Kind)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := append(append([]int{}, index...), i)
+		switch f.Type.Kind() {
+		case reflect.Struct:
+			walkFields(f.Type, path, prefix+name+".", fn)
+		case reflect.String, reflect.Int, reflect.Bool:
+			fn(prefix+name, path, f.Type.Kind())
+		}
+	}
+}
+
+func kindType(kind reflect.Kind) reflect.Type {
+	switch kind {
+	case reflect.Int:
+		return reflect.TypeOf(0)
+	case reflect.Bool:
+		return reflect.TypeOf(false)
+	default:
+		return reflect.TypeOf("")
+	}
+}
+
+func parseValue(kind reflect.Kind, s string, dst reflect.Value) error {
+	switch kind {
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		dst.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		dst.SetBool(b)
+	default:
+		dst.SetString(s)
+	}
+	return nil
+}
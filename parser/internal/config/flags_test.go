@@ -0,0 +1,46 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestApplyFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs)
+
+	err := fs.Parse([]string{"-batch_size=100", "-manticore.index=library_test", "-pdf_mode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{BatchSize: 5000, Concurrency: 12, Manticore: Manticore{Index: "library", Host: "localhost"}}
+	if err := ApplyFlags(fs, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.BatchSize != 100 {
+		t.Errorf("BatchSize = %v, want 100", cfg.BatchSize)
+	}
+	if cfg.Manticore.Index != "library_test" {
+		t.Errorf("Manticore.Index = %v, want library_test", cfg.Manticore.Index)
+	}
+	if !cfg.PDFMode {
+		t.Error("PDFMode = false, want true")
+	}
+	// Не заданные флаги не меняют значения из конфига
+	if cfg.Concurrency != 12 || cfg.Manticore.Host != "localhost" {
+		t.Errorf("unset flags changed config: %+v", cfg)
+	}
+}
+
+func TestApplyFlagsInvalid(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs)
+
+	if err := fs.Parse([]string{"-batch_size=many"}); err == nil {
+		t.Error("expected error for non-numeric batch_size")
+	}
+}
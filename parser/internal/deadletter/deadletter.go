@@ -0,0 +1,191 @@
+// Package deadletter хранит пакеты документов, которые не удалось записать в хранилище.
+// Каждый пакет сохраняется отдельным JSON-файлом вместе с ошибкой и метаданными книги,
+// команда replay позже отправляет их повторно.
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// Book метаданные книги, параграфы которой попали в очередь
+type Book struct {
+	SourceUUID string `json:"source_uuid"`
+	Source     string `json:"source"`
+	Path       string `json:"path,omitempty"` // путь книги в каталоге: абсолютный для файлов тома, имя в архиве для tar
+	Genre      string `json:"genre"`
+	Author     string `json:"author"`
+	Title      string `json:"title"`
+}
+
+// Doc документ в том виде, в котором его передали хранилищу
+type Doc struct {
+	Table string          `json:"table"`
+	ID    *int64          `json:"id,omitempty"`
+	Doc   json.RawMessage `json:"doc"`
+}
+
+// Record пакет недописанных документов
+type Record struct {
+	Book      Book   `json:"book"`
+	Error     string `json:"error"`
+	Attempts  int    `json:"attempts"` // сколько раз пакет пытались записать
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+	Docs      []Doc  `json:"docs"`
+}
+
+// NewRecord создаёт запись очереди из документов, которые вернуло хранилище
+func NewRecord(book Book, docs []storage.Document, cause error) (*Record, error) {
+	now := time.Now().Unix()
+	rec := &Record{
+		Book:      book,
+		Error:     cause.Error(),
+		Attempts:  1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := rec.SetDocuments(docs); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// SetDocuments заменяет документы записи, например оставляя только отклонённые при повторной отправке
+func (r *Record) SetDocuments(docs []storage.Document) error {
+	out := make([]Doc, len(docs))
+	for i, d := range docs {
+		data, err := json.Marshal(d.Doc)
+		if err != nil {
+			return err
+		}
+		out[i] = Doc{Table: d.Table, ID: d.ID, Doc: data}
+	}
+	r.Docs = out
+	return nil
+}
+
+// Documents возвращает документы записи для повторной отправки
+func (r *Record) Documents() []storage.Document {
+	docs := make([]storage.Document, len(r.Docs))
+	for i, d := range r.Docs {
+		docs[i] = storage.Document{Table: d.Table, ID: d.ID, Doc: d.Doc}
+	}
+	return docs
+}
+
+// Queue каталог с записями очереди
+type Queue struct {
+	dir string
+	seq atomic.Int64
+}
+
+// Open открывает каталог очереди, создавая его при необходимости
+func Open(dir string) (*Queue, error) {
+	const op = "deadletter.Open"
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &Queue{dir: dir}, nil
+}
+
+// Dir возвращает каталог очереди
+func (q *Queue) Dir() string {
+	return q.dir
+}
+
+// Put сохраняет новую запись и возвращает путь к её файлу
+func (q *Queue) Put(rec *Record) (string, error) {
+	const op = "deadletter.Queue.Put"
+
+	name := fmt.Sprintf("%d-%04d-%s.json", time.Now().UnixNano(), q.seq.Add(1)%10000, rec.Book.SourceUUID)
+	path := filepath.Join(q.dir, name)
+	if err := write(path, rec); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return path, nil
+}
+
+// Update перезаписывает запись после неудачной повторной отправки
+func (q *Queue) Update(path string, rec *Record) error {
+	const op = "deadletter.Queue.Update"
+
+	rec.UpdatedAt = time.Now().Unix()
+	if err := write(path, rec); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// List возвращает файлы записей в порядке их создания
+func (q *Queue) List() ([]string, error) {
+	const op = "deadletter.Queue.List"
+
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, filepath.Join(q.dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Load читает запись из файла
+func (q *Queue) Load(path string) (*Record, error) {
+	const op = "deadletter.Queue.Load"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", op, path, err)
+	}
+	return &rec, nil
+}
+
+// Remove удаляет запись после успешной повторной отправки
+func (q *Queue) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// write атомарно записывает запись через временный файл
+func write(path string, rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
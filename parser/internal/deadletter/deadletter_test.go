@@ -0,0 +1,65 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+func TestQueueRoundTrip(t *testing.T) {
+	q, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := int64(7)
+	docs := []storage.Document{
+		{Table: "library", ID: &id, Doc: map[string]string{"content": "текст"}},
+		{Table: "authors", Doc: map[string]string{"name": "Иванов"}},
+	}
+	rec, err := NewRecord(Book{SourceUUID: "uuid", Source: "book.docx"}, docs, errors.New("unknown column"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Put(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d files, want 1", len(files))
+	}
+
+	loaded, err := q.Load(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Book.Source != "book.docx" || loaded.Error != "unknown column" || loaded.Attempts != 1 {
+		t.Errorf("unexpected record: %+v", loaded)
+	}
+
+	got := loaded.Documents()
+	if len(got) != 2 || got[0].Table != "library" || got[0].ID == nil || *got[0].ID != 7 || got[1].ID != nil {
+		t.Fatalf("unexpected documents: %+v", got)
+	}
+	// Тело документа отправляется повторно без изменений
+	body, err := json.Marshal(got[0].Doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"content":"текст"}` {
+		t.Errorf("got body %s", body)
+	}
+
+	if err := q.Remove(files[0]); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := q.List(); len(files) != 0 {
+		t.Errorf("got %d files after remove", len(files))
+	}
+}
@@ -6,73 +6,73 @@ import (
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/library/mapping"
 )
 
 type TitleList struct {
-	SourceUUID uuid.UUID
-	Source     string
-	Genre      string
-	Author     string
-	Title      string
-	Folder     string // Добавлено новое поле
+	SourceUUID  uuid.UUID
+	Source      string
+	Genre       string
+	GenrePath   []string // уровни таксономии жанра от корня, последний — Genre
+	Author      string
+	Persons     []Person // участники книги из поля автора, см. ParsePersons
+	Title       string
+	Year        int      // год издания из названия, 0 если не указан
+	Volume      int      // номер тома, книги, части или выпуска из названия
+	Edition     int      // номер издания из названия
+	Series      string   // серия или многотомное издание, к которому относится том
+	Folder      string   // Добавлено новое поле
+	ContentHash string   // SHA-256 содержимого файла, из него выводится SourceUUID
+	Description string   // аннотация из файла метаданных
+	Tags        []string // теги из файла метаданных
+	Sidecar     string   // файл метаданных, поля которого заменили разобранные из имени, см. ApplySidecar
 }
 
-// NewTitleList создает новый TitleList из полного пути файла
-func NewTitleList(filePath string, genresMap, foldersMap map[string]string) *TitleList {
+// filenamePattern шаблон имени файла книги «Жанр_Автор — Название»:
+// 1. Жанр: все до первого "_"
+// 2. Автор: либо текст между "_" и " — ", либо пусто
+// 3. Название: все после " — "
+var filenamePattern = regexp.MustCompile(`^([^_]+)_([^—]*) — (.+)$`)
+
+// NewTitleList создает новый TitleList из полного пути файла.
+// Жанр и папка сопоставляются по правилам genres и folders, nil оставляет их как есть.
+// Имена участников сводятся к каноническим по псевдонимам aliases, если они заданы.
+func NewTitleList(filePath string, genres, folders *mapping.Mapper, aliases *Aliases) *TitleList {
 	// Извлекаем имя файла и папки
 	filename := filepath.Base(filePath)
 	baseName := strings.TrimSuffix(filename, filepath.Ext(filename))
-	folder := filepath.Base(filepath.Dir(filePath))
 
 	// Применяем маппинг папок
-	if mapped, ok := foldersMap[folder]; ok {
-		folder = mapped
-	}
+	folder := folders.Map(filepath.Base(filepath.Dir(filePath))).Target
 
 	tl := &TitleList{
 		Folder: folder,
 	}
 
-	// Улучшенное регулярное выражение:
-	// 1. Жанр: все до первого "_"
-	// 2. Автор: либо текст между "_" и " — ", либо пусто
-	// 3. Название: все после " — "
-	const pattern = `^([^_]+)_([^—]*) — (.+)$`
-	matches := regexp.MustCompile(pattern).FindStringSubmatch(baseName)
+	matches := filenamePattern.FindStringSubmatch(baseName)
 
 	if len(matches) == 4 {
-		originalGenre := strings.TrimSpace(matches[1])
 		author := strings.TrimSpace(matches[2])
 		title := strings.TrimSpace(matches[3])
 
-		// Применяем маппинг жанров
-		genre := originalGenre
-		if genresMap != nil {
-			if mapped, ok := genresMap[originalGenre]; ok {
-				genre = mapped
-			} else {
-				// Поиск с учетом тримминга пробелов
-				trimmedOriginal := strings.TrimSpace(originalGenre)
-				for original, mapped := range genresMap {
-					if strings.TrimSpace(original) == trimmedOriginal {
-						genre = mapped
-						break
-					}
-				}
-			}
-		}
-
-		tl.Genre = genre
+		// Применяем маппинг жанров, жанр может быть уровнем таксономии
+		genre := genres.Map(matches[1])
+		tl.Genre = genre.Target
+		tl.GenrePath = genre.Path
 		tl.Author = author
+		tl.Persons = aliases.Resolve(ParsePersons(author))
 		tl.Title = title
 	} else {
 		// Если не соответствует шаблону - используем имя файла как название
 		tl.Title = baseName
 	}
 
+	parseTitle(tl)
+
 	// Если жанр не указан - используем имя папки
 	if tl.Genre == "" {
 		tl.Genre = folder
+		tl.GenrePath = []string{folder}
 	}
 
 	return tl
@@ -1,6 +1,12 @@
 package book
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestNewTitleList(t *testing.T) {
 	tests := []struct {
@@ -36,7 +42,7 @@ func TestNewTitleList(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tl := NewTitleList(tt.filePath, nil, nil)
+			tl := NewTitleList(tt.filePath, nil, nil, nil)
 			if tl.Genre != tt.wantGenre {
 				t.Errorf("Genre = %v, want %v", tl.Genre, tt.wantGenre)
 			}
@@ -49,3 +55,258 @@ func TestNewTitleList(t *testing.T) {
 		})
 	}
 }
+
+func TestSourceUUIDDeterministic(t *testing.T) {
+	const hash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	if SourceUUID(hash) != SourceUUID(hash) {
+		t.Fatal("SourceUUID must be deterministic")
+	}
+	if got := SourceUUID(hash).Version(); got != 5 {
+		t.Errorf("Version = %v, want 5", got)
+	}
+	if SourceUUID(hash) == SourceUUID(hash[1:]) {
+		t.Error("different fingerprints must give different UUIDs")
+	}
+}
+
+func TestParsePersons(t *testing.T) {
+	tests := []struct {
+		author string
+		want   []Person
+	}{
+		{"Иванов И.И.", []Person{{Name: "Иванов И.И.", Role: RoleAuthor}}},
+		{"Иванов И.И., Петров П.П.", []Person{{Name: "Иванов И.И.", Role: RoleAuthor}, {Name: "Петров П.П.", Role: RoleAuthor}}},
+		{"Ильф и Петров", []Person{{Name: "Ильф", Role: RoleAuthor}, {Name: "Петров", Role: RoleAuthor}}},
+		{"под ред. Сидорова", []Person{{Name: "Сидорова", Role: RoleEditor}}},
+		{"Иванов, под ред. Сидорова и Козлова", []Person{{Name: "Иванов", Role: RoleAuthor}, {Name: "Сидорова", Role: RoleEditor}, {Name: "Козлова", Role: RoleEditor}}},
+		{"Смит Дж.; пер. с англ. Кузнецова", []Person{{Name: "Смит Дж.", Role: RoleAuthor}, {Name: "Кузнецова", Role: RoleTranslator}}},
+		{"Сост.: Орлов А.", []Person{{Name: "Орлов А.", Role: RoleCompiler}}},
+		{"Сидоров (ред.), Петров", []Person{{Name: "Сидоров", Role: RoleEditor}, {Name: "Петров", Role: RoleAuthor}}},
+		{"Перельман Я.И., Редькин", []Person{{Name: "Перельман Я.И.", Role: RoleAuthor}, {Name: "Редькин", Role: RoleAuthor}}},
+		{"Иванов, иванов", []Person{{Name: "Иванов", Role: RoleAuthor}}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got := ParsePersons(tt.author)
+		if len(got) != len(tt.want) {
+			t.Errorf("ParsePersons(%q) = %v, want %v", tt.author, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].Name != tt.want[i].Name || got[i].Role != tt.want[i].Role {
+				t.Errorf("ParsePersons(%q) = %v, want %v", tt.author, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		name          string
+		wantCanonical string
+		wantKey       string
+	}{
+		{"Пушкин А.С.", "Пушкин А.С.", "pushkin a s"},
+		{"А. С. Пушкин", "Пушкин А.С.", "pushkin a s"},
+		{"Пушкин Александр Сергеевич", "Пушкин Александр Сергеевич", "pushkin aleksandr sergeevich"},
+		{"Александр Сергеевич Пушкин", "Пушкин Александр Сергеевич", "pushkin aleksandr sergeevich"},
+		{"Иванов Иван Иванович", "Иванов Иван Иванович", "ivanov ivan ivanovich"},
+		{"Иванов Игорь Ильич", "Иванов Игорь Ильич", "ivanov igor ilich"},
+		{"A. S. Pushkin", "Pushkin A.S.", "pushkin a s"},
+		{"Смит Дж.", "Смит Дж.", "smit d"},
+		{"Горький", "Горький", "gorkiy"},
+		{"Джон Р. Р. Толкин", "Джон Р. Р. Толкин", "dzhon r r tolkin"},
+	}
+	for _, tt := range tests {
+		n := ParseName(tt.name)
+		if got := n.Canonical(); got != tt.wantCanonical {
+			t.Errorf("ParseName(%q).Canonical() = %q, want %q", tt.name, got, tt.wantCanonical)
+		}
+		if got := n.Key(); got != tt.wantKey {
+			t.Errorf("ParseName(%q).Key() = %q, want %q", tt.name, got, tt.wantKey)
+		}
+	}
+	if got := ParseName("Пушкин Александр Сергеевич").InitialsKey(); got != "pushkin a s" {
+		t.Errorf("InitialsKey() = %q, want %q", got, "pushkin a s")
+	}
+}
+
+func TestAliasesResolve(t *testing.T) {
+	aliases := NewAliases(map[string][]string{
+		"Пушкин Александр Сергеевич": {"Белкин И.П."},
+		"Иванов Иван Иванович":       nil,
+	})
+	persons := aliases.Resolve(ParsePersons("Белкин И. П., А.С. Пушкин, Гоголь Н.В., Иванов Игорь Ильич"))
+	want := []string{"Пушкин Александр Сергеевич", "Гоголь Н.В.", "Иванов Игорь Ильич"}
+	if len(persons) != len(want) {
+		t.Fatalf("Resolve = %+v, want %v", persons, want)
+	}
+	for i, p := range persons {
+		if p.Name != want[i] {
+			t.Errorf("persons[%d].Name = %q, want %q", i, p.Name, want[i])
+		}
+	}
+	if persons[0].Variant != "Белкин И. П." || len(persons[0].Aliases) != 1 {
+		t.Errorf("unexpected alias person %+v", persons[0])
+	}
+	if persons[1].Variant != "" || persons[1].Aliases != nil {
+		t.Errorf("unexpected person without aliases %+v", persons[1])
+	}
+}
+
+func TestParseTitle(t *testing.T) {
+	tests := []struct {
+		title   string
+		year    int
+		volume  int
+		edition int
+		series  string
+	}{
+		{"Собрание сочинений. Том 3 (1985)", 1985, 3, 0, "Собрание сочинений"},
+		{"Серия Х. Книга 2", 0, 2, 0, "Серия Х"},
+		{"Война и мир. Т. IV", 0, 4, 0, "Война и мир"},
+		{"Физика. 2-е издание, 1972", 1972, 0, 2, ""},
+		{"Учебник химии, изд. 3-е, 2001 г.", 2001, 0, 3, ""},
+		{"Часть 2: Возвращение", 0, 2, 0, ""},
+		{"1984", 0, 0, 0, ""},
+		{"Дневник 1941 г.", 1941, 0, 0, ""},
+		{"Часть речи", 0, 0, 0, ""},
+		{"Издательство и книга", 0, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		tl := &TitleList{Title: tt.title}
+		parseTitle(tl)
+		if tl.Year != tt.year || tl.Volume != tt.volume || tl.Edition != tt.edition || tl.Series != tt.series {
+			t.Errorf("parseTitle(%q) = year %d, volume %d, edition %d, series %q; want %d, %d, %d, %q",
+				tt.title, tl.Year, tl.Volume, tl.Edition, tl.Series, tt.year, tt.volume, tt.edition, tt.series)
+		}
+		if tl.Title != tt.title {
+			t.Errorf("parseTitle changed title %q to %q", tt.title, tl.Title)
+		}
+	}
+}
+
+func TestSidecar(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("scan_0001.yaml", "genre: История\nauthors: [Иванов Иван Петрович, Петров П.П. (ред.)]\ntitle: Летопись. Том 2\nyear: 1999\ntags: [летописи, Русь]\n")
+	write("book.opf", `<package><metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+<dc:title>Записки</dc:title><dc:creator opf:role="aut">Сидоров С.С.</dc:creator>
+<dc:creator opf:role="trl">Smith J.</dc:creator><dc:date>1965-01-01</dc:date>
+<dc:description>Аннотация</dc:description><dc:subject>мемуары</dc:subject></metadata></package>`)
+	write(FolderMetadataFile, "file,genre,author,title,year,tags\n"+
+		"Фантастика_Иванов — Космос.docx,,Петров П.П.,,2010,космос; полёты\n")
+
+	reader := NewSidecarReader()
+	tests := []struct {
+		file      string
+		wantTitle string
+		wantYear  int
+		wantGenre string
+		persons   []Person
+		tags      []string
+	}{
+		{
+			file: "scan_0001.pdf", wantTitle: "Летопись. Том 2", wantYear: 1999, wantGenre: "История",
+			persons: []Person{{Name: "Иванов Иван Петрович", Role: RoleAuthor}, {Name: "Петров П.П.", Role: RoleEditor}},
+			tags:    []string{"летописи", "Русь"},
+		},
+		{
+			file: "book.epub", wantTitle: "Записки", wantYear: 1965, wantGenre: filepath.Base(dir),
+			persons: []Person{{Name: "Сидоров С.С.", Role: RoleAuthor}, {Name: "Smith J.", Role: RoleTranslator}},
+			tags:    []string{"мемуары"},
+		},
+		{
+			file: "Фантастика_Иванов — Космос.docx", wantTitle: "Космос", wantYear: 2010, wantGenre: "Фантастика",
+			persons: []Person{{Name: "Петров П.П.", Role: RoleAuthor}},
+			tags:    []string{"космос", "полёты"},
+		},
+		{file: "Фантастика_Иванов — Звёзды.docx", wantTitle: "Звёзды", wantGenre: "Фантастика",
+			persons: []Person{{Name: "Иванов", Role: RoleAuthor}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			tl := NewTitleList(path, nil, nil, nil)
+			sc, err := reader.Find(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tl.ApplySidecar(sc, nil, nil)
+			if tl.Title != tt.wantTitle || tl.Year != tt.wantYear || tl.Genre != tt.wantGenre {
+				t.Errorf("got title %q, year %d, genre %q; want %q, %d, %q",
+					tl.Title, tl.Year, tl.Genre, tt.wantTitle, tt.wantYear, tt.wantGenre)
+			}
+			if !reflect.DeepEqual(tl.Persons, tt.persons) {
+				t.Errorf("persons = %+v, want %+v", tl.Persons, tt.persons)
+			}
+			if !reflect.DeepEqual(tl.Tags, tt.tags) {
+				t.Errorf("tags = %q, want %q", tl.Tags, tt.tags)
+			}
+		})
+	}
+}
+
+func TestLintFilename(t *testing.T) {
+	tests := []struct {
+		path      string
+		sidecar   *Sidecar
+		issues    []string
+		suggested string
+	}{
+		{path: "/books/Фантастика_Иванов — Космос.docx"},
+		{path: "/books/Альтернативная медицина_ — Прибор.docx"},
+		{path: "/books/Фантастика_Иванов - Космос.docx", issues: []string{IssueWrongDash}, suggested: "Фантастика_Иванов — Космос.docx"},
+		{path: "/books/Фантастика_Иванов—Космос.pdf", issues: []string{IssueWrongDash}, suggested: "Фантастика_Иванов — Космос.pdf"},
+		{path: "/books/Иванов — Космос.docx", issues: []string{IssueNoGenre}, suggested: "books_Иванов — Космос.docx"},
+		{path: "/books/Фантастика_Космос.docx", issues: []string{IssueNoSeparator}, suggested: "Фантастика_ — Космос.docx"},
+		{path: "/books/Фантастика_Иванов  — Космос .docx", issues: []string{IssueExtraSpaces}, suggested: "Фантастика_Иванов — Космос.docx"},
+		{path: "/books/Космос.docx", issues: []string{IssueNoGenre, IssueNoSeparator}, suggested: "books_ — Космос.docx"},
+		{path: "/books/Фантастика_Иванов —.docx", issues: []string{IssueWrongDash, IssueNoTitle}},
+		{
+			path:    "/books/scan_0001.pdf",
+			sidecar: &Sidecar{Path: "/books/scan_0001.yaml", Genre: "История", Author: "Петров П.П.", Title: "Летопись"},
+			issues:  []string{IssueNoSeparator}, suggested: "История_Петров П.П. — Летопись.pdf",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			l := LintFilename(tt.path, "books", tt.sidecar)
+			if tt.issues == nil {
+				if l != nil {
+					t.Errorf("LintFilename() = %+v, want nil", l)
+				}
+				return
+			}
+			if l == nil {
+				t.Fatalf("LintFilename() = nil, want issues %v", tt.issues)
+			}
+			if !reflect.DeepEqual(l.Issues, tt.issues) || l.Suggested != tt.suggested {
+				t.Errorf("LintFilename() = %v, %q; want %v, %q", l.Issues, l.Suggested, tt.issues, tt.suggested)
+			}
+			if l.Suggested != "" && FilenameIssues(strings.TrimSuffix(l.Suggested, filepath.Ext(l.Suggested))) != nil {
+				t.Errorf("suggested name %q does not conform", l.Suggested)
+			}
+		})
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct{ title, want string }{
+		{"Ёлка, или  Праздник!", "елка или праздник"},
+		{"Война и мир. Том 3", "война и мир v3"},
+		{"Война и мир, т. III", "война и мир v3"},
+		{"Война и мир (Книга 3)", "война и мир v3"},
+		{"Томск", "томск"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeTitle(tt.title); got != tt.want {
+			t.Errorf("NormalizeTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package book
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// namespace пространство имён для UUIDv5 книг библиотеки.
+// Выводится из URL репозитория, поэтому одинаково во всех сборках.
+var namespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/terratensor/library"))
+
+// Fingerprint возвращает SHA-256 содержимого файла в шестнадцатеричном виде
+func Fingerprint(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("fingerprint: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("fingerprint: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SourceUUID возвращает UUIDv5 книги, выведенный из отпечатка её содержимого.
+// Один и тот же файл всегда получает один и тот же UUID, независимо от имени и пути.
+func SourceUUID(fingerprint string) uuid.UUID {
+	return uuid.NewSHA1(namespace, []byte(fingerprint))
+}
+
+// SetFingerprint вычисляет отпечаток файла и заполняет ContentHash и SourceUUID
+func (tl *TitleList) SetFingerprint(filePath string) error {
+	hash, err := Fingerprint(filePath)
+	if err != nil {
+		return err
+	}
+	tl.ContentHash = hash
+	tl.SourceUUID = SourceUUID(hash)
+	return nil
+}
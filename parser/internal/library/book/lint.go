@@ -0,0 +1,139 @@
+package book
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Причины несоответствия имени файла шаблону «Жанр_Автор — Название»
+const (
+	IssueExtraSpaces = "extra_spaces" // пробелы в начале, в конце или несколько подряд
+	IssueNoGenre     = "no_genre"     // нет «_» после жанра или жанр пуст
+	IssueNoSeparator = "no_separator" // нет « — » между автором и названием
+	IssueWrongDash   = "wrong_dash"   // автор и название разделены дефисом, «–» или «—» без пробелов
+	IssueDashAuthor  = "dash_author"  // в поле автора есть «—»
+	IssueNoTitle     = "no_title"     // пустое название
+)
+
+// wrongDash разделители автора и названия, похожие на « — »
+var wrongDash = regexp.MustCompile(`\s*—\s*|\s+(?:--?|–)\s+`)
+
+// FilenameLint результат проверки имени файла книги
+type FilenameLint struct {
+	Path      string   `json:"path"`
+	Issues    []string `json:"issues"`
+	Genre     string   `json:"genre"` // значения, разобранные из имени с учётом файла метаданных
+	Author    string   `json:"author"`
+	Title     string   `json:"title"`
+	Sidecar   string   `json:"sidecar,omitempty"`
+	Suggested string   `json:"suggested"` // имя файла по шаблону, пусто если его не удалось составить
+}
+
+// LintFilename проверяет имя файла filePath и предлагает имя по шаблону.
+// Имя разбирается с заменой похожих разделителей, жанр без «_» берётся
+// из defaultGenre (обычно — папки), заполненные поля файла метаданных sc
+// важнее разобранных из имени. Для имени по шаблону возвращает nil.
+func LintFilename(filePath, defaultGenre string, sc *Sidecar) *FilenameLint {
+	filename := filepath.Base(filePath)
+	ext := filepath.Ext(filename)
+	name := strings.TrimSuffix(filename, ext)
+	issues := FilenameIssues(name)
+	if len(issues) == 0 {
+		return nil
+	}
+
+	l := &FilenameLint{Path: filePath, Issues: issues}
+	l.Genre, l.Author, l.Title = splitFilename(strings.Join(strings.Fields(name), " "))
+	if l.Genre == "" {
+		l.Genre = defaultGenre
+	}
+	if sc != nil {
+		if sc.Genre != "" {
+			l.Genre = sc.Genre
+		}
+		if sc.Author != "" {
+			l.Author = sc.Author
+		}
+		if sc.Title != "" {
+			l.Title = sc.Title
+		}
+		l.Sidecar = sc.Path
+	}
+	if suggested := FormatFilename(l.Genre, l.Author, l.Title); suggested != "" && suggested != name {
+		l.Suggested = suggested + ext
+	}
+	return l
+}
+
+// FilenameIssues возвращает причины, по которым имя файла без расширения
+// не соответствует шаблону, или nil
+func FilenameIssues(name string) []string {
+	var issues []string
+	norm := strings.Join(strings.Fields(name), " ")
+	if norm != name {
+		issues = append(issues, IssueExtraSpaces)
+	}
+	genre, author, title := splitFilename(norm)
+	if genre == "" {
+		issues = append(issues, IssueNoGenre)
+	}
+	if !strings.Contains(norm, " — ") {
+		if wrongDash.MatchString(norm) {
+			issues = append(issues, IssueWrongDash)
+		} else {
+			issues = append(issues, IssueNoSeparator)
+		}
+	}
+	if strings.Contains(author, "—") {
+		issues = append(issues, IssueDashAuthor)
+	}
+	if title == "" {
+		issues = append(issues, IssueNoTitle)
+	}
+	return issues
+}
+
+// splitFilename делит имя файла на жанр, автора и название, принимая
+// за разделитель автора и названия первое « — » или похожий на него знак
+func splitFilename(name string) (genre, author, title string) {
+	head, title, ok := strings.Cut(name, " — ")
+	if !ok {
+		if loc := wrongDash.FindStringIndex(name); loc != nil {
+			head, title, ok = name[:loc[0]], name[loc[1]:], true
+		}
+	}
+	if !ok {
+		// Без разделителя автора нет: «Жанр_Название» или «Название»
+		if g, t, found := strings.Cut(name, "_"); found {
+			return strings.TrimSpace(g), "", strings.TrimSpace(t)
+		}
+		return "", "", name
+	}
+	if g, a, found := strings.Cut(head, "_"); found {
+		return strings.TrimSpace(g), strings.TrimSpace(a), strings.TrimSpace(title)
+	}
+	return "", strings.TrimSpace(head), strings.TrimSpace(title)
+}
+
+// FormatFilename составляет имя файла без расширения по шаблону
+// «Жанр_Автор — Название». Знаки, которые нарушили бы разбор имени,
+// заменяются. Без жанра или названия возвращает пустую строку.
+func FormatFilename(genre, author, title string) string {
+	clean := func(s, bad string) string {
+		s = strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' || strings.ContainsRune(bad, r) {
+				return ' '
+			}
+			return r
+		}, s)
+		return strings.Join(strings.Fields(s), " ")
+	}
+	genre = clean(genre, "_")
+	author = strings.ReplaceAll(clean(author, ""), "—", "-")
+	title = clean(title, "")
+	if genre == "" || title == "" {
+		return ""
+	}
+	return genre + "_" + author + " — " + title
+}
@@ -0,0 +1,221 @@
+package book
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Name имя человека, разобранное на фамилию, инициалы и полные имена
+type Name struct {
+	Surname  string
+	Initials []string // инициалы без точек: «А», «С», «Дж»
+	Given    []string // имя и отчество полностью, если известны
+	raw      string   // имя, которое не удалось разобрать, как записано
+}
+
+// initialToken инициалы «А.», «А.С.», «Дж.» или одиночная заглавная буква
+var initialToken = regexp.MustCompile(`^(?:\p{Lu}\p{Ll}?\.)+$|^\p{Lu}$`)
+
+var patronymicSuffixes = []string{"вич", "вна", "ична", "инична", "ич"}
+
+// ParseName разбирает имя, записанное как «Пушкин А.С.», «А. С. Пушкин»,
+// «Пушкин Александр Сергеевич» или «Александр Сергеевич Пушкин».
+// Из двух слов без инициалов первым считается фамилия. Имена другого вида
+// не разбираются и сохраняются как записаны.
+func ParseName(s string) Name {
+	tokens := strings.Fields(s)
+	var words, initials []string
+	for _, t := range tokens {
+		if initialToken.MatchString(t) {
+			for _, i := range strings.Split(strings.Trim(t, "."), ".") {
+				initials = append(initials, i)
+			}
+			continue
+		}
+		words = append(words, t)
+	}
+
+	switch {
+	case len(words) == 1:
+		return Name{Surname: words[0], Initials: initials}
+	case len(initials) > 0:
+		// Несколько слов с инициалами: «Джон Р. Р. Толкин», «ван Гог В.»
+	case len(words) == 3 && isPatronymic(words[2]):
+		return fullName(words[0], words[1:])
+	case len(words) == 3 && isPatronymic(words[1]):
+		return fullName(words[2], words[:2])
+	case len(words) == 2:
+		return fullName(words[0], words[1:])
+	}
+	return Name{raw: strings.Join(tokens, " ")}
+}
+
+func fullName(surname string, given []string) Name {
+	n := Name{Surname: surname, Given: given}
+	for _, g := range given {
+		n.Initials = append(n.Initials, string([]rune(g)[:1]))
+	}
+	return n
+}
+
+func isPatronymic(word string) bool {
+	word = strings.ToLower(word)
+	for _, suffix := range patronymicSuffixes {
+		if strings.HasSuffix(word, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonical возвращает каноническую запись имени: фамилия и полные имена,
+// если они известны, иначе фамилия и инициалы — «Пушкин А.С.»
+func (n Name) Canonical() string {
+	switch {
+	case n.raw != "" || n.Surname == "":
+		return n.raw
+	case len(n.Given) > 0:
+		return n.Surname + " " + strings.Join(n.Given, " ")
+	case len(n.Initials) > 0:
+		return n.Surname + " " + strings.Join(n.Initials, ".") + "."
+	}
+	return n.Surname
+}
+
+// Key возвращает ключ транслитерации: фамилия и полные имена, если они
+// известны, иначе фамилия и первые буквы инициалов латиницей в нижнем
+// регистре. Записи одного имени разного вида, в том числе латиницей, получают
+// один ключ: «Пушкин А.С.» и «A. S. Pushkin» — «pushkin a s». Полные имена
+// входят в ключ, чтобы «Иванов Иван Иванович» и «Иванов Игорь Ильич»
+// не сводились в одного автора.
+func (n Name) Key() string {
+	if len(n.Given) > 0 && n.raw == "" {
+		return n.key(n.Given)
+	}
+	return n.InitialsKey()
+}
+
+// InitialsKey возвращает ключ по фамилии и инициалам даже для имени,
+// записанного полностью: «Пушкин Александр Сергеевич» — «pushkin a s»
+func (n Name) InitialsKey() string {
+	if n.raw != "" || n.Surname == "" {
+		return Transliterate(strings.ToLower(n.raw))
+	}
+	initials := make([]string, len(n.Initials))
+	for i, s := range n.Initials {
+		initials[i] = string([]rune(s)[:1])
+	}
+	return n.key(initials)
+}
+
+func (n Name) key(given []string) string {
+	parts := []string{Transliterate(strings.ToLower(n.Surname))}
+	for _, g := range given {
+		parts = append(parts, Transliterate(strings.ToLower(g)))
+	}
+	return strings.Join(parts, " ")
+}
+
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ў': "u",
+}
+
+// Transliterate переводит кириллицу в латиницу, знаки кроме букв, цифр,
+// пробелов и дефисов отбрасывает
+func Transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if t, ok := translit[unicode.ToLower(r)]; ok {
+			b.WriteString(t)
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Aliases псевдонимы и варианты написания имён авторов. Все записи одного
+// автора сводятся к его каноническому имени из файла псевдонимов.
+type Aliases struct {
+	canonical map[string]string   // ключ записи → каноническое имя
+	aliases   map[string][]string // каноническое имя → псевдонимы
+}
+
+// LoadAliases читает файл псевдонимов в формате YAML:
+//
+//	authors:
+//	  Пушкин Александр Сергеевич:
+//	    - Белкин И.П.
+//	    - A. S. Pushkin
+func LoadAliases(path string) (*Aliases, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("aliases: %w", err)
+	}
+	var file struct {
+		Authors map[string][]string `yaml:"authors"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("aliases: %s: %w", path, err)
+	}
+	return NewAliases(file.Authors), nil
+}
+
+// NewAliases создаёт псевдонимы по списку вариантов каждого канонического имени
+func NewAliases(authors map[string][]string) *Aliases {
+	a := &Aliases{canonical: make(map[string]string), aliases: make(map[string][]string)}
+	// Сначала ключи по инициалам, чтобы сокращённая запись («Пушкин А.С.»)
+	// сводилась к полному имени, затем полные ключи: они важнее
+	for _, initials := range []bool{true, false} {
+		for name, aliases := range authors {
+			name = strings.Join(strings.Fields(name), " ")
+			for _, variant := range append([]string{name}, aliases...) {
+				n := ParseName(variant)
+				if initials {
+					a.canonical[n.InitialsKey()] = name
+				} else {
+					a.canonical[n.Key()] = name
+				}
+			}
+			a.aliases[name] = aliases
+		}
+	}
+	return a
+}
+
+// Resolve заменяет имена участников каноническими из файла псевдонимов
+// и добавляет им известные псевдонимы. Участники, сведённые к уже
+// встреченному имени, отбрасываются. Nil-псевдонимы ничего не меняют.
+func (a *Aliases) Resolve(persons []Person) []Person {
+	if a == nil {
+		return persons
+	}
+	resolved := persons[:0]
+	seen := make(map[string]bool, len(persons))
+	for _, p := range persons {
+		if name, ok := a.canonical[ParseName(p.Name).Key()]; ok {
+			if p.Variant == "" && p.Name != name {
+				p.Variant = p.Name
+			}
+			p.Name = name
+			p.Aliases = a.aliases[name]
+		}
+		if seen[p.Name] {
+			continue
+		}
+		seen[p.Name] = true
+		resolved = append(resolved, p)
+	}
+	return resolved
+}
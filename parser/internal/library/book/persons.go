@@ -0,0 +1,120 @@
+package book
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Роли участников книги
+const (
+	RoleAuthor     = "author"
+	RoleEditor     = "editor"
+	RoleTranslator = "translator"
+	RoleCompiler   = "compiler"
+)
+
+// Person участник книги из поля автора в имени файла
+type Person struct {
+	Name    string // каноническое имя, см. Name.Canonical и Aliases
+	Role    string
+	Variant string   // имя, как оно записано в имени файла, если отличается от канонического
+	Aliases []string // псевдонимы и варианты написания из файла псевдонимов
+}
+
+// rolePrefixes обозначения роли перед именем, в нижнем регистре.
+// Более длинные обозначения идут раньше совпадающих с ними коротких.
+var rolePrefixes = []struct {
+	prefix string
+	role   string
+}{
+	{"под общей редакцией ", RoleEditor},
+	{"под общ. ред.", RoleEditor},
+	{"под редакцией ", RoleEditor},
+	{"под ред.", RoleEditor},
+	{"редакторы ", RoleEditor},
+	{"редактор ", RoleEditor},
+	{"ред.", RoleEditor},
+	{"переводчики ", RoleTranslator},
+	{"переводчик ", RoleTranslator},
+	{"перевод ", RoleTranslator},
+	{"пер.", RoleTranslator},
+	{"составители ", RoleCompiler},
+	{"составитель ", RoleCompiler},
+	{"сост.", RoleCompiler},
+}
+
+// roleSuffix обозначение роли в скобках после имени: «Сидоров (ред.)»
+var roleSuffix = regexp.MustCompile(`\s*\((ред|редактор|пер|переводчик|сост|составитель)\.?\)$`)
+
+var suffixRoles = map[string]string{
+	"ред": RoleEditor, "редактор": RoleEditor,
+	"пер": RoleTranslator, "переводчик": RoleTranslator,
+	"сост": RoleCompiler, "составитель": RoleCompiler,
+}
+
+// fromLanguage язык оригинала после обозначения перевода: «пер. с англ.»
+var fromLanguage = regexp.MustCompile(`^с\s+\p{L}+\.?\s*`)
+
+// ParsePersons разбирает поле автора из имени файла на участников книги.
+// Участники перечисляются через запятую, точку с запятой или союз «и».
+// Обозначение роли перед именем («под ред.», «пер.», «сост.») относится
+// и к следующим именам до другого обозначения, обозначение в скобках
+// после имени — только к нему. Без обозначения участник считается автором.
+// Имена приводятся к канонической записи, повторы одного имени отбрасываются.
+func ParsePersons(author string) []Person {
+	var persons []Person
+	seen := make(map[string]bool)
+	role := RoleAuthor
+	for _, part := range splitPersons(author) {
+		name := part
+		if r, rest, ok := cutRolePrefix(name); ok {
+			role, name = r, rest
+		}
+		nameRole := role
+		if m := roleSuffix.FindStringSubmatch(name); m != nil {
+			nameRole = suffixRoles[m[1]]
+			name = name[:len(name)-len(m[0])]
+		}
+		name = strings.TrimSpace(strings.Trim(strings.Join(strings.Fields(name), " "), ":"))
+		parsed := ParseName(name)
+		if name == "" || seen[parsed.Key()] {
+			continue
+		}
+		seen[parsed.Key()] = true
+		p := Person{Name: parsed.Canonical(), Role: nameRole}
+		if p.Name != name {
+			p.Variant = name
+		}
+		persons = append(persons, p)
+	}
+	return persons
+}
+
+// splitPersons делит поле автора на части с одним участником
+func splitPersons(author string) []string {
+	var parts []string
+	for _, p := range strings.FieldsFunc(author, func(r rune) bool { return r == ',' || r == ';' || r == '&' }) {
+		for _, s := range strings.Split(p, " и ") {
+			if s = strings.TrimSpace(s); s != "" {
+				parts = append(parts, s)
+			}
+		}
+	}
+	return parts
+}
+
+// cutRolePrefix отрезает обозначение роли в начале части
+func cutRolePrefix(part string) (string, string, bool) {
+	lower := strings.ToLower(part)
+	for _, rp := range rolePrefixes {
+		if !strings.HasPrefix(lower, rp.prefix) {
+			continue
+		}
+		rest := strings.TrimSpace(part[len(rp.prefix):])
+		if rp.role == RoleTranslator {
+			rest = fromLanguage.ReplaceAllString(rest, "")
+		}
+		return rp.role, rest, true
+	}
+	return "", part, false
+}
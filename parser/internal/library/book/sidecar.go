@@ -0,0 +1,271 @@
+package book
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/terratensor/library/parser/internal/library/mapping"
+	"gopkg.in/yaml.v3"
+)
+
+// FolderMetadataFile файл метаданных всех книг папки
+const FolderMetadataFile = "metadata.csv"
+
+// SidecarExts расширения файлов метаданных рядом с книгой в порядке поиска
+var SidecarExts = []string{".yaml", ".yml", ".json", ".opf"}
+
+// IsMetadataFile сообщает, что файл name — файл метаданных, а не книга
+func IsMetadataFile(name string) bool {
+	if name == FolderMetadataFile {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range SidecarExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Sidecar метаданные книги из файла рядом с ней. Заполненные поля заменяют
+// разобранные из имени файла.
+type Sidecar struct {
+	Path        string   // файл, из которого прочитаны метаданные
+	Genre       string   `yaml:"genre" json:"genre"`
+	Author      string   `yaml:"author" json:"author"`   // участники через запятую, как в имени файла
+	Authors     []string `yaml:"authors" json:"authors"` // или списком
+	Title       string   `yaml:"title" json:"title"`
+	Year        int      `yaml:"year" json:"year"`
+	Description string   `yaml:"description" json:"description"`
+	Tags        []string `yaml:"tags" json:"tags"`
+}
+
+// Fingerprint возвращает отпечаток метаданных, по которому инкрементальная
+// индексация замечает их правку без изменения файла книги. Для nil — пустая строка.
+func (sc *Sidecar) Fingerprint() string {
+	if sc == nil {
+		return ""
+	}
+	data, _ := json.Marshal(sc)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// SidecarReader ищет метаданные книг: файл <имя книги>.yaml, .yml, .json или .opf
+// рядом с книгой, а если его нет — строку книги в metadata.csv её папки.
+// Прочитанные metadata.csv кэшируются.
+type SidecarReader struct {
+	mu      sync.Mutex
+	folders map[string]map[string]*Sidecar // папка → имя файла книги → метаданные
+}
+
+// NewSidecarReader создаёт SidecarReader
+func NewSidecarReader() *SidecarReader {
+	return &SidecarReader{folders: make(map[string]map[string]*Sidecar)}
+}
+
+// Find возвращает метаданные книги filePath или nil, если их нет
+func (r *SidecarReader) Find(filePath string) (*Sidecar, error) {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	for _, ext := range SidecarExts {
+		path := base + ext
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sidecar: %w", err)
+		}
+		sc, err := parseSidecar(ext, data)
+		if err != nil {
+			return nil, fmt.Errorf("sidecar: %s: %w", path, err)
+		}
+		sc.Path = path
+		return sc, nil
+	}
+
+	rows, err := r.folder(filepath.Dir(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return rows[filepath.Base(filePath)], nil
+}
+
+func parseSidecar(ext string, data []byte) (*Sidecar, error) {
+	sc := &Sidecar{}
+	var err error
+	switch ext {
+	case ".json":
+		err = json.Unmarshal(data, sc)
+	case ".opf":
+		sc, err = parseOPF(data)
+	default:
+		err = yaml.Unmarshal(data, sc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sc.Author == "" && len(sc.Authors) > 0 {
+		sc.Author = strings.Join(sc.Authors, ", ")
+	}
+	return sc, nil
+}
+
+// opfRoles обозначения ролей MARC в OPF и их запись для ParsePersons
+var opfRoles = map[string]string{"edt": " (ред.)", "trl": " (пер.)", "com": " (сост.)"}
+
+// parseOPF читает метаданные Dublin Core из OPF-файла (EPUB, Calibre)
+func parseOPF(data []byte) (*Sidecar, error) {
+	var pkg struct {
+		Metadata struct {
+			Titles   []string `xml:"title"`
+			Creators []struct {
+				Name string `xml:",chardata"`
+				Role string `xml:"role,attr"`
+			} `xml:"creator"`
+			Subjects    []string `xml:"subject"`
+			Dates       []string `xml:"date"`
+			Description string   `xml:"description"`
+		} `xml:"metadata"`
+	}
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+	md := pkg.Metadata
+	sc := &Sidecar{Description: strings.TrimSpace(md.Description), Tags: md.Subjects}
+	if len(md.Titles) > 0 {
+		sc.Title = strings.TrimSpace(md.Titles[0])
+	}
+	for _, c := range md.Creators {
+		sc.Authors = append(sc.Authors, strings.TrimSpace(c.Name)+opfRoles[c.Role])
+	}
+	if len(md.Dates) > 0 && len(md.Dates[0]) >= 4 {
+		sc.Year, _ = strconv.Atoi(md.Dates[0][:4])
+	}
+	return sc, nil
+}
+
+// folder возвращает строки metadata.csv папки dir по именам файлов книг
+func (r *SidecarReader) folder(dir string) (map[string]*Sidecar, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rows, ok := r.folders[dir]; ok {
+		return rows, nil
+	}
+
+	path := filepath.Join(dir, FolderMetadataFile)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		r.folders[dir] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sidecar: %w", err)
+	}
+	defer file.Close()
+
+	rows, err := readFolderMetadata(file, path)
+	if err != nil {
+		return nil, fmt.Errorf("sidecar: %s: %w", path, err)
+	}
+	r.folders[dir] = rows
+	return rows, nil
+}
+
+// readFolderMetadata читает metadata.csv с заголовком: обязательная колонка file
+// и любые из genre, author, title, year, description, tags (теги через «;»)
+func readFolderMetadata(src io.Reader, path string) (map[string]*Sidecar, error) {
+	reader := csv.NewReader(src)
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["file"]; !ok {
+		return nil, fmt.Errorf("no file column")
+	}
+
+	rows := make(map[string]*Sidecar)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		sc := &Sidecar{
+			Path:        path,
+			Genre:       get("genre"),
+			Author:      get("author"),
+			Title:       get("title"),
+			Description: get("description"),
+		}
+		if year := get("year"); year != "" {
+			if sc.Year, err = strconv.Atoi(year); err != nil {
+				return nil, fmt.Errorf("%s: invalid year %q", get("file"), year)
+			}
+		}
+		for _, tag := range strings.Split(get("tags"), ";") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				sc.Tags = append(sc.Tags, tag)
+			}
+		}
+		rows[get("file")] = sc
+	}
+	return rows, nil
+}
+
+// ApplySidecar заменяет поля, разобранные из имени файла, заполненными полями
+// метаданных sc. Жанр сопоставляется по правилам genres, участники сводятся
+// по псевдонимам aliases, том, издание и серия заново разбираются из названия.
+func (tl *TitleList) ApplySidecar(sc *Sidecar, genres *mapping.Mapper, aliases *Aliases) {
+	if sc == nil {
+		return
+	}
+	if sc.Genre != "" {
+		genre := genres.Map(sc.Genre)
+		tl.Genre, tl.GenrePath = genre.Target, genre.Path
+	}
+	if sc.Author != "" {
+		tl.Author = sc.Author
+		tl.Persons = aliases.Resolve(ParsePersons(sc.Author))
+	}
+	if sc.Title != "" {
+		tl.Title = sc.Title
+		tl.Year, tl.Volume, tl.Edition, tl.Series = 0, 0, 0, ""
+		parseTitle(tl)
+	}
+	if sc.Year != 0 {
+		tl.Year = sc.Year
+	}
+	if sc.Description != "" {
+		tl.Description = sc.Description
+	}
+	if len(sc.Tags) > 0 {
+		tl.Tags = sc.Tags
+	}
+	tl.Sidecar = sc.Path
+}
@@ -0,0 +1,122 @@
+package book
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// titleRule правило извлечения сведений об издании из названия книги.
+// Правила применяются по порядку, каждое заполняет поле, если оно ещё пусто.
+type titleRule struct {
+	re    *regexp.Regexp
+	apply func(tl *TitleList, m []string) bool // возвращает false, если совпадение не подошло
+}
+
+var titleRules = []titleRule{
+	// Год издания в скобках, после запятой или точки в конце названия или с «г.»:
+	// «Том 3 (1985)», «Мемуары, 1972», «Дневник 1941 г.». Название «1984» годом не считается.
+	{
+		re: regexp.MustCompile(`\(((?:1[4-9]|20)\d\d)(?:\s*г\.?)?\)` +
+			`|[,.]\s*((?:1[4-9]|20)\d\d)(?:\s*(?:г\.|год|гг\.))?\s*$` +
+			`|\s((?:1[4-9]|20)\d\d)\s*(?:г\.|год)\s*$`),
+		apply: func(tl *TitleList, m []string) bool { return setYear(tl, m[1]+m[2]+m[3]) },
+	},
+	// Издание: «2-е издание», «3-е изд.», «издание 4-е»
+	{
+		re:    regexp.MustCompile(`(?i)(\d{1,2})\s*-?\s*е\s+изд(?:ание|\.)|изд(?:ание|\.)\s*(\d{1,2})(?:\D|$)`),
+		apply: func(tl *TitleList, m []string) bool { return setInt(&tl.Edition, m[1]+m[2]) },
+	},
+	// Серия и номер в ней: «Собрание сочинений. Том 3», «Серия Х. Книга 2», «Т. IV»
+	{
+		re: regexp.MustCompile(`^(?:(.+?)[.,:]\s*)?(?i:том|т\.|книга|кн\.|часть|ч\.|выпуск|вып\.)\s*(\d+|[IVXLC]+)(?:[\s.,:(]|$)`),
+		apply: func(tl *TitleList, m []string) bool {
+			if !setInt(&tl.Volume, m[2]) {
+				return false
+			}
+			tl.Series = strings.TrimSpace(m[1])
+			return true
+		},
+	},
+}
+
+// parseTitle извлекает из названия год издания, номер тома, издание и серию.
+// Название сохраняется как записано.
+func parseTitle(tl *TitleList) {
+	for _, rule := range titleRules {
+		for _, m := range rule.re.FindAllStringSubmatch(tl.Title, -1) {
+			if rule.apply(tl, m) {
+				break
+			}
+		}
+	}
+}
+
+// setYear принимает год от начала книгопечатания до следующего года
+func setYear(tl *TitleList, s string) bool {
+	year, err := strconv.Atoi(s)
+	if err != nil || year < 1450 || year > time.Now().Year()+1 || tl.Year != 0 {
+		return false
+	}
+	tl.Year = year
+	return true
+}
+
+// setInt записывает положительное число арабскими или римскими цифрами
+func setInt(dst *int, s string) bool {
+	if *dst != 0 {
+		return false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		n = roman(s)
+	}
+	if n <= 0 {
+		return false
+	}
+	*dst = n
+	return true
+}
+
+// roman возвращает значение числа римскими цифрами или 0
+func roman(s string) int {
+	values := map[rune]int{'I': 1, 'V': 5, 'X': 10, 'L': 50, 'C': 100}
+	n, prev := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		v, ok := values[rune(s[i])]
+		if !ok {
+			return 0
+		}
+		if v < prev {
+			n -= v
+		} else {
+			n += v
+			prev = v
+		}
+	}
+	return n
+}
+
+// volumeMarker обозначение тома, книги, части или выпуска с номером
+var volumeMarker = regexp.MustCompile(`(?i)(?:^|[\s.,:;(])(?:том|т\.|книга|кн\.|часть|ч\.|выпуск|вып\.)\s*(\d+|[ivxlc]+)\b`)
+
+// NormalizeTitle приводит название к виду для поиска дубликатов: нижний регистр,
+// «е» вместо «ё», без знаков препинания и лишних пробелов, обозначения тома
+// разного вида («Том 3», «т. III», «Книга 3») записываются одинаково — «v3»
+func NormalizeTitle(title string) string {
+	s := strings.ReplaceAll(strings.ToLower(title), "ё", "е")
+	s = volumeMarker.ReplaceAllStringFunc(s, func(m string) string {
+		var n int
+		setInt(&n, strings.ToUpper(volumeMarker.FindStringSubmatch(m)[1]))
+		return " v" + strconv.Itoa(n)
+	})
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
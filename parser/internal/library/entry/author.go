@@ -1,30 +1,145 @@
 package entry
 
-import "github.com/terratensor/library/parser/internal/library/book"
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/storage"
+)
 
 type Author struct {
-	ID          *int64 `json:"id,omitempty"`
+	ID          *int64 `json:"-"`
 	Name        string `json:"name"`
 	EntryType   string `json:"entry_type"`
 	Role        string `json:"role"`
+	Aliases     string `json:"aliases"` // псевдонимы и варианты написания имени
 	Description string `json:"description"`
 	Avatar_file string `json:"avatar_file"`
 	CreatedAt   int64  `json:"created_at"`
 	UpdatedAt   int64  `json:"updated_at"`
 }
 
+// AuthorTable таблица авторов
+var AuthorTable = storage.NewTable("authors", 2, []storage.Column{
+	{Name: "name", Type: "string attribute indexed"},
+	{Name: "entry_type", Type: "string"},
+	{Name: "role", Type: "string"},
+	{Name: "aliases", Type: "text"},
+	{Name: "description", Type: "text"},
+	{Name: "avatar_file", Type: "string attribute indexed"},
+	{Name: "created_at", Type: "timestamp"},
+	{Name: "updated_at", Type: "timestamp"},
+}, func(a *Author) *int64 { return a.ID })
+
 func NewAuthor(name string, entryType string) *Author {
+	id := AuthorID(name)
 	return &Author{
+		ID:        &id,
 		Name:      name,
 		EntryType: entryType,
 	}
 }
 
-func NewAuthorFromTitleList(titleList *book.TitleList) *Author {
-	return &Author{
-		Name:      titleList.Author,
-		EntryType: "book",
+// NewAuthorsFromTitleList создаёт участников книги, перечисленных в имени файла
+func NewAuthorsFromTitleList(titleList *book.TitleList) []Author {
+	return NewAuthors(Persons(titleList))
+}
+
+// NewAuthors создаёт строки участников книги с их ролями и псевдонимами
+func NewAuthors(persons []book.Person) []Author {
+	var authors []Author
+	for _, p := range persons {
+		a := NewAuthor(p.Name, "book")
+		a.SetRole(p.Role)
+		a.AddAliases(p.Aliases...)
+		if p.Variant != "" {
+			a.AddAliases(p.Variant)
+		}
+		authors = append(authors, *a)
+	}
+	return authors
+}
+
+// Merge объединяет строку с другой записью того же автора: сохраняет более
+// полное имя и все псевдонимы
+func (a *Author) Merge(other Author) {
+	if len(book.ParseName(a.Name).Given) == 0 && len(book.ParseName(other.Name).Given) > 0 {
+		a.Name, other.Name = other.Name, a.Name
+		a.AddAliases(other.Name)
+	} else if other.Name != a.Name {
+		a.AddAliases(other.Name)
+	}
+	if other.Aliases != "" {
+		a.AddAliases(strings.Split(other.Aliases, aliasSeparator)...)
+	}
+}
+
+const aliasSeparator = "; "
+
+// AuthorReader хранилище, из которого можно прочитать записанные строки авторов
+type AuthorReader interface {
+	// Authors возвращает строки авторов с ID из ids, отсутствующие пропускаются
+	Authors(ctx context.Context, ids []int64) ([]Author, error)
+}
+
+// authorSet строки авторов, записанные за время работы. Строка автора
+// перезаписывается целиком, поэтому перед записью она сводится с уже
+// записанной: иначе книга с кратким именем («Пушкин А.С.») затирала бы
+// полное имя и псевдонимы, собранные по другим книгам.
+type authorSet struct {
+	mu   sync.Mutex
+	rows map[int64]Author
+}
+
+// merge сводит строки authors с записанными ранее и возвращает строки для записи.
+// Строки, которых ещё нет в наборе, читаются из хранилища, если оно это умеет.
+func (s *authorSet) merge(ctx context.Context, store any, authors []Author) ([]Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var missing []int64
+	for _, a := range authors {
+		if _, ok := s.rows[*a.ID]; !ok && !slices.Contains(missing, *a.ID) {
+			missing = append(missing, *a.ID)
+		}
+	}
+	if r, ok := store.(AuthorReader); ok && len(missing) > 0 {
+		stored, err := r.Authors(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range stored {
+			s.rows[*a.ID] = a
+		}
+	}
+
+	merged := make([]Author, 0, len(authors))
+	for _, a := range authors {
+		if existing, ok := s.rows[*a.ID]; ok {
+			existing.Merge(a)
+			a = existing
+		}
+		s.rows[*a.ID] = a
+		merged = append(merged, a)
+	}
+	return merged, nil
+}
+
+// AddAliases добавляет псевдонимы, которых ещё нет
+func (a *Author) AddAliases(aliases ...string) {
+	var list []string
+	if a.Aliases != "" {
+		list = strings.Split(a.Aliases, aliasSeparator)
+	}
+	for _, alias := range aliases {
+		if alias != "" && alias != a.Name && !slices.Contains(list, alias) {
+			list = append(list, alias)
+		}
 	}
+	a.Aliases = strings.Join(list, aliasSeparator)
 }
 
 func (a *Author) SetDescription(description string) {
@@ -0,0 +1,146 @@
+package entry
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/library/similarity"
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// Book строка каталога книг: одна на файл, со статистикой по его параграфам.
+// Позволяет выводить и фильтровать книги, не агрегируя параграфы.
+type Book struct {
+	ID          *int64  `json:"-"`
+	SourceUUID  string  `json:"source_uuid"`
+	Source      string  `json:"source"`
+	Path        string  `json:"path"`
+	Folder      string  `json:"folder"`
+	Genre       string  `json:"genre"`
+	Author      string  `json:"author"`
+	Title       string  `json:"title"`
+	Year        int     `json:"year"`
+	Volume      int     `json:"volume"`
+	Edition     int     `json:"edition"`
+	Series      string  `json:"series"`
+	Description string  `json:"description"` // аннотация из файла метаданных
+	Tags        string  `json:"tags"`        // теги из файла метаданных через запятую
+	AuthorIDs   []int64 `json:"author_ids"`
+	CategoryIDs []int64 `json:"category_ids"`
+	FileSize    int64   `json:"file_size"`
+	ContentHash string  `json:"content_hash"`
+	Format      string  `json:"format"`
+	Chunks      int     `json:"chunks"`
+	CharCount   int64   `json:"char_count"`
+	WordCount   int64   `json:"word_count"`
+	Language    string  `json:"language"`    // язык большинства параграфов
+	OCRQuality  float32 `json:"ocr_quality"` // среднее качество OCR параграфов
+	IndexedAt   int64   `json:"indexed_at"`
+
+	Persons   []book.Person       `json:"-"` // участники книги для строк authors и book_authors
+	GenrePath []string            `json:"-"` // уровни таксономии жанра для строк categories
+	MinHash   *similarity.MinHash `json:"-"` // сигнатура содержимого книги для поиска дубликатов
+	SimHashes []uint64            `json:"-"` // SimHash параграфов по порядку
+
+	languages map[string]int
+	ocrSum    float64
+}
+
+// BookTable таблица каталога книг
+var BookTable = storage.NewTable("books", 4, []storage.Column{
+	{Name: "source_uuid", Type: "string"},
+	{Name: "source", Type: "string attribute indexed"},
+	{Name: "path", Type: "string"},
+	{Name: "folder", Type: "string attribute indexed"},
+	{Name: "genre", Type: "string attribute indexed"},
+	{Name: "author", Type: "string attribute indexed"},
+	{Name: "title", Type: "string attribute indexed"},
+	{Name: "year", Type: "int"},
+	{Name: "volume", Type: "int"},
+	{Name: "edition", Type: "int"},
+	{Name: "series", Type: "string attribute indexed"},
+	{Name: "description", Type: "text"},
+	{Name: "tags", Type: "string attribute indexed"},
+	{Name: "author_ids", Type: "multi64"},
+	{Name: "category_ids", Type: "multi64"},
+	{Name: "file_size", Type: "bigint"},
+	{Name: "content_hash", Type: "string"},
+	{Name: "format", Type: "string"},
+	{Name: "chunks", Type: "int"},
+	{Name: "char_count", Type: "bigint"},
+	{Name: "word_count", Type: "bigint"},
+	{Name: "language", Type: "string"},
+	{Name: "ocr_quality", Type: "float"},
+	{Name: "indexed_at", Type: "timestamp"},
+}, func(b *Book) *int64 { return b.ID })
+
+// NewBook создаёт строку каталога по метаданным из имени файла и файла метаданных
+func NewBook(titleList *book.TitleList) *Book {
+	id := BookID(titleList.SourceUUID)
+	return &Book{
+		ID:          &id,
+		SourceUUID:  titleList.SourceUUID.String(),
+		Source:      titleList.Source,
+		Folder:      titleList.Folder,
+		Genre:       titleList.Genre,
+		Author:      titleList.Author,
+		Title:       titleList.Title,
+		Year:        titleList.Year,
+		Volume:      titleList.Volume,
+		Edition:     titleList.Edition,
+		Series:      titleList.Series,
+		Description: titleList.Description,
+		Tags:        strings.Join(titleList.Tags, ", "),
+		AuthorIDs:   AuthorIDs(titleList),
+		Persons:     Persons(titleList),
+		GenrePath:   GenrePath(titleList),
+		CategoryIDs: CategoryIDs(titleList),
+		ContentHash: titleList.ContentHash,
+	}
+}
+
+// BookID возвращает ID строки каталога. Выводится из UUID книги,
+// поэтому повторная индексация заменяет строку.
+func BookID(sourceUUID uuid.UUID) int64 {
+	// Номера параграфов начинаются с 1, нулевой свободен
+	return ChunkID(sourceUUID, 0)
+}
+
+// Add учитывает параграф книги в статистике
+func (b *Book) Add(e Entry) {
+	if b.languages == nil {
+		b.languages = make(map[string]int)
+	}
+	if b.MinHash == nil {
+		b.MinHash = similarity.NewMinHash()
+	}
+	b.MinHash.Add(e.Content)
+	b.SimHashes = append(b.SimHashes, uint64(e.SimHash))
+	b.Chunks++
+	b.CharCount += int64(e.CharCount)
+	b.WordCount += int64(e.WordCount)
+	b.languages[e.Language]++
+	b.ocrSum += float64(e.OCRQuality)
+}
+
+// Finish вычисляет язык книги и среднее качество OCR после добавления всех параграфов
+func (b *Book) Finish() {
+	if b.Chunks == 0 {
+		return
+	}
+	b.OCRQuality = float32(b.ocrSum / float64(b.Chunks))
+
+	langs := make([]string, 0, len(b.languages))
+	for lang := range b.languages {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	best := 0
+	for _, lang := range langs {
+		if n := b.languages[lang]; n > best {
+			b.Language, best = lang, n
+		}
+	}
+}
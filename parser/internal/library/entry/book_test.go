@@ -0,0 +1,94 @@
+package entry
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+func TestBookStats(t *testing.T) {
+	b := NewBook(&book.TitleList{SourceUUID: uuid.New(), Source: "Жанр_Автор — Книга.docx"})
+	for _, e := range []Entry{
+		{Language: "ru", CharCount: 100, WordCount: 20, OCRQuality: 1},
+		{Language: "en", CharCount: 50, WordCount: 10, OCRQuality: 0.5},
+		{Language: "ru", CharCount: 30, WordCount: 5, OCRQuality: 0.9},
+	} {
+		b.Add(e)
+	}
+	b.Finish()
+
+	if b.Chunks != 3 || b.CharCount != 180 || b.WordCount != 35 {
+		t.Errorf("unexpected totals: %+v", b)
+	}
+	if b.Language != "ru" {
+		t.Errorf("Language = %q, want ru", b.Language)
+	}
+	if b.OCRQuality < 0.79 || b.OCRQuality > 0.81 {
+		t.Errorf("OCRQuality = %v, want 0.8", b.OCRQuality)
+	}
+	if b.ID == nil || *b.ID != BookID(uuid.MustParse(b.SourceUUID)) {
+		t.Errorf("unexpected ID %v", b.ID)
+	}
+}
+
+// authorStore хранит последнюю записанную строку каждого автора
+type authorStore struct {
+	rows map[int64]Author
+}
+
+func (s *authorStore) Write(ctx context.Context, docs []storage.Document) error {
+	for _, d := range docs {
+		if a, ok := d.Doc.(Author); ok {
+			a.ID = d.ID
+			s.rows[*d.ID] = a
+		}
+	}
+	return nil
+}
+
+func (s *authorStore) Authors(ctx context.Context, ids []int64) ([]Author, error) {
+	var out []Author
+	for _, id := range ids {
+		if a, ok := s.rows[id]; ok {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (s *authorStore) DeleteBySourceUUID(ctx context.Context, sourceUUID uuid.UUID) error { return nil }
+func (s *authorStore) DeleteBySource(ctx context.Context, source string) error            { return nil }
+
+func TestSaveBookMergesAuthors(t *testing.T) {
+	store := &authorStore{rows: make(map[int64]Author)}
+	save := func(e *Entries, author string) {
+		t.Helper()
+		b := NewBook(&book.TitleList{SourceUUID: uuid.New(), Source: "x.docx", Author: author, Title: "Книга"})
+		if err := e.SaveBook(context.Background(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := New(store, "library", "", nil, 100)
+	save(e, "Пушкин А.С.")
+	save(e, "А. С. Пушкин")
+	// Следующий запуск сводит строку с записанной в хранилище
+	save(New(store, "library", "", nil, 100), "A. S. Pushkin")
+
+	a, ok := store.rows[AuthorID("Пушкин А.С.")]
+	if !ok || len(store.rows) != 1 {
+		t.Fatalf("got %d author rows, want 1", len(store.rows))
+	}
+	if a.Name != "Пушкин А.С." {
+		t.Errorf("Name = %q, want the first record", a.Name)
+	}
+	for _, alias := range []string{"А. С. Пушкин", "A. S. Pushkin"} {
+		if !strings.Contains(a.Aliases, alias) {
+			t.Errorf("Aliases = %q, want %q", a.Aliases, alias)
+		}
+	}
+}
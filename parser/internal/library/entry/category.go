@@ -1,28 +1,82 @@
 package entry
 
-import "github.com/terratensor/library/parser/internal/library/book"
+import (
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/library/mapping"
+	"github.com/terratensor/library/parser/internal/storage"
+)
 
 type Category struct {
-	ID          *int64 `json:"id,omitempty"`
+	ID          *int64 `json:"-"`
 	Name        string `json:"name"`
+	ParentID    int64  `json:"parent_id"` // ID родительской категории таксономии, 0 для корня
+	Path        string `json:"path"`      // уровни таксономии от корня: «Наука > Физика»
 	EntryType   string `json:"entry_type"`
 	Description string `json:"description"`
 	CreatedAt   int64  `json:"created_at"`
 	UpdatedAt   int64  `json:"updated_at"`
 }
 
+// CategoryTable таблица категорий
+var CategoryTable = storage.NewTable("categories", 2, []storage.Column{
+	{Name: "name", Type: "string attribute indexed"},
+	{Name: "parent_id", Type: "bigint"},
+	{Name: "path", Type: "string"},
+	{Name: "entry_type", Type: "string"},
+	{Name: "description", Type: "text"},
+	{Name: "created_at", Type: "timestamp"},
+	{Name: "updated_at", Type: "timestamp"},
+}, func(c *Category) *int64 { return c.ID })
+
+// NewCategory создаёт категорию верхнего уровня таксономии
 func NewCategory(name string, entryType string) *Category {
+	id := CategoryID([]string{name})
 	return &Category{
+		ID:        &id,
 		Name:      name,
+		Path:      name,
 		EntryType: entryType,
 	}
 }
 
-func NewCategoryFromTitleList(titleList *book.TitleList) *Category {
-	return &Category{
-		Name:      titleList.Genre,
-		EntryType: "book",
+// NewCategories создаёт категории всех уровней таксономии жанра от корня,
+// каждая ссылается на предыдущую как на родительскую
+func NewCategories(path []string) []Category {
+	var categories []Category
+	var levels []string
+	var parentID int64
+	for _, name := range path {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		levels = append(levels, name)
+		c := NewCategory(name, "book")
+		*c.ID = CategoryID(levels)
+		c.ParentID = parentID
+		c.Path = strings.Join(levels, " "+mapping.PathSeparator+" ")
+		categories = append(categories, *c)
+		parentID = *c.ID
+	}
+	return categories
+}
+
+// NewCategoriesFromTitleList создаёт категории таксономии жанра книги
+func NewCategoriesFromTitleList(titleList *book.TitleList) []Category {
+	return NewCategories(GenrePath(titleList))
+}
+
+// GenrePath возвращает уровни таксономии жанра книги. Для TitleList, созданного
+// не через book.NewTitleList, это только жанр.
+func GenrePath(titleList *book.TitleList) []string {
+	if len(titleList.GenrePath) > 0 {
+		return titleList.GenrePath
+	}
+	if strings.TrimSpace(titleList.Genre) == "" {
+		return nil
 	}
+	return []string{titleList.Genre}
 }
 
 func (c *Category) SetDescription(description string) {
@@ -2,79 +2,312 @@ package entry
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
 	"github.com/abadojack/whatlanggo"
 	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/library/similarity"
+	"github.com/terratensor/library/parser/internal/storage"
 )
 
 // PrepareParagraphs срез подготовленных параграфов книги
 type PrepareParagraphs []Entry
 
 type Entry struct {
-	ID         *int64    `json:"id,omitempty"`
-	SourceUUID uuid.UUID `json:"source_uuid"`
-	Source     string    `json:"source"`
-	Genre      string    `json:"genre"`
-	Author     string    `json:"author"`
-	BookName   string    `json:"title"`
-	Content    string    `json:"content"`
-	Language   string    `json:"language"` // "ru", "en", "de" и т.д.
-	Chunk      int       `json:"chunk"`
-	CharCount  int       `json:"char_count"`  // Реальное количество символов
-	WordCount  int       `json:"word_count"`  // Количество слов
-	OCRQuality float32   `json:"ocr_quality"` // 0.0 - 1.0 (1.0 - идеальное качество)
-	Datetime   int64     `json:"datetime"`
-	CreatedAt  int64     `json:"created_at"`
-	UpdatedAt  int64     `json:"updated_at"`
+	ID          *int64    `json:"-"`
+	SourceUUID  uuid.UUID `json:"source_uuid"`
+	Source      string    `json:"source"`
+	Genre       string    `json:"genre"`
+	Author      string    `json:"author"`
+	BookName    string    `json:"title"`
+	Content     string    `json:"content"`
+	Language    string    `json:"language"`     // "ru", "en", "de" и т.д.
+	AuthorIDs   []int64   `json:"author_ids"`   // ID авторов книги, см. AuthorID
+	CategoryIDs []int64   `json:"category_ids"` // ID категорий книги, см. CategoryID
+	Year        int       `json:"year"`         // год издания из названия книги
+	Volume      int       `json:"volume"`       // номер тома в серии
+	Edition     int       `json:"edition"`
+	Series      string    `json:"series"`
+	Chunk       int       `json:"chunk"`
+	CharCount   int       `json:"char_count"`  // Реальное количество символов
+	WordCount   int       `json:"word_count"`  // Количество слов
+	OCRQuality  float32   `json:"ocr_quality"` // 0.0 - 1.0 (1.0 - идеальное качество)
+	SimHash     int64     `json:"simhash"`     // SimHash текста для поиска почти одинаковых параграфов, биты uint64
+	Datetime    int64     `json:"datetime"`    // начало года издания, если он не раньше 1970
+	CreatedAt   int64     `json:"created_at"`
+	UpdatedAt   int64     `json:"updated_at"`
+}
+
+// EntryTable основная таблица параграфов. Имя таблицы задаётся в конфиге
+// (manticore.index), поэтому используется через EntryTable.WithName.
+var EntryTable = storage.NewTable("library", 4, []storage.Column{
+	{Name: "source_uuid", Type: "string"},
+	{Name: "source", Type: "string attribute indexed"},
+	{Name: "genre", Type: "string attribute indexed"},
+	{Name: "author", Type: "string attribute indexed"},
+	{Name: "title", Type: "string attribute indexed"},
+	{Name: "content", Type: "text"},
+	{Name: "language", Type: "string"},
+	{Name: "author_ids", Type: "multi64"},
+	{Name: "category_ids", Type: "multi64"},
+	{Name: "year", Type: "int"},
+	{Name: "volume", Type: "int"},
+	{Name: "edition", Type: "int"},
+	{Name: "series", Type: "string attribute indexed"},
+	{Name: "chunk", Type: "int"},
+	{Name: "char_count", Type: "int"},
+	{Name: "word_count", Type: "int"},
+	{Name: "ocr_quality", Type: "float"},
+	{Name: "simhash", Type: "bigint"},
+	{Name: "datetime", Type: "timestamp"},
+	{Name: "created_at", Type: "timestamp"},
+	{Name: "updated_at", Type: "timestamp"},
+}, func(e *Entry) *int64 { return e.ID })
+
+// YearTimestamp возвращает начало года в секундах Unix. Тип timestamp в Manticore
+// беззнаковый, поэтому для лет раньше 1970 и неизвестного года возвращается 0.
+func YearTimestamp(year int) int64 {
+	if year < 1970 {
+		return 0
+	}
+	return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC).Unix()
+}
+
+// LanguageTable возвращает имя таблицы параграфов языка lang
+func LanguageTable(index, lang string) string {
+	return index + "_" + lang
+}
+
+// ContentTables возвращает основную таблицу параграфов и таблицы языков languages
+func ContentTables(index string, languages []string) []string {
+	tables := []string{index}
+	for _, lang := range languages {
+		tables = append(tables, LanguageTable(index, lang))
+	}
+	return tables
+}
+
+// CatalogueTables возвращает таблицы каталога: книги, авторы, категории,
+// заголовки и связи книг с авторами и категориями. version — суффикс версии
+// таблиц, построенной командой rebuild, пустой для таблиц без версий.
+func CatalogueTables(version string) []string {
+	var tables []string
+	for _, schema := range catalogueSchemas(version) {
+		tables = append(tables, schema.Name)
+	}
+	return tables
+}
+
+// BookTables возвращает таблицы, в которых есть строки книги: параграфы,
+// каталог и связи книги с авторами и категориями
+func BookTables(index, version string, languages []string) []string {
+	return append(ContentTables(index, languages), BookTable.Name+version, BookAuthorTable.Name+version, BookCategoryTable.Name+version)
+}
+
+// Schemas возвращает схемы всех таблиц библиотеки, index — имя основной таблицы,
+// version — суффикс версии таблиц каталога, languages — языки, параграфы
+// которых пишутся в отдельные таблицы
+func Schemas(index, version string, languages []string) []storage.Schema {
+	schemas := catalogueSchemas(version)
+	for _, table := range ContentTables(index, languages) {
+		schemas = append(schemas, EntryTable.WithName(table).Schema)
+	}
+	return schemas
+}
+
+func catalogueSchemas(version string) []storage.Schema {
+	schemas := []storage.Schema{
+		AuthorTable.Schema,
+		CategoryTable.Schema,
+		TitleTable.Schema,
+		BookTable.Schema,
+		BookAuthorTable.Schema,
+		BookCategoryTable.Schema,
+	}
+	for i := range schemas {
+		schemas[i].Name += version
+	}
+	return schemas
 }
 
 type StorageInterface interface {
-	// Bulk index operations Post/bulk
-	// Bulk(ctx context.Context, entries *[]Entry) error
-	// Bulk index operations Post/bulk for all types
-	Bulk(ctx context.Context, docs interface{}) error
+	// Write записывает пакет документов в их таблицы
+	storage.Writer
+	Deleter
+}
+
+// Deleter удаление параграфов книги и её строки в каталоге
+type Deleter interface {
+	// DeleteBySourceUUID удаляет все параграфы книги
+	DeleteBySourceUUID(ctx context.Context, sourceUUID uuid.UUID) error
+	// DeleteBySource удаляет все параграфы книги по имени исходного файла
+	DeleteBySource(ctx context.Context, source string) error
 }
 
 type Entries struct {
-	store StorageInterface
+	store          StorageInterface
+	known          *authorSet // строки авторов, записанные за время работы
+	entries        *storage.Repository[Entry]
+	authors        *storage.Repository[Author]
+	categories     *storage.Repository[Category]
+	titles         *storage.Repository[Title]
+	books          *storage.Repository[Book]
+	bookAuthors    *storage.Repository[BookAuthor]
+	bookCategories *storage.Repository[BookCategory]
 }
 
-func New(store StorageInterface) *Entries {
+// New создаёт репозитории моделей библиотеки поверх хранилища.
+// index — имя основной таблицы, параграфы языков из languages пишутся
+// в таблицы LanguageTable, остальные — в основную. version — суффикс версии
+// таблиц каталога, см. CatalogueTables. batchSize — максимальный размер
+// одного bulk-запроса.
+func New(store StorageInterface, index, version string, languages []string, batchSize int) *Entries {
+	table := EntryTable.WithName(index)
+	if len(languages) > 0 {
+		routed := make(map[string]string, len(languages))
+		for _, lang := range languages {
+			routed[lang] = LanguageTable(index, lang)
+		}
+		table = table.WithRoute(func(e *Entry) string { return routed[e.Language] })
+	}
+
 	return &Entries{
-		store: store,
+		store:          store,
+		known:          &authorSet{rows: make(map[int64]Author)},
+		entries:        storage.NewRepository(store, table, batchSize),
+		authors:        storage.NewRepository(store, AuthorTable.WithName(AuthorTable.Name+version), batchSize),
+		categories:     storage.NewRepository(store, CategoryTable.WithName(CategoryTable.Name+version), batchSize),
+		titles:         storage.NewRepository(store, TitleTable.WithName(TitleTable.Name+version), batchSize),
+		books:          storage.NewRepository(store, BookTable.WithName(BookTable.Name+version), batchSize),
+		bookAuthors:    storage.NewRepository(store, BookAuthorTable.WithName(BookAuthorTable.Name+version), batchSize),
+		bookCategories: storage.NewRepository(store, BookCategoryTable.WithName(BookCategoryTable.Name+version), batchSize),
 	}
 }
 
 func (e Entries) Bulk(ctx context.Context, entries []Entry) error {
 	const op = "entry.Entries.Bulk"
 
-	select {
-	case <-ctx.Done():
-		return fmt.Errorf("%s: %w", op, ctx.Err())
-	default:
+	if err := e.entries.Save(ctx, entries); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
+	return nil
+}
+
+// Submit отдаёт параграфы хранилищу без ожидания записи, если оно пишет асинхронно.
+// Результат записи возвращает Pending.Wait.
+func (e Entries) Submit(ctx context.Context, entries []Entry) (storage.Pending, error) {
+	const op = "entry.Entries.Submit"
 
-	err := e.store.Bulk(ctx, &entries)
+	pending, err := e.entries.Submit(ctx, entries)
 	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return pending, nil
+}
+
+// Documents возвращает параграфы в виде документов таблиц параграфов
+func (e Entries) Documents(entries []Entry) []storage.Document {
+	return e.entries.Table().Documents(entries)
+}
+
+// Write записывает готовые документы, например повторно отправляемые из очереди недоставленных
+func (e Entries) Write(ctx context.Context, docs []storage.Document) error {
+	const op = "entry.Entries.Write"
+
+	if err := e.store.Write(ctx, docs); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 	return nil
 }
 
-// New methods for other models
+// BulkAuthors записывает строки авторов, сведённые с записанными ранее, см. authorSet
 func (e Entries) BulkAuthors(ctx context.Context, authors []Author) error {
-	return e.store.Bulk(ctx, &authors)
+	merged, err := e.known.merge(ctx, e.store, authors)
+	if err != nil {
+		return err
+	}
+	return e.authors.Save(ctx, merged)
 }
 
 func (e Entries) BulkCategories(ctx context.Context, categories []Category) error {
-	return e.store.Bulk(ctx, &categories)
+	return e.categories.Save(ctx, categories)
 }
 
 func (e Entries) BulkTitles(ctx context.Context, titles []Title) error {
-	return e.store.Bulk(ctx, &titles)
+	return e.titles.Save(ctx, titles)
+}
+
+// SaveBook записывает строку каталога книг, её связи с авторами и категориями
+// и строки авторов, категорий и заголовка. ID этих строк выводятся из имён,
+// поэтому повторная запись не создаёт дублей, а строки авторов сводятся
+// с записанными по другим книгам.
+func (e Entries) SaveBook(ctx context.Context, b *Book) error {
+	const op = "entry.Entries.SaveBook"
+
+	if err := e.BulkAuthors(ctx, NewAuthors(b.Persons)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := e.categories.Save(ctx, NewCategories(b.GenrePath)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if strings.TrimSpace(b.Title) != "" {
+		if err := e.titles.Save(ctx, []Title{*NewTitle(b.Title, "book")}); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	authorLinks, categoryLinks := BookLinks(b)
+	if err := e.bookAuthors.Save(ctx, authorLinks); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := e.bookCategories.Save(ctx, categoryLinks); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := e.books.Save(ctx, []Book{*b}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteBook удаляет все параграфы книги из хранилища
+func (e Entries) DeleteBook(ctx context.Context, sourceUUID uuid.UUID) error {
+	const op = "entry.Entries.DeleteBook"
+
+	if err := e.store.DeleteBySourceUUID(ctx, sourceUUID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteSource удаляет все параграфы книги по имени исходного файла
+func (e Entries) DeleteSource(ctx context.Context, source string) error {
+	const op = "entry.Entries.DeleteSource"
+
+	if err := e.store.DeleteBySource(ctx, source); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// ChunkID возвращает детерминированный ID параграфа, выведенный из UUID книги и номера чанка.
+// Повторная индексация того же файла даёт те же ID, поэтому документы заменяются, а не дублируются.
+func ChunkID(sourceUUID uuid.UUID, chunk int) int64 {
+	buf := make([]byte, len(sourceUUID)+8)
+	copy(buf, sourceUUID[:])
+	binary.BigEndian.PutUint64(buf[len(sourceUUID):], uint64(chunk))
+	// ID той же ширины, что у авторов, категорий и заголовков, см. hashID
+	return hashID(string(buf))
+}
+
+// SetID устанавливает детерминированный ID параграфа по SourceUUID и Chunk
+func (e *Entry) SetID() {
+	id := ChunkID(e.SourceUUID, e.Chunk)
+	e.ID = &id
 }
 
 func (e *Entry) DetectLanguage() {
@@ -129,3 +362,8 @@ func (e *Entry) CalculateOCRQuality() {
 	// Гарантируем диапазон 0.0-1.0
 	e.OCRQuality = max(0.0, min(1.0, quality))
 }
+
+// CalculateSimHash вычисляет SimHash текста параграфа, см. similarity.SimHash
+func (e *Entry) CalculateSimHash() {
+	e.SimHash = int64(similarity.SimHash(e.Content))
+}
@@ -0,0 +1,92 @@
+package entry
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/library/mapping"
+)
+
+// ID авторов, категорий и заголовков выводятся из нормализованного имени,
+// поэтому одно и то же имя из разных файлов и запусков получает одну строку,
+// а параграфы и книги ссылаются на неё по числовому ID.
+
+// AuthorID возвращает ID автора по ключу транслитерации имени, поэтому записи
+// одного имени разного вида («Пушкин А.С.», «А. С. Пушкин») получают один ID
+func AuthorID(name string) int64 { return hashID("author\x00" + book.ParseName(name).Key()) }
+
+// CategoryID возвращает ID категории по уровням таксономии от корня до неё,
+// поэтому одноимённые категории с разными родителями получают разные ID.
+// Уровни соединяются mapping.PathSeparator, которого в них не бывает.
+func CategoryID(path []string) int64 {
+	levels := make([]string, len(path))
+	for i, name := range path {
+		levels[i] = normalizeName(name)
+	}
+	return nameID("category", strings.Join(levels, mapping.PathSeparator))
+}
+
+// TitleID возвращает ID заголовка
+func TitleID(title string) int64 { return nameID("title", title) }
+
+// AuthorNames возвращает имена участников книги из поля автора, см. book.ParsePersons
+func AuthorNames(author string) []string {
+	persons := book.ParsePersons(author)
+	names := make([]string, 0, len(persons))
+	for _, p := range persons {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// AuthorIDs возвращает ID участников книги: авторов, редакторов, переводчиков и составителей
+func AuthorIDs(titleList *book.TitleList) []int64 {
+	ids := []int64{}
+	for _, p := range Persons(titleList) {
+		ids = append(ids, AuthorID(p.Name))
+	}
+	return ids
+}
+
+// Persons возвращает участников книги. Для TitleList, созданного не через
+// book.NewTitleList, они разбираются из поля автора без псевдонимов.
+func Persons(titleList *book.TitleList) []book.Person {
+	if titleList.Persons != nil {
+		return titleList.Persons
+	}
+	return book.ParsePersons(titleList.Author)
+}
+
+// CategoryIDs возвращает ID категорий книги: жанра и его родителей в таксономии
+func CategoryIDs(titleList *book.TitleList) []int64 {
+	ids := []int64{}
+	for _, c := range NewCategoriesFromTitleList(titleList) {
+		ids = append(ids, *c.ID)
+	}
+	return ids
+}
+
+// normalizeName приводит имя к виду, по которому сравниваются имена:
+// нижний регистр, одиночные пробелы, ё как е
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	return strings.ReplaceAll(name, "ё", "е")
+}
+
+func nameID(kind, name string) int64 {
+	return hashID(kind + "\x00" + normalizeName(name))
+}
+
+// hashID положительный ID, выведенный из строки. Все ID парсера, в том числе
+// параграфов и книг, умещаются в 53 бита, чтобы не терять точность при разборе
+// JSON-ответов Manticore в float64.
+func hashID(s string) int64 {
+	sum := sha256.Sum256([]byte(s))
+	id := int64(binary.BigEndian.Uint64(sum[:8]) & (1<<53 - 1))
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
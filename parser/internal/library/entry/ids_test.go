@@ -0,0 +1,123 @@
+package entry
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/library/book"
+)
+
+func TestAuthorNames(t *testing.T) {
+	tests := []struct {
+		author string
+		want   []string
+	}{
+		{"Толстой Л.Н.", []string{"Толстой Л.Н."}},
+		{"Ильф И., Петров Е.", []string{"Ильф И.", "Петров Е."}},
+		{"Стругацкий А.;  Стругацкий Б. ; ", []string{"Стругацкий А.", "Стругацкий Б."}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := AuthorNames(tt.author); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("AuthorNames(%q) = %q, want %q", tt.author, got, tt.want)
+		}
+	}
+}
+
+func TestNameIDs(t *testing.T) {
+	if AuthorID("Пётр  Иванов") != AuthorID(" пётр иванов") || AuthorID("Пётр Иванов") != AuthorID("Петр Иванов") {
+		t.Error("AuthorID depends on case, spacing or ё")
+	}
+	if AuthorID("Пушкин А.С.") != AuthorID("А. С. Пушкин") || AuthorID("Пушкин Александр Сергеевич") != AuthorID("Александр Сергеевич Пушкин") {
+		t.Error("AuthorID differs for variants of one name")
+	}
+	if AuthorID("Иванов Иван Иванович") == AuthorID("Иванов Игорь Ильич") {
+		t.Error("different people with the same initials share an AuthorID")
+	}
+	if AuthorID("Иванов") == CategoryID([]string{"Иванов"}) {
+		t.Error("author and category with the same name share an ID")
+	}
+	if id := TitleID("Война и мир"); id <= 0 {
+		t.Errorf("TitleID = %d, want positive", id)
+	}
+	// Все ID умещаются в 53 бита и не теряют точность в float64
+	src := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	for i := range 1000 {
+		ids := []int64{ChunkID(src, i+1), AuthorID(fmt.Sprint("Автор ", i)), CategoryID([]string{fmt.Sprint(i)}), TitleID(fmt.Sprint(i))}
+		if i == 0 {
+			ids = append(ids, BookID(src))
+		}
+		for _, id := range ids {
+			if id <= 0 || id >= 1<<53 {
+				t.Fatalf("ID %d does not fit 53 bits", id)
+			}
+		}
+	}
+}
+
+func TestBookLinks(t *testing.T) {
+	tl := &book.TitleList{SourceUUID: uuid.New(), Source: "x.docx", Genre: "Проза", Author: "Ильф И., Петров Е."}
+	b := NewBook(tl)
+	authors, categories := BookLinks(b)
+	if len(authors) != 2 || len(categories) != 1 {
+		t.Fatalf("got %d author and %d category links, want 2 and 1", len(authors), len(categories))
+	}
+	if authors[1].AuthorID != AuthorID("Петров Е.") || categories[0].CategoryID != CategoryID([]string{"Проза"}) {
+		t.Errorf("unexpected links %+v %+v", authors, categories)
+	}
+	if *authors[0].ID == *authors[1].ID || authors[0].BookID != *b.ID {
+		t.Errorf("unexpected link IDs %+v", authors)
+	}
+	again, _ := BookLinks(NewBook(tl))
+	if *again[0].ID != *authors[0].ID {
+		t.Error("link ID is not stable")
+	}
+}
+
+func TestAuthorMerge(t *testing.T) {
+	a := NewAuthors(book.ParsePersons("А. С. Пушкин"))[0]
+	a.Merge(NewAuthors(book.ParsePersons("Пушкин Александр Сергеевич"))[0])
+	if a.Name != "Пушкин Александр Сергеевич" {
+		t.Errorf("Name = %q, want full name", a.Name)
+	}
+	if a.Aliases != "А. С. Пушкин; Пушкин А.С." {
+		t.Errorf("Aliases = %q", a.Aliases)
+	}
+}
+
+func TestNewCategories(t *testing.T) {
+	categories := NewCategories([]string{"Наука", "Физика"})
+	if len(categories) != 2 {
+		t.Fatalf("got %d categories, want 2", len(categories))
+	}
+	if categories[0].ParentID != 0 || categories[1].ParentID != *categories[0].ID {
+		t.Errorf("unexpected parents %+v", categories)
+	}
+	if categories[1].Path != "Наука > Физика" || *categories[1].ID != CategoryID([]string{"Наука", "Физика"}) {
+		t.Errorf("unexpected leaf %+v", categories[1])
+	}
+	ids := CategoryIDs(&book.TitleList{GenrePath: []string{"Наука", "Физика"}})
+	if !reflect.DeepEqual(ids, []int64{CategoryID([]string{"Наука"}), CategoryID([]string{"Наука", "Физика"})}) {
+		t.Errorf("CategoryIDs = %v", ids)
+	}
+}
+
+func TestCategoryIDPath(t *testing.T) {
+	// Одноимённые категории с разными родителями
+	history := NewCategories([]string{"История", "Россия"})
+	geography := NewCategories([]string{"География", "Россия"})
+	if *history[1].ID == *geography[1].ID {
+		t.Error("categories with the same name under different parents share an ID")
+	}
+	if *history[1].ID == CategoryID([]string{"Россия"}) {
+		t.Error("nested category shares an ID with the root category of the same name")
+	}
+	if CategoryID([]string{"Наука", "Физика"}) != CategoryID([]string{"наука", " Физика "}) {
+		t.Error("CategoryID depends on case or spacing")
+	}
+	if CategoryID([]string{"Наука/Физика"}) == CategoryID([]string{"Наука", "Физика"}) {
+		t.Error("level name with a slash shares an ID with the nested path")
+	}
+}
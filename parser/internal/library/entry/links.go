@@ -0,0 +1,60 @@
+package entry
+
+import (
+	"fmt"
+
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// BookAuthor связь книги с автором
+type BookAuthor struct {
+	ID         *int64 `json:"-"`
+	SourceUUID string `json:"source_uuid"`
+	Source     string `json:"source"`
+	BookID     int64  `json:"book_id"`
+	AuthorID   int64  `json:"author_id"`
+	Role       string `json:"role"` // роль участника в этой книге, см. book.RoleAuthor
+}
+
+// BookCategory связь книги с категорией
+type BookCategory struct {
+	ID         *int64 `json:"-"`
+	SourceUUID string `json:"source_uuid"`
+	Source     string `json:"source"`
+	BookID     int64  `json:"book_id"`
+	CategoryID int64  `json:"category_id"`
+}
+
+// BookAuthorTable таблица связей книг и авторов
+var BookAuthorTable = storage.NewTable("book_authors", 2, []storage.Column{
+	{Name: "source_uuid", Type: "string"},
+	{Name: "source", Type: "string"},
+	{Name: "book_id", Type: "bigint"},
+	{Name: "author_id", Type: "bigint"},
+	{Name: "role", Type: "string"},
+}, func(l *BookAuthor) *int64 { return l.ID })
+
+// BookCategoryTable таблица связей книг и категорий
+var BookCategoryTable = storage.NewTable("book_categories", 1, []storage.Column{
+	{Name: "source_uuid", Type: "string"},
+	{Name: "source", Type: "string"},
+	{Name: "book_id", Type: "bigint"},
+	{Name: "category_id", Type: "bigint"},
+}, func(l *BookCategory) *int64 { return l.ID })
+
+// BookLinks возвращает связи книги с её авторами и категориями.
+// ID связи выводится из пары ID, поэтому повторная запись заменяет строку.
+func BookLinks(b *Book) ([]BookAuthor, []BookCategory) {
+	var authors []BookAuthor
+	for _, p := range b.Persons {
+		id := AuthorID(p.Name)
+		linkID := hashID(fmt.Sprintf("%d:%d", *b.ID, id))
+		authors = append(authors, BookAuthor{ID: &linkID, SourceUUID: b.SourceUUID, Source: b.Source, BookID: *b.ID, AuthorID: id, Role: p.Role})
+	}
+	var categories []BookCategory
+	for _, id := range b.CategoryIDs {
+		linkID := hashID(fmt.Sprintf("%d:%d", *b.ID, id))
+		categories = append(categories, BookCategory{ID: &linkID, SourceUUID: b.SourceUUID, Source: b.Source, BookID: *b.ID, CategoryID: id})
+	}
+	return authors, categories
+}
@@ -0,0 +1,13 @@
+package entry
+
+// BookRef автор, жанр и название книги, как они записаны в её параграфах.
+// Строки таблиц authors, categories и titles нужны, пока на них ссылается
+// хотя бы один параграф, поэтому после удаления или переиндексации книги
+// ставшие ненужными строки удаляются.
+type BookRef struct {
+	Author      string
+	Genre       string
+	Title       string
+	AuthorIDs   []int64 // ID строк authors, см. AuthorID
+	CategoryIDs []int64 // ID строк categories, см. CategoryID
+}
@@ -1,9 +1,12 @@
 package entry
 
-import "github.com/terratensor/library/parser/internal/library/book"
+import (
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/storage"
+)
 
 type Title struct {
-	ID          *int64 `json:"id,omitempty"`
+	ID          *int64 `json:"-"`
 	Title       string `json:"title"`
 	EntryType   string `json:"entry_type"`
 	Description string `json:"description"`
@@ -11,18 +14,26 @@ type Title struct {
 	UpdatedAt   int64  `json:"updated_at"`
 }
 
+// TitleTable таблица заголовков
+var TitleTable = storage.NewTable("titles", 1, []storage.Column{
+	{Name: "title", Type: "string attribute indexed"},
+	{Name: "entry_type", Type: "string"},
+	{Name: "description", Type: "text"},
+	{Name: "created_at", Type: "timestamp"},
+	{Name: "updated_at", Type: "timestamp"},
+}, func(t *Title) *int64 { return t.ID })
+
 func NewTitle(title string, entryType string) *Title {
+	id := TitleID(title)
 	return &Title{
+		ID:        &id,
 		Title:     title,
 		EntryType: entryType,
 	}
 }
 
 func NewTitleFromTitleList(titleList *book.TitleList) *Title {
-	return &Title{
-		Title:     titleList.Title,
-		EntryType: "book",
-	}
+	return NewTitle(titleList.Title, "book")
 }
 
 func (t *Title) SetDescription(description string) {
@@ -0,0 +1,249 @@
+// Package mapping сопоставляет жанры и папки из имён файлов с отображаемыми
+// названиями по правилам: точным, glob- и regex-шаблонам.
+package mapping
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PathSeparator разделяет уровни таксономии в названии: «Наука > Физика»
+const PathSeparator = ">"
+
+// Виды правил
+const (
+	KindExact = "exact"
+	KindGlob  = "glob"
+	KindRegex = "regex"
+)
+
+// Rule правило сопоставления. Образец сравнивается с нормализованным значением:
+// без учёта регистра, лишних пробелов и различия «ё»/«е».
+type Rule struct {
+	Pattern string // образец, как записан в файле
+	Target  string // название, в которое отображается значение, уровни разделены PathSeparator
+	Kind    string // KindExact, KindGlob или KindRegex
+	Source  string // файл и строка правила
+	norm    string
+	re      *regexp.Regexp
+}
+
+// String описывает правило для отчётов
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s %s %q", r.Source, r.Kind, r.Pattern)
+}
+
+// NewRule создаёт правило. Образец с префиксом «re:» — регулярное выражение,
+// в Target можно ссылаться на его группы ($1), образец с символами *, ? или [ — glob,
+// остальные сравниваются целиком.
+func NewRule(pattern, target, source string) (Rule, error) {
+	r := Rule{Pattern: pattern, Target: strings.TrimSpace(target), Source: source}
+	switch {
+	case strings.HasPrefix(pattern, "re:"):
+		re, err := regexp.Compile("(?i)" + strings.TrimPrefix(pattern, "re:"))
+		if err != nil {
+			return r, fmt.Errorf("%s: %w", source, err)
+		}
+		r.Kind, r.re = KindRegex, re
+	case strings.ContainsAny(pattern, "*?["):
+		r.Kind, r.norm = KindGlob, Normalize(pattern)
+		if _, err := path.Match(r.norm, ""); err != nil {
+			return r, fmt.Errorf("%s: %w", source, err)
+		}
+	default:
+		r.Kind, r.norm = KindExact, Normalize(pattern)
+	}
+	return r, nil
+}
+
+// Normalize приводит значение к виду, в котором оно сравнивается с образцами
+func Normalize(s string) string {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	return strings.ReplaceAll(s, "ё", "е")
+}
+
+// Match результат сопоставления значения
+type Match struct {
+	Value  string   // исходное значение
+	Target string   // итоговое название: последний уровень Path
+	Path   []string // уровни таксономии от корня, для несопоставленного значения — оно само
+	Rule   *Rule    // сработавшее правило, nil если значение не сопоставлено
+}
+
+// Mapper набор правил. Точные правила проверяются первыми, затем glob- и regex-
+// правила в порядке файла. Mapper считает срабатывания правил и несопоставленные
+// значения, nil Mapper оставляет значения как есть.
+type Mapper struct {
+	exact    map[string]*Rule
+	patterns []*Rule
+
+	mu       sync.Mutex
+	fired    map[*Rule]int
+	unmapped map[string]int
+}
+
+// New создаёт Mapper из правил
+func New(rules []Rule) *Mapper {
+	m := &Mapper{
+		exact:    make(map[string]*Rule),
+		fired:    make(map[*Rule]int),
+		unmapped: make(map[string]int),
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.Kind == KindExact {
+			if _, ok := m.exact[r.norm]; !ok {
+				m.exact[r.norm] = r
+			}
+			continue
+		}
+		m.patterns = append(m.patterns, r)
+	}
+	return m
+}
+
+// Map сопоставляет значение с правилами
+func (m *Mapper) Map(value string) Match {
+	value = strings.TrimSpace(value)
+	match := Match{Value: value, Target: value, Path: []string{value}}
+	if m == nil || value == "" {
+		return match
+	}
+
+	rule, target := m.match(Normalize(value))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rule == nil {
+		m.unmapped[value]++
+		return match
+	}
+	m.fired[rule]++
+
+	match.Rule, match.Path = rule, SplitPath(target)
+	match.Target = match.Path[len(match.Path)-1]
+	return match
+}
+
+// match возвращает первое подходящее правило и название для значения norm
+func (m *Mapper) match(norm string) (*Rule, string) {
+	if r, ok := m.exact[norm]; ok {
+		return r, r.Target
+	}
+	for _, r := range m.patterns {
+		switch r.Kind {
+		case KindGlob:
+			if ok, _ := path.Match(r.norm, norm); ok {
+				return r, r.Target
+			}
+		case KindRegex:
+			if sm := r.re.FindStringSubmatchIndex(norm); sm != nil {
+				return r, string(r.re.ExpandString(nil, r.Target, norm, sm))
+			}
+		}
+	}
+	return nil, ""
+}
+
+// SplitPath делит название на уровни таксономии
+func SplitPath(target string) []string {
+	var levels []string
+	for _, level := range strings.Split(target, PathSeparator) {
+		if level = strings.TrimSpace(level); level != "" {
+			levels = append(levels, level)
+		}
+	}
+	if len(levels) == 0 {
+		return []string{strings.TrimSpace(target)}
+	}
+	return levels
+}
+
+// Unmapped возвращает несопоставленные значения с количеством обращений
+func (m *Mapper) Unmapped() map[string]int {
+	out := make(map[string]int)
+	if m == nil {
+		return out
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for v, n := range m.unmapped {
+		out[v] = n
+	}
+	return out
+}
+
+// Fired возвращает количество срабатываний каждого правила
+func (m *Mapper) Fired() map[*Rule]int {
+	out := make(map[*Rule]int)
+	if m == nil {
+		return out
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for r, n := range m.fired {
+		out[r] = n
+	}
+	return out
+}
+
+// LoadCSV читает правила из CSV с колонками «образец,название»
+func LoadCSV(path string) (*Mapper, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("mapping: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2 // Ожидаем ровно 2 колонки
+	reader.LazyQuotes = true   // Для обработки строк в кавычках
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("mapping: %s: %w", path, err)
+	}
+
+	rules := make([]Rule, 0, len(records))
+	for n, record := range records {
+		r, err := NewRule(strings.TrimSpace(record[0]), record[1], fmt.Sprintf("%s:%d", path, n+1))
+		if err != nil {
+			return nil, fmt.Errorf("mapping: %w", err)
+		}
+		rules = append(rules, r)
+	}
+	return New(rules), nil
+}
+
+// LoadYAML читает правила из секции mappings YAML-файла. Порядок glob-
+// и regex-правил сохраняется.
+func LoadYAML(path string) (*Mapper, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("mapping: %w", err)
+	}
+	var file struct {
+		Mappings yaml.Node `yaml:"mappings"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("mapping: %s: %w", path, err)
+	}
+
+	var rules []Rule
+	nodes := file.Mappings.Content
+	for i := 0; i+1 < len(nodes); i += 2 {
+		key, value := nodes[i], nodes[i+1]
+		r, err := NewRule(key.Value, value.Value, fmt.Sprintf("%s:%d", path, key.Line))
+		if err != nil {
+			return nil, fmt.Errorf("mapping: %w", err)
+		}
+		rules = append(rules, r)
+	}
+	return New(rules), nil
+}
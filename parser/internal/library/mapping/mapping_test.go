@@ -0,0 +1,93 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMapperMap(t *testing.T) {
+	var rules []Rule
+	for _, r := range [][2]string{
+		{"Военное дело", "Военное дело"},
+		{"Физика*", "Наука > Физика"},
+		{"re:^(химия|биология),", "Наука > $1"},
+		{"*история*", "История"},
+		{"Военная история", "Военное дело"},
+	} {
+		rule, err := NewRule(r[0], r[1], "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	m := New(rules)
+
+	tests := []struct {
+		value    string
+		wantPath []string
+		wantKind string
+	}{
+		{"  военное   ДЕЛО ", []string{"Военное дело"}, KindExact},
+		{"Физика твёрдого тела", []string{"Наука", "Физика"}, KindGlob},
+		{"Химия, биохимия", []string{"Наука", "химия"}, KindRegex},
+		{"Военная история", []string{"Военное дело"}, KindExact}, // точные правила раньше шаблонов
+		{"История России", []string{"История"}, KindGlob},
+		{"Фантастика", []string{"Фантастика"}, ""},
+	}
+	for _, tt := range tests {
+		got := m.Map(tt.value)
+		if !reflect.DeepEqual(got.Path, tt.wantPath) || got.Target != tt.wantPath[len(tt.wantPath)-1] {
+			t.Errorf("Map(%q) = %v (%q), want %v", tt.value, got.Path, got.Target, tt.wantPath)
+		}
+		kind := ""
+		if got.Rule != nil {
+			kind = got.Rule.Kind
+		}
+		if kind != tt.wantKind {
+			t.Errorf("Map(%q) fired %q rule, want %q", tt.value, kind, tt.wantKind)
+		}
+	}
+
+	m.Map("Фантастика")
+	if got := m.Unmapped(); !reflect.DeepEqual(got, map[string]int{"Фантастика": 2}) {
+		t.Errorf("Unmapped() = %v", got)
+	}
+	if got := len(m.Fired()); got != 5 {
+		t.Errorf("Fired() has %d rules, want 5", got)
+	}
+
+	var nilMapper *Mapper
+	if got := nilMapper.Map("Жанр"); got.Target != "Жанр" || got.Rule != nil {
+		t.Errorf("nil Mapper changed value: %+v", got)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "folders.yaml")
+	data := "mappings:\n  flibusta_*: Флибуста\n  flibusta_2023: Флибуста 2023\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadYAML(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Map("flibusta_2023"); got.Target != "Флибуста 2023" {
+		t.Errorf("exact rule lost to glob: %+v", got)
+	}
+	got := m.Map("flibusta_2025")
+	if got.Target != "Флибуста" || got.Rule.Source != path+":2" {
+		t.Errorf("Map(flibusta_2025) = %q by %v", got.Target, got.Rule)
+	}
+}
+
+func TestNewRuleInvalid(t *testing.T) {
+	if _, err := NewRule("re:(", "x", "test"); err == nil {
+		t.Error("invalid regex accepted")
+	}
+	if _, err := NewRule("[", "x", "test"); err == nil {
+		t.Error("invalid glob accepted")
+	}
+}
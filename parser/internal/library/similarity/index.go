@@ -0,0 +1,223 @@
+package similarity
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const (
+	// bands × rows = MinHashSize. Книги с мерой Жаккара 0.5 становятся
+	// кандидатами с вероятностью около 0.87, с 0.8 — почти наверняка.
+	bands = 32
+	rows  = MinHashSize / bands
+
+	// chunkDistance наибольшее расстояние Хэмминга между SimHash почти
+	// одинаковых параграфов. SimHash делится на chunkDistance+1 частей,
+	// хотя бы одна из них у таких параграфов совпадает.
+	chunkDistance = 3
+	chunkParts    = chunkDistance + 1
+	chunkPartBits = 64 / chunkParts
+)
+
+// Doc сигнатуры книги
+type Doc struct {
+	ID        string   // SourceUUID, одинаковые файлы имеют один ID
+	Path      string   // путь к файлу, версии одного файла не сравниваются
+	MinHash   *MinHash // сигнатура всей книги
+	SimHashes []uint64 // SimHash параграфов, nil для книг из файла состояния
+}
+
+// Match книга, содержимое которой похоже на содержимое другой
+type Match struct {
+	ID          string  `json:"id"`
+	Path        string  `json:"path"`
+	Similarity  float64 `json:"similarity"`  // оценка меры Жаккара шинглов книг
+	Containment float64 `json:"containment"` // доля параграфов меньшей книги, почти совпадающих с параграфами другой
+	Exact       bool    `json:"exact"`       // файл с тем же содержимым и тем же ID
+}
+
+type chunkRef struct {
+	doc, chunk int32
+}
+
+// Index LSH-индекс сигнатур книг. Кандидаты в дубликаты ищутся по полосам
+// MinHash, кандидаты в частичные копии — по частям SimHash параграфов,
+// поэтому книга не сравнивается со всеми проиндексированными.
+type Index struct {
+	mu     sync.Mutex
+	docs   []*Doc                // nil для удалённых книг
+	byID   map[string][]int32    // ID → книги, файлы с одинаковым содержимым
+	bands  map[uint64][]int32    // хэш полосы MinHash → книги
+	chunks map[uint64][]chunkRef // часть SimHash с номером части → параграфы
+}
+
+// NewIndex создаёт пустой индекс
+func NewIndex() *Index {
+	return &Index{
+		byID:   make(map[string][]int32),
+		bands:  make(map[uint64][]int32),
+		chunks: make(map[uint64][]chunkRef),
+	}
+}
+
+// Add находит книги индекса, похожие на d, и добавляет d в индекс.
+// Возвращает книги, у которых оценка меры Жаккара не ниже minSimilarity
+// или доля общих параграфов не ниже minContainment, по убыванию сходства.
+func (x *Index) Add(d *Doc, minSimilarity, minContainment float64) []Match {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	matches := x.query(d, minSimilarity, minContainment)
+	x.insert(d)
+	return matches
+}
+
+// Load добавляет d в индекс без поиска похожих, например книгу,
+// проиндексированную в прошлый запуск
+func (x *Index) Load(d *Doc) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.insert(d)
+}
+
+// Remove удаляет d из индекса, например книгу, не проиндексированную
+// как почти точная копия другой. Возвращает true, если в индексе остались
+// файлы с тем же ID: их параграфы записаны под тем же UUID.
+func (x *Index) Remove(d *Doc) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	shared := false
+	for _, n := range x.byID[d.ID] {
+		switch x.docs[n] {
+		case d:
+			x.docs[n] = nil
+		case nil:
+		default:
+			shared = true
+		}
+	}
+	return shared
+}
+
+func (x *Index) query(d *Doc, minSimilarity, minContainment float64) []Match {
+	// Файлы с тем же содержимым — кандидаты, даже если у книги нет текста
+	candidates := make(map[int32]bool)
+	for _, n := range x.byID[d.ID] {
+		candidates[n] = true
+	}
+	if d.MinHash != nil && !d.MinHash.Empty() {
+		for band := 0; band < bands; band++ {
+			for _, n := range x.bands[bandKey(d.MinHash, band)] {
+				candidates[n] = true
+			}
+		}
+	}
+
+	// Для каждого параграфа d — книги, в которых есть почти такой же параграф
+	matched := make(map[int32]int)
+	for _, h := range d.SimHashes {
+		if h == 0 {
+			// Параграф без слов
+			continue
+		}
+		found := make(map[int32]bool)
+		for part := 0; part < chunkParts; part++ {
+			for _, ref := range x.chunks[partKey(h, part)] {
+				other := x.docs[ref.doc]
+				if found[ref.doc] || other == nil || Hamming(h, other.SimHashes[ref.chunk]) > chunkDistance {
+					continue
+				}
+				found[ref.doc] = true
+			}
+		}
+		for n := range found {
+			matched[n]++
+			candidates[n] = true
+		}
+	}
+
+	var matches []Match
+	for n := range candidates {
+		other := x.docs[n]
+		if other == nil || other.Path == d.Path {
+			// Удалённая книга или прежняя версия того же файла
+			continue
+		}
+		if other.ID == d.ID {
+			// Побайтовая копия: параграфы обоих файлов записаны под одним UUID
+			matches = append(matches, Match{ID: other.ID, Path: other.Path, Similarity: 1, Containment: 1, Exact: true})
+			continue
+		}
+		m := Match{ID: other.ID, Path: other.Path}
+		if d.MinHash != nil && other.MinHash != nil {
+			m.Similarity = d.MinHash.Similarity(other.MinHash)
+		}
+		if smaller := min(len(d.SimHashes), len(other.SimHashes)); smaller > 0 {
+			m.Containment = min(1, float64(matched[n])/float64(smaller))
+		}
+		if m.Similarity >= minSimilarity || m.Containment >= minContainment {
+			matches = append(matches, m)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Exact != matches[j].Exact {
+			return matches[i].Exact
+		}
+		if matches[i].Similarity != matches[j].Similarity {
+			return matches[i].Similarity > matches[j].Similarity
+		}
+		if matches[i].Containment != matches[j].Containment {
+			return matches[i].Containment > matches[j].Containment
+		}
+		return matches[i].Path < matches[j].Path
+	})
+	return matches
+}
+
+func (x *Index) insert(d *Doc) {
+	n := int32(len(x.docs))
+	x.docs = append(x.docs, d)
+	x.byID[d.ID] = append(x.byID[d.ID], n)
+	if d.MinHash != nil && !d.MinHash.Empty() {
+		for band := 0; band < bands; band++ {
+			key := bandKey(d.MinHash, band)
+			x.bands[key] = append(x.bands[key], n)
+		}
+	}
+	for c, h := range d.SimHashes {
+		if h == 0 {
+			continue
+		}
+		for part := 0; part < chunkParts; part++ {
+			key := partKey(h, part)
+			x.chunks[key] = append(x.chunks[key], chunkRef{doc: n, chunk: int32(c)})
+		}
+	}
+}
+
+// Len возвращает количество книг в индексе
+func (x *Index) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.docs)
+}
+
+// bandKey хэш полосы band сигнатуры с её номером
+func bandKey(m *MinHash, band int) uint64 {
+	var b [4 * (rows + 1)]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(band))
+	for i := 0; i < rows; i++ {
+		binary.LittleEndian.PutUint32(b[4*(i+1):], m[band*rows+i])
+	}
+	h := fnv.New64a()
+	h.Write(b[:])
+	return h.Sum64()
+}
+
+// partKey часть part SimHash с её номером в старших битах
+func partKey(h uint64, part int) uint64 {
+	v := (h >> (part * chunkPartBits)) & (1<<chunkPartBits - 1)
+	return uint64(part)<<chunkPartBits | v
+}
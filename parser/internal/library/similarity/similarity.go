@@ -0,0 +1,155 @@
+// Package similarity вычисляет сигнатуры содержимого для поиска почти
+// одинаковых текстов: SimHash параграфов и MinHash книг, и индексирует их
+// для поиска дубликатов и частичных копий (LSH).
+package similarity
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// shingleSize слов в одном шингле
+const shingleSize = 4
+
+// words разбивает текст на слова в нижнем регистре, «ё» считается «е»
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ReplaceAll(strings.ToLower(text), "ё", "е"), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// shingles вызывает fn с хэшем каждого шингла текста. Текст короче шингла
+// даёт один шингл из всех слов.
+func shingles(text string, fn func(h uint64)) {
+	ws := words(text)
+	if len(ws) == 0 {
+		return
+	}
+	n := shingleSize
+	if len(ws) < n {
+		n = len(ws)
+	}
+	for i := 0; i+n <= len(ws); i++ {
+		h := fnv.New64a()
+		for _, w := range ws[i : i+n] {
+			h.Write([]byte(w))
+			h.Write([]byte{0})
+		}
+		fn(h.Sum64())
+	}
+}
+
+// SimHash возвращает 64-битный SimHash текста по его шинглам. У почти
+// одинаковых текстов SimHash отличается в немногих битах, см. Hamming.
+func SimHash(text string) uint64 {
+	var weights [64]int
+	shingles(text, func(h uint64) {
+		for i := range weights {
+			if h&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	})
+	var sum uint64
+	for i, w := range weights {
+		if w > 0 {
+			sum |= 1 << i
+		}
+	}
+	return sum
+}
+
+// Hamming возвращает количество различающихся битов
+func Hamming(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// MinHashSize количество хэш-функций MinHash
+const MinHashSize = 128
+
+// MinHash сигнатура множества шинглов книги: минимумы MinHashSize хэш-функций.
+// Доля совпадающих минимумов двух сигнатур оценивает меру Жаккара множеств.
+type MinHash [MinHashSize]uint32
+
+// NewMinHash создаёт пустую сигнатуру
+func NewMinHash() *MinHash {
+	m := &MinHash{}
+	for i := range m {
+		m[i] = ^uint32(0)
+	}
+	return m
+}
+
+// seeds сдвиги хэш-функций MinHash
+var seeds = func() [MinHashSize]uint64 {
+	var s [MinHashSize]uint64
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range s {
+		x = mix(x + uint64(i))
+		s[i] = x
+	}
+	return s
+}()
+
+// mix финализатор splitmix64
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// Add добавляет шинглы текста в сигнатуру
+func (m *MinHash) Add(text string) {
+	shingles(text, func(h uint64) {
+		for i, seed := range seeds {
+			if v := uint32(mix(h ^ seed)); v < m[i] {
+				m[i] = v
+			}
+		}
+	})
+}
+
+// Empty сообщает, что в сигнатуру не добавлено ни одного шингла
+func (m *MinHash) Empty() bool {
+	return m[0] == ^uint32(0) && m[1] == ^uint32(0)
+}
+
+// Similarity оценивает меру Жаккара множеств шинглов двух сигнатур
+func (m *MinHash) Similarity(o *MinHash) float64 {
+	same := 0
+	for i := range m {
+		if m[i] == o[i] {
+			same++
+		}
+	}
+	return float64(same) / MinHashSize
+}
+
+// Bytes кодирует сигнатуру для хранения
+func (m *MinHash) Bytes() []byte {
+	b := make([]byte, 4*MinHashSize)
+	for i, v := range m {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return b
+}
+
+// ParseMinHash декодирует сигнатуру, записанную Bytes. Возвращает nil,
+// если длина данных не подходит.
+func ParseMinHash(b []byte) *MinHash {
+	if len(b) != 4*MinHashSize {
+		return nil
+	}
+	m := &MinHash{}
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return m
+}
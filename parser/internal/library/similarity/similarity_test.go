@@ -0,0 +1,97 @@
+package similarity
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// text возвращает n различных предложений, начиная с from
+func text(from, n int) []string {
+	var pars []string
+	for i := from; i < from+n; i++ {
+		pars = append(pars, fmt.Sprintf("Параграф номер %d рассказывает о событии %d года и о людях, живших в городе %d, их делах и заботах.", i, 1800+i, i*7))
+	}
+	return pars
+}
+
+func doc(id string, pars []string) *Doc {
+	d := &Doc{ID: id, Path: "/" + id, MinHash: NewMinHash()}
+	for _, p := range pars {
+		d.MinHash.Add(p)
+		d.SimHashes = append(d.SimHashes, SimHash(p))
+	}
+	return d
+}
+
+func TestSimHash(t *testing.T) {
+	a := "Мороз и солнце; день чудесный! Еще ты дремлешь, друг прелестный — пора, красавица, проснись: открой сомкнуты негой взоры навстречу северной Авроры, звездою севера явись!"
+	b := strings.ReplaceAll(a, "Еще", "Ещё") + " "
+	c := "Совсем другой текст о погоде, природе и прогулках по лесу в летний день, когда светит солнце и поют птицы."
+	if d := Hamming(SimHash(a), SimHash(b)); d != 0 {
+		t.Errorf("Hamming(a, b) = %d, want 0", d)
+	}
+	if d := Hamming(SimHash(a), SimHash(c)); d <= chunkDistance {
+		t.Errorf("Hamming(a, c) = %d, want > %d", d, chunkDistance)
+	}
+}
+
+func TestMinHashBytes(t *testing.T) {
+	m := NewMinHash()
+	m.Add(strings.Join(text(0, 3), " "))
+	if got := ParseMinHash(m.Bytes()); got == nil || *got != *m {
+		t.Errorf("ParseMinHash(Bytes()) = %v, want %v", got, m)
+	}
+	if ParseMinHash([]byte{1, 2}) != nil {
+		t.Error("ParseMinHash of short data is not nil")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	x := NewIndex()
+	if m := x.Add(doc("a", text(0, 40)), 0.9, 0.5); len(m) != 0 {
+		t.Fatalf("first book matches %v", m)
+	}
+
+	// Та же книга с одним изменённым параграфом
+	copyPars := text(0, 40)
+	copyPars[5] = "Совсем другой параграф."
+	m := x.Add(doc("b", copyPars), 0.9, 0.5)
+	if len(m) != 1 || m[0].ID != "a" || m[0].Similarity < 0.9 {
+		t.Errorf("near copy matches = %+v, want a with similarity >= 0.9", m)
+	}
+
+	// Первая треть книги: частичная копия
+	m = x.Add(doc("c", text(0, 15)), 0.9, 0.5)
+	if len(m) != 2 || m[0].Containment != 1 || m[0].Similarity >= 0.9 {
+		t.Errorf("partial copy matches = %+v, want a and b with containment 1", m)
+	}
+
+	// Другая книга и новая версия того же файла
+	if m := x.Add(doc("d", text(100, 40)), 0.9, 0.5); len(m) != 0 {
+		t.Errorf("different book matches %+v", m)
+	}
+	if m := x.Add(doc("a", text(0, 40)), 0.9, 0.5); len(m) != 2 {
+		t.Errorf("same path matches = %+v, want only b and c", m)
+	}
+
+	// Побайтовая копия в другом файле: тот же ID, обе версии a первыми
+	exact := doc("a", text(0, 40))
+	exact.Path = "/copy of a"
+	m = x.Add(exact, 0.9, 0.5)
+	if len(m) != 4 || !m[0].Exact || !m[1].Exact || m[0].ID != "a" || m[0].Similarity != 1 || m[2].Exact {
+		t.Errorf("exact copy matches = %+v, want both versions of a exact first", m)
+	}
+
+	// Удалённая из индекса книга не находится
+	if !x.Remove(exact) {
+		t.Error("Remove of exact copy reports no other files with the same id")
+	}
+	empty := &Doc{ID: "a", Path: "/empty copy of a"}
+	if m := x.Add(empty, 0.9, 0.5); len(m) != 2 || m[0].Path == exact.Path {
+		t.Errorf("matches after remove = %+v, want two versions of a", m)
+	}
+	if x.Remove(doc("e", nil)) {
+		t.Error("Remove of unknown doc reports other files with the same id")
+	}
+}
@@ -0,0 +1,256 @@
+package metadata
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+)
+
+// DuplicateFile файл книги в кластере дубликатов
+type DuplicateFile struct {
+	Path       string  `json:"path"`
+	Title      string  `json:"title"`
+	Size       int64   `json:"size"`
+	Format     string  `json:"format"`
+	OCRQuality float32 `json:"ocr_quality,omitempty"` // 0, если неизвестно
+	Similarity float64 `json:"similarity"`            // сходство названия с названием канонического файла
+}
+
+// Cluster файлы одной книги: с совпадающими после нормализации
+// или похожими названиями
+type Cluster struct {
+	Key       string          `json:"key"`       // нормализованное название канонического файла
+	Canonical string          `json:"canonical"` // рекомендуемый файл
+	Files     []DuplicateFile `json:"files"`     // канонический первым
+}
+
+// Виды книг с похожим содержимым
+const (
+	KindExact     = "exact"     // файл с тем же содержимым, проиндексирован как та же книга
+	KindDuplicate = "duplicate" // почти точная копия
+	KindPartial   = "partial"   // значительная часть параграфов совпадает
+)
+
+// ContentPair книга с содержимым, похожим на содержимое другой книги
+type ContentPair struct {
+	Path        string  `json:"path"`
+	Other       string  `json:"other"`       // книга, проиндексированная раньше
+	Similarity  float64 `json:"similarity"`  // оценка меры Жаккара шинглов текста
+	Containment float64 `json:"containment"` // доля совпадающих параграфов меньшей книги
+	Kind        string  `json:"kind"`
+	Skipped     bool    `json:"skipped,omitempty"` // книга не проиндексирована, см. duplicates.policy
+}
+
+// dupTitle нормализованное название и файлы с ним
+type dupTitle struct {
+	key    string
+	volume int
+	grams  []uint32 // хэши триграмм названия по возрастанию
+	files  []DuplicateFile
+}
+
+// DuplicateIndex находит дубликаты по названиям книг. Названия нормализуются
+// (book.NormalizeTitle) и сравниваются по словарю, а похожие — по мере Жаккара
+// на триграммах не ниже порога. Кандидаты ищутся по инвертированному индексу
+// префиксов наборов триграмм (prefix filtering), поэтому файл не сравнивается
+// со всеми предыдущими. Названия разных томов похожими не считаются.
+type DuplicateIndex struct {
+	mu        sync.Mutex
+	threshold float64
+	byKey     map[string]int
+	titles    []*dupTitle
+	prefixes  map[uint32][]int // триграмма → названия, в префиксе которых она есть
+	parent    []int            // объединение названий в кластеры
+}
+
+// NewDuplicateIndex создаёт индекс с порогом сходства названий от 0 до 1.
+// С порогом 1 и выше дубликатами считаются только совпадающие названия.
+func NewDuplicateIndex(threshold float64) *DuplicateIndex {
+	return &DuplicateIndex{
+		threshold: threshold,
+		byKey:     make(map[string]int),
+		prefixes:  make(map[uint32][]int),
+	}
+}
+
+// Add добавляет в индекс файл книги с названием title и номером тома volume
+func (d *DuplicateIndex) Add(title string, volume int, file DuplicateFile) {
+	key := book.NormalizeTitle(title)
+	if key == "" {
+		return
+	}
+	file.Title = title
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if i, ok := d.byKey[key]; ok {
+		d.titles[i].files = append(d.titles[i].files, file)
+		return
+	}
+	t := &dupTitle{key: key, volume: volume, grams: trigrams(key), files: []DuplicateFile{file}}
+	i := len(d.titles)
+	d.titles = append(d.titles, t)
+	d.parent = append(d.parent, i)
+	d.byKey[key] = i
+	if d.threshold >= 1 {
+		return
+	}
+
+	// Наборы со сходством не ниже порога пересекаются в префиксах
+	// длины |x| - ⌈t·|x|⌉ + 1 при общем порядке триграмм
+	seen := make(map[int]bool)
+	for _, g := range t.grams[:prefixLen(len(t.grams), d.threshold)] {
+		for _, j := range d.prefixes[g] {
+			if seen[j] {
+				continue
+			}
+			seen[j] = true
+			if d.titles[j].volume == t.volume && jaccard(t.grams, d.titles[j].grams) >= d.threshold {
+				d.union(i, j)
+			}
+		}
+		d.prefixes[g] = append(d.prefixes[g], i)
+	}
+}
+
+func (d *DuplicateIndex) find(i int) int {
+	for d.parent[i] != i {
+		d.parent[i] = d.parent[d.parent[i]]
+		i = d.parent[i]
+	}
+	return i
+}
+
+func (d *DuplicateIndex) union(i, j int) {
+	d.parent[d.find(i)] = d.find(j)
+}
+
+// Clusters возвращает кластеры из двух и более файлов, упорядоченные
+// по ключу. Канонический файл выбирается функцией betterFile.
+func (d *DuplicateIndex) Clusters() []Cluster {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	groups := make(map[int][]int)
+	for i := range d.titles {
+		root := d.find(i)
+		groups[root] = append(groups[root], i)
+	}
+
+	var clusters []Cluster
+	for _, members := range groups {
+		type member struct {
+			file  DuplicateFile
+			title *dupTitle
+		}
+		var files []member
+		for _, i := range members {
+			for _, f := range d.titles[i].files {
+				files = append(files, member{f, d.titles[i]})
+			}
+		}
+		if len(files) < 2 {
+			continue
+		}
+
+		best := 0
+		for n := range files {
+			if betterFile(files[n].file, files[best].file) {
+				best = n
+			}
+		}
+		canonical := files[best].title
+		c := Cluster{Key: canonical.key, Canonical: files[best].file.Path}
+		for n, m := range files {
+			m.file.Similarity = jaccard(m.title.grams, canonical.grams)
+			if n == best {
+				c.Files = append([]DuplicateFile{m.file}, c.Files...)
+				continue
+			}
+			c.Files = append(c.Files, m.file)
+		}
+		rest := c.Files[1:]
+		sort.Slice(rest, func(a, b int) bool { return rest[a].Path < rest[b].Path })
+		clusters = append(clusters, c)
+	}
+	sort.Slice(clusters, func(a, b int) bool {
+		if clusters[a].Key != clusters[b].Key {
+			return clusters[a].Key < clusters[b].Key
+		}
+		return clusters[a].Canonical < clusters[b].Canonical
+	})
+	return clusters
+}
+
+// formatRank предпочтение форматов: текстовые лучше сканов
+var formatRank = map[string]int{"docx": 3, "epub": 2, "pdf": 1}
+
+// betterFile сравнивает файлы для выбора канонического: выше качество OCR,
+// если оно известно для обоих, затем формат, затем больший размер
+func betterFile(a, b DuplicateFile) bool {
+	if a.OCRQuality > 0 && b.OCRQuality > 0 && a.OCRQuality != b.OCRQuality {
+		return a.OCRQuality > b.OCRQuality
+	}
+	if ra, rb := formatRank[strings.ToLower(a.Format)], formatRank[strings.ToLower(b.Format)]; ra != rb {
+		return ra > rb
+	}
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	return a.Path < b.Path
+}
+
+// trigrams возвращает хэши триграмм строки с пробелами по краям без повторов
+func trigrams(s string) []uint32 {
+	runes := []rune(" " + s + " ")
+	set := make(map[uint32]bool)
+	for i := 0; i+3 <= len(runes); i++ {
+		h := fnv.New32a()
+		h.Write([]byte(string(runes[i : i+3])))
+		set[h.Sum32()] = true
+	}
+	grams := make([]uint32, 0, len(set))
+	for g := range set {
+		grams = append(grams, g)
+	}
+	sort.Slice(grams, func(i, j int) bool { return grams[i] < grams[j] })
+	return grams
+}
+
+// prefixLen длина префикса набора из n элементов для порога t
+func prefixLen(n int, t float64) int {
+	p := n - int(math.Ceil(t*float64(n)-1e-9)) + 1
+	if p > n {
+		return n
+	}
+	if p < 1 {
+		return 1
+	}
+	return p
+}
+
+// jaccard мера Жаккара упорядоченных наборов
+func jaccard(a, b []uint32) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	common := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			common++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
@@ -0,0 +1,71 @@
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDuplicateIndex(t *testing.T) {
+	d := NewDuplicateIndex(0.8)
+	add := func(title string, volume int, path, format string, size int64) {
+		d.Add(title, volume, DuplicateFile{Path: path, Format: format, Size: size})
+	}
+	add("Война и мир. Том 1", 1, "/a/1.pdf", "pdf", 900)
+	add("война и мир, т. I", 1, "/b/1.docx", "docx", 100)
+	add("Война и мир. Том 2", 2, "/a/2.pdf", "pdf", 900)
+	add("Мастер и Маргарита", 0, "/a/mm.pdf", "pdf", 100)
+	add("Мастер и Маргарита (роман)", 0, "/b/mm.pdf", "pdf", 200)
+	add("Мастер и Маргарит", 0, "/c/mm.epub", "epub", 50)
+	add("Идиот", 0, "/a/idiot.pdf", "pdf", 100)
+
+	var got [][]string
+	for _, c := range d.Clusters() {
+		var paths []string
+		for _, f := range c.Files {
+			paths = append(paths, f.Path)
+		}
+		if c.Canonical != paths[0] {
+			t.Errorf("cluster %q: canonical %q is not first", c.Key, c.Canonical)
+		}
+		got = append(got, paths)
+	}
+	want := [][]string{
+		{"/b/1.docx", "/a/1.pdf"},
+		{"/c/mm.epub", "/a/mm.pdf"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Clusters() = %v, want %v", got, want)
+	}
+}
+
+func TestDuplicateIndexOCRQuality(t *testing.T) {
+	d := NewDuplicateIndex(1)
+	d.Add("Идиот", 0, DuplicateFile{Path: "/a.pdf", Format: "pdf", OCRQuality: 0.95})
+	d.Add("Идиот", 0, DuplicateFile{Path: "/b.docx", Format: "docx", OCRQuality: 0.6})
+	d.Add("Идиот (роман)", 0, DuplicateFile{Path: "/c.docx", Format: "docx", OCRQuality: 1})
+
+	clusters := d.Clusters()
+	if len(clusters) != 1 || clusters[0].Canonical != "/a.pdf" || len(clusters[0].Files) != 2 {
+		t.Errorf("Clusters() = %+v, want one cluster of /a.pdf and /b.docx", clusters)
+	}
+}
+
+func TestPrefixFilteringFindsAllPairs(t *testing.T) {
+	// Поиск по префиксам находит те же пары, что и сравнение всех со всеми
+	titles := []string{"Тихий Дон", "Тихий Дон.", "Тихий дон роман", "Тихая Дон", "Поднятая целина", "Поднятая целина 2", "Дон Кихот"}
+	for _, threshold := range []float64{0.5, 0.7, 0.9} {
+		d := NewDuplicateIndex(threshold)
+		for n, title := range titles {
+			d.Add(title, 0, DuplicateFile{Path: fmt.Sprint(n)})
+		}
+		for i := range d.titles {
+			for j := range d.titles {
+				similar := jaccard(d.titles[i].grams, d.titles[j].grams) >= threshold
+				if similar && d.find(i) != d.find(j) {
+					t.Errorf("threshold %v: %q and %q not clustered", threshold, d.titles[i].key, d.titles[j].key)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/lib/logger/sl"
+	"github.com/terratensor/library/parser/internal/library/book"
+)
+
+// Lints возвращает результаты проверки имён файлов не по шаблону, упорядоченные по пути
+func (mp *Processor) Lints() []book.FilenameLint {
+	mp.entryMutex.Lock()
+	defer mp.entryMutex.Unlock()
+
+	lints := append([]book.FilenameLint(nil), mp.lints...)
+	sort.Slice(lints, func(i, j int) bool { return lints[i].Path < lints[j].Path })
+	return lints
+}
+
+// SaveLintReport записывает отчёт об именах файлов не по шаблону: JSON,
+// если у path расширение .json, иначе CSV с причинами через «;»
+func (mp *Processor) SaveLintReport(path string) error {
+	lints := mp.Lints()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create lint report: %v", err)
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(lints); err != nil {
+			return fmt.Errorf("failed to write lint report: %v", err)
+		}
+	} else if err := WriteLintCSV(f, lints); err != nil {
+		return fmt.Errorf("failed to write lint report: %v", err)
+	}
+
+	mp.logger.Info("filename lint report saved", "path", path, "files", len(lints))
+	return nil
+}
+
+// WriteLintCSV записывает результаты проверки имён файлов в CSV,
+// причины перечисляются через «;»
+func WriteLintCSV(w io.Writer, lints []book.FilenameLint) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "issues", "genre", "author", "title", "sidecar", "suggested"})
+	for _, l := range lints {
+		cw.Write([]string{l.Path, strings.Join(l.Issues, ";"), l.Genre, l.Author, l.Title, l.Sidecar, l.Suggested})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ApplyRenames переименовывает файлы не по шаблону в предложенные имена вместе
+// с их файлами метаданных и строками metadata.csv папки и дописывает каждое
+// переименование в журнал undoLog (CSV «old,new», для строки metadata.csv —
+// имена файла книги и третьей колонкой путь к metadata.csv), по которому
+// UndoRenames возвращает прежние имена. Файлы, для которых имя не предложено
+// или занято, пропускаются.
+func (mp *Processor) ApplyRenames(undoLog string) (int, error) {
+	journal, err := openUndoLog(undoLog)
+	if err != nil {
+		return 0, err
+	}
+	defer journal.Close()
+	cw := csv.NewWriter(journal)
+
+	renamed := 0
+	for _, l := range mp.Lints() {
+		if l.Suggested == "" {
+			continue
+		}
+		newPath := filepath.Join(filepath.Dir(l.Path), l.Suggested)
+		if _, err := os.Lstat(newPath); err == nil {
+			mp.logWarning(fmt.Sprintf("rename skipped, %s already exists", newPath))
+			continue
+		}
+
+		folderMetadata := filepath.Join(filepath.Dir(l.Path), book.FolderMetadataFile)
+		oldName, newName := filepath.Base(l.Path), l.Suggested
+		hasRow, err := hasFolderRow(folderMetadata, oldName)
+		if err != nil {
+			return renamed, err
+		}
+		taken, err := hasFolderRow(folderMetadata, newName)
+		if err != nil {
+			return renamed, err
+		}
+		if taken {
+			mp.logWarning(fmt.Sprintf("rename skipped, %s already has a row for %s", folderMetadata, newName))
+			continue
+		}
+
+		moves := [][2]string{{l.Path, newPath}}
+		oldBase := strings.TrimSuffix(l.Path, filepath.Ext(l.Path))
+		newBase := strings.TrimSuffix(newPath, filepath.Ext(newPath))
+		for _, ext := range book.SidecarExts {
+			if _, err := os.Stat(oldBase + ext); err != nil {
+				continue
+			}
+			if _, err := os.Lstat(newBase + ext); err == nil {
+				mp.logWarning(fmt.Sprintf("sidecar %s not renamed, %s already exists", oldBase+ext, newBase+ext))
+				continue
+			}
+			moves = append(moves, [2]string{oldBase + ext, newBase + ext})
+		}
+		for _, m := range moves {
+			if err := os.Rename(m[0], m[1]); err != nil {
+				cw.Flush()
+				return renamed, fmt.Errorf("failed to rename %s: %v", m[0], err)
+			}
+			cw.Write(m[:])
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return renamed, fmt.Errorf("failed to write undo log: %v", err)
+			}
+		}
+		// Строка книги в metadata.csv иначе перестанет находиться по новому имени
+		if hasRow {
+			if err := renameFolderRow(folderMetadata, oldName, newName); err != nil {
+				return renamed, err
+			}
+			cw.Write([]string{oldName, newName, folderMetadata})
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return renamed, fmt.Errorf("failed to write undo log: %v", err)
+			}
+		}
+		renamed++
+	}
+
+	mp.logger.Info("files renamed", "renamed", renamed, "undo_log", undoLog)
+	return renamed, nil
+}
+
+// openUndoLog открывает журнал переименований для дописывания,
+// новому журналу записывает заголовок
+func openUndoLog(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open undo log: %v", err)
+	}
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		f.WriteString("old,new\n")
+	}
+	return f, nil
+}
+
+// UndoRenames возвращает прежние имена файлов по журналу undoLog в обратном
+// порядке. Переименования, которые уже отменены или мешают существующим файлам,
+// пропускаются.
+func UndoRenames(undoLog string, logger *slog.Logger) (int, error) {
+	f, err := os.Open(undoLog)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open undo log: %v", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read undo log: %v", err)
+	}
+
+	restored := 0
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if len(r) == 3 {
+			// Строка metadata.csv, уже отменённая строка не находится
+			if err := renameFolderRow(r[2], r[1], r[0]); err != nil {
+				logger.Error("undo failed", slog.String("path", r[2]), sl.Err(err))
+			}
+			continue
+		}
+		if len(r) != 2 || (r[0] == "old" && r[1] == "new") {
+			continue
+		}
+		oldPath, newPath := r[0], r[1]
+		if _, err := os.Lstat(newPath); errors.Is(err, os.ErrNotExist) {
+			// Уже отменено
+			continue
+		}
+		if _, err := os.Lstat(oldPath); err == nil {
+			logger.Warn("undo skipped, file exists", slog.String("path", oldPath))
+			continue
+		}
+		if err := os.Rename(newPath, oldPath); err != nil {
+			logger.Error("undo failed", slog.String("path", newPath), sl.Err(err))
+			continue
+		}
+		restored++
+	}
+	logger.Info("renames undone", slog.Int("restored", restored), slog.String("undo_log", undoLog))
+	return restored, nil
+}
+
+// readFolderRows читает metadata.csv path целиком и возвращает его строки
+// с номером колонки file. Для отсутствующего файла возвращает nil.
+func readFolderRows(path string) ([][]string, int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, -1, nil
+	}
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if len(records) > 0 {
+		for i, name := range records[0] {
+			if strings.ToLower(strings.TrimSpace(name)) == "file" {
+				return records, i, nil
+			}
+		}
+	}
+	return records, -1, nil
+}
+
+// hasFolderRow сообщает, есть ли в metadata.csv path строка книги name
+func hasFolderRow(path, name string) (bool, error) {
+	records, col, err := readFolderRows(path)
+	if err != nil || col < 0 {
+		return false, err
+	}
+	for _, r := range records[1:] {
+		if col < len(r) && strings.TrimSpace(r[col]) == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// renameFolderRow заменяет имя книги oldName на newName в колонке file
+// metadata.csv path. Если строки oldName нет, файл не меняется.
+func renameFolderRow(path, oldName, newName string) error {
+	records, col, err := readFolderRows(path)
+	if err != nil || col < 0 {
+		return err
+	}
+	found := false
+	for _, r := range records[1:] {
+		if col < len(r) && strings.TrimSpace(r[col]) == oldName {
+			r[col] = newName
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	if err := cw.WriteAll(records); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+)
+
+func TestApplyRenamesFolderMetadata(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "книга.docx")
+	newPath := filepath.Join(dir, "Роман_Иванов И. — Название.docx")
+	csvPath := filepath.Join(dir, book.FolderMetadataFile)
+	if err := os.WriteFile(oldPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(csvPath, []byte("file,title\nкнига.docx,Название\nдругая.docx,Другая\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mp, err := NewProcessor(Config{LogFilePath: filepath.Join(dir, "errors.log"), Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mp.Close()
+	mp.lints = []book.FilenameLint{{Path: oldPath, Suggested: filepath.Base(newPath)}}
+
+	undoLog := filepath.Join(dir, "undo.csv")
+	if n, err := mp.ApplyRenames(undoLog); err != nil || n != 1 {
+		t.Fatalf("ApplyRenames = %d, %v, want 1 file", n, err)
+	}
+	title := func(path string) string {
+		sc, err := book.NewSidecarReader().Find(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sc == nil {
+			return ""
+		}
+		return sc.Title
+	}
+	if got := title(newPath); got != "Название" {
+		t.Errorf("metadata of renamed file: title %q, want the metadata.csv row", got)
+	}
+	if got := title(filepath.Join(dir, "другая.docx")); got != "Другая" {
+		t.Errorf("other row changed: title %q", got)
+	}
+
+	if n, err := UndoRenames(undoLog, logger); err != nil || n != 1 {
+		t.Fatalf("UndoRenames = %d, %v, want 1 file", n, err)
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		t.Errorf("file not restored: %v", err)
+	}
+	if got := title(oldPath); got != "Название" {
+		t.Errorf("metadata after undo: title %q, want the metadata.csv row", got)
+	}
+}
@@ -1,30 +1,33 @@
 package metadata
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 
-	"github.com/google/uuid"
 	"github.com/terratensor/library/parser/internal/library/book"
 	"github.com/terratensor/library/parser/internal/library/entry"
-	"gopkg.in/yaml.v3"
+	"github.com/terratensor/library/parser/internal/library/mapping"
 )
 
 type Processor struct {
-	duplicates map[string][]string // key: title, value: список путей к файлов
+	duplicates *DuplicateIndex // дубликаты по нормализованным и похожим названиям
 	entries    map[string]book.TitleList
 	authors    map[string]entry.Author
 	categories map[string]entry.Category
 	titles     map[string]entry.Title
+	lints      []book.FilenameLint // имена файлов не по шаблону
 
-	genresMap  map[string]string // Маппинг жанров
-	foldersMap map[string]string // Новое поле для маппинга папок
+	genres   *mapping.Mapper     // правила сопоставления жанров
+	folders  *mapping.Mapper     // правила сопоставления папок
+	aliases  *book.Aliases       // Псевдонимы авторов
+	sidecars *book.SidecarReader // файлы метаданных рядом с книгами
 
 	dupMutex    sync.Mutex
 	entryMutex  sync.Mutex
@@ -37,8 +40,11 @@ type Processor struct {
 type Config struct {
 	GenresMapPath  string
 	FoldersMapPath string
-	LogFilePath    string
-	Logger         *slog.Logger
+	AliasesPath    string
+	// TitleSimilarity порог сходства названий дубликатов в процентах, 100 — только совпадающие
+	TitleSimilarity int
+	LogFilePath     string
+	Logger          *slog.Logger
 }
 
 func NewProcessor(cfg Config) (*Processor, error) {
@@ -47,61 +53,46 @@ func NewProcessor(cfg Config) (*Processor, error) {
 		return nil, fmt.Errorf("failed to open log file: %v", err)
 	}
 
-	// Загружаем маппинг жанров из CSV
-	genresMap := make(map[string]string)
+	// Загружаем правила сопоставления жанров и папок
+	var genres, folders *mapping.Mapper
 	if cfg.GenresMapPath != "" {
-		file, err := os.Open(cfg.GenresMapPath)
+		m, err := mapping.LoadCSV(cfg.GenresMapPath)
 		if err != nil {
-			log.Printf("Warning: could not open genres map file: %v", err)
+			log.Printf("Warning: could not load genres map: %v", err)
 		} else {
-			defer file.Close()
-
-			reader := csv.NewReader(file)
-			reader.Comma = ','
-			reader.FieldsPerRecord = 2
-			reader.LazyQuotes = true
-
-			records, err := reader.ReadAll()
-			if err != nil {
-				log.Printf("Warning: could not read genres map file: %v", err)
-			} else {
-				for _, record := range records {
-					if len(record) == 2 {
-						original := strings.TrimSpace(record[0])
-						mapped := strings.TrimSpace(record[1])
-						genresMap[original] = mapped
-					}
-				}
-			}
+			genres = m
 		}
 	}
-
-	// Загружаем маппинг папок из YAML
-	foldersMap := make(map[string]string)
 	if cfg.FoldersMapPath != "" {
-		data, err := os.ReadFile(cfg.FoldersMapPath)
+		m, err := mapping.LoadYAML(cfg.FoldersMapPath)
 		if err != nil {
-			log.Printf("Warning: could not open folders map file: %v", err)
+			log.Printf("Warning: could not load folders map: %v", err)
 		} else {
-			var config struct {
-				Mappings map[string]string `yaml:"mappings"`
-			}
-			if err := yaml.Unmarshal(data, &config); err != nil {
-				log.Printf("Warning: could not parse folders map file: %v", err)
-			} else {
-				foldersMap = config.Mappings
-			}
+			folders = m
+		}
+	}
+
+	// Загружаем псевдонимы авторов
+	var aliases *book.Aliases
+	if cfg.AliasesPath != "" {
+		a, err := book.LoadAliases(cfg.AliasesPath)
+		if err != nil {
+			log.Printf("Warning: could not load authors aliases: %v", err)
+		} else {
+			aliases = a
 		}
 	}
 
 	return &Processor{
-		duplicates: make(map[string][]string),
+		duplicates: NewDuplicateIndex(float64(cfg.TitleSimilarity) / 100),
 		entries:    make(map[string]book.TitleList),
 		authors:    make(map[string]entry.Author),
 		categories: make(map[string]entry.Category),
 		titles:     make(map[string]entry.Title),
-		genresMap:  genresMap,
-		foldersMap: foldersMap, // Добавляем маппинг папок
+		genres:     genres,
+		folders:    folders,
+		aliases:    aliases,
+		sidecars:   book.NewSidecarReader(),
 		errorLog:   f,
 		logger:     cfg.Logger,
 	}, nil
@@ -130,20 +121,39 @@ func (mp *Processor) ProcessFile(path string) error {
 	}
 
 	// Используем новый конструктор с маппингом папок
-	titleList := book.NewTitleList(path, mp.genresMap, mp.foldersMap)
+	titleList := book.NewTitleList(path, mp.genres, mp.folders, mp.aliases)
+	// Файл метаданных книги важнее имени файла
+	sidecar, err := mp.sidecars.Find(path)
+	if err != nil {
+		mp.logWarning(err.Error())
+	}
+	if lint := book.LintFilename(path, titleList.Folder, sidecar); lint != nil {
+		mp.entryMutex.Lock()
+		mp.lints = append(mp.lints, *lint)
+		mp.entryMutex.Unlock()
+	}
+	titleList.ApplySidecar(sidecar, mp.genres, mp.aliases)
 
 	// Проверка на пустой заголовок
 	if titleList.Title == "" {
 		msg := fmt.Sprintf("invalid filename format: %s", filename)
 		mp.logError(msg)
-		return fmt.Errorf(msg)
+		return errors.New(msg)
 	}
 
-	titleList.SourceUUID = uuid.New()
+	// SourceUUID выводится из содержимого файла, как при индексации,
+	// поэтому строки метаданных совпадают с книгами в индексе
+	if err := titleList.SetFingerprint(path); err != nil {
+		mp.logError(err.Error())
+		return err
+	}
 	titleList.Source = filename
 
-	// Остальной код без изменений
-	mp.processDuplicates(titleList, path)
+	file := DuplicateFile{Path: path, Format: strings.TrimPrefix(strings.ToLower(ext), ".")}
+	if info, err := os.Stat(path); err == nil {
+		file.Size = info.Size()
+	}
+	mp.duplicates.Add(titleList.Title, titleList.Volume, file)
 
 	// Обработка моделей (авторы, категории, заголовки)
 	mp.processModels(titleList)
@@ -156,28 +166,6 @@ func (mp *Processor) ProcessFile(path string) error {
 	return nil
 }
 
-func (mp *Processor) processDuplicates(tl *book.TitleList, path string) {
-	mp.dupMutex.Lock()
-	defer mp.dupMutex.Unlock()
-
-	if files, exists := mp.duplicates[tl.Title]; exists {
-		mp.duplicates[tl.Title] = append(files, path)
-		msg := fmt.Sprintf("duplicate title '%s' in files: %v", tl.Title, mp.duplicates[tl.Title])
-		mp.logWarning(msg)
-	} else {
-		// Проверяем, есть ли такой заголовок в уже обработанных файлах
-		for p, entry := range mp.entries {
-			if entry.Title == tl.Title {
-				mp.duplicates[tl.Title] = []string{p, path}
-				msg := fmt.Sprintf("duplicate title '%s' found between: %s and %s", tl.Title, p, path)
-				mp.logWarning(msg)
-				return
-			}
-		}
-		mp.duplicates[tl.Title] = []string{path}
-	}
-}
-
 func (mp *Processor) logError(msg string) error {
 	if _, err := mp.errorLog.WriteString(fmt.Sprintf("[ERROR] %s\n", msg)); err != nil {
 		return fmt.Errorf("failed to write to error log: %v", err)
@@ -191,6 +179,11 @@ func (mp *Processor) logWarning(msg string) {
 	mp.errorLog.WriteString(fmt.Sprintf("[WARN] %s\n", msg))
 }
 
+// UnmappedGenres возвращает жанры, для которых не нашлось правила сопоставления
+func (mp *Processor) UnmappedGenres() map[string]int {
+	return mp.genres.Unmapped()
+}
+
 func (mp *Processor) GetAuthors() []entry.Author {
 	mp.modelsMutex.Lock()
 	defer mp.modelsMutex.Unlock()
@@ -230,17 +223,23 @@ func (mp *Processor) processModels(tl *book.TitleList) {
 
 	// Обработка автора
 	if tl.Author != "" {
-		if _, exists := mp.authors[tl.Author]; !exists {
-			author := entry.NewAuthorFromTitleList(tl)
-			mp.authors[tl.Author] = *author
+		for _, author := range entry.NewAuthorsFromTitleList(tl) {
+			// Записи одного автора разного вида сводятся в одну строку
+			key := strconv.FormatInt(*author.ID, 10)
+			if existing, exists := mp.authors[key]; exists {
+				existing.Merge(author)
+				author = existing
+			}
+			mp.authors[key] = author
 		}
 	}
 
 	// Обработка категории
 	if tl.Genre != "" {
-		if _, exists := mp.categories[tl.Genre]; !exists {
-			category := entry.NewCategoryFromTitleList(tl)
-			mp.categories[tl.Genre] = *category
+		for _, category := range entry.NewCategoriesFromTitleList(tl) {
+			if _, exists := mp.categories[category.Path]; !exists {
+				mp.categories[category.Path] = category
+			}
 		}
 	}
 
@@ -252,3 +251,20 @@ func (mp *Processor) processModels(tl *book.TitleList) {
 		}
 	}
 }
+
+// Entries возвращает разобранные имена файлов по их путям
+func (mp *Processor) Entries() map[string]book.TitleList {
+	mp.entryMutex.Lock()
+	defer mp.entryMutex.Unlock()
+
+	entries := make(map[string]book.TitleList, len(mp.entries))
+	for path, tl := range mp.entries {
+		entries[path] = tl
+	}
+	return entries
+}
+
+// Duplicates возвращает кластеры дубликатов по названиям, упорядоченные по ключу
+func (mp *Processor) Duplicates() []Cluster {
+	return mp.duplicates.Clusters()
+}
@@ -0,0 +1,54 @@
+package metadata
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+)
+
+func TestProcessFileSourceUUIDFromContent(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Роман_Иванов И. — Книга.docx":  "содержимое",
+		"Роман_Иванов И. — Копия.docx":  "содержимое",
+		"Роман_Иванов И. — Другая.docx": "другое содержимое",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mp, err := NewProcessor(Config{
+		LogFilePath: filepath.Join(dir, "errors.log"),
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mp.Close()
+	for name := range files {
+		if err := mp.ProcessFile(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := mp.Entries()
+	for name := range files {
+		path := filepath.Join(dir, name)
+		hash, err := book.Fingerprint(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := entries[path].SourceUUID; got != book.SourceUUID(hash) {
+			t.Errorf("%s: SourceUUID %v, want the one derived from the fingerprint", name, got)
+		}
+	}
+	if entries[filepath.Join(dir, "Роман_Иванов И. — Книга.docx")].SourceUUID != entries[filepath.Join(dir, "Роман_Иванов И. — Копия.docx")].SourceUUID {
+		t.Error("identical files got different SourceUUIDs")
+	}
+}
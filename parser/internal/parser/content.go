@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/terratensor/library/parser/internal/library/similarity"
+	"github.com/terratensor/library/parser/internal/metadata"
+	"github.com/terratensor/library/parser/internal/state"
+)
+
+// Политики обработки почти точных копий, см. config.Duplicates.Policy
+const (
+	PolicyIndex = "index"
+	PolicySkip  = "skip"
+)
+
+// LoadSignatures добавляет в индекс сигнатур книги, проиндексированные
+// в прошлые запуски, чтобы новые книги сравнивались и с ними. Книги,
+// файлов которых больше нет, пропускаются. Возвращает количество книг.
+func (p *Parser) LoadSignatures(st *state.Store) (int, error) {
+	loaded := 0
+	err := st.ForEach(func(rec state.Record) error {
+		if rec.Status != state.StatusDone {
+			return nil
+		}
+		m := similarity.ParseMinHash(rec.MinHash)
+		if m == nil {
+			return nil
+		}
+		if _, err := os.Stat(rec.Path); err != nil {
+			return nil
+		}
+		p.content.Load(&similarity.Doc{ID: rec.SourceUUID, Path: rec.Path, MinHash: m})
+		loaded++
+		return nil
+	})
+	return loaded, err
+}
+
+// checkContent сравнивает содержимое книги с проиндексированными и запоминает
+// похожие для отчёта. По политике skip удаляет параграфы почти точной копии
+// уже проиндексированной книги и возвращает true: строка каталога для неё
+// не пишется. Параграфы к этому моменту уже записаны, так как сигнатура
+// книги известна только после разбиения всего текста.
+func (p *Parser) checkContent(ctx context.Context, res *Result) (bool, error) {
+	b := res.Book
+	if b == nil || b.MinHash == nil {
+		return false, nil
+	}
+	res.MinHash = b.MinHash.Bytes()
+
+	dc := p.cfg.Duplicates
+	doc := &similarity.Doc{
+		ID:        res.SourceUUID.String(),
+		Path:      b.Path,
+		MinHash:   b.MinHash,
+		SimHashes: b.SimHashes,
+	}
+	matches := p.content.Add(doc, float64(dc.ContentSimilarity)/100, float64(dc.PartialContainment)/100)
+	if len(matches) == 0 {
+		return false, nil
+	}
+
+	// Побайтовые копии идут первыми. Параграфы такой книги записаны под UUID
+	// уже проиндексированной, поэтому она не удаляется. Книгу с параграфами
+	// в очереди недоставленных тоже не удаляем: их дозапишет replay.
+	skip := dc.Policy == PolicySkip && res.Failed == 0 && !matches[0].Exact && matches[0].Similarity >= float64(dc.SkipSimilarity)/100
+	p.noteContent(doc.Path, matches, skip)
+	if !skip {
+		return false, nil
+	}
+
+	log.Printf("Warning: %v is a near copy of %v (similarity %.2f), not indexed", res.Source, matches[0].Path, matches[0].Similarity)
+	// Копия этого файла, добавленная в индекс за время его обработки,
+	// проиндексирована под тем же UUID, её параграфы не удаляются
+	if shared := p.content.Remove(doc); !shared {
+		if err := p.storage.DeleteBook(ctx, res.SourceUUID); err != nil {
+			return true, &storageError{fmt.Errorf("%v: error deleting duplicate: %w", res.Source, err)}
+		}
+	}
+	res.DuplicateOf = matches[0].Path
+	res.Chunks = 0
+	return true, nil
+}
+
+// noteContent запоминает книги с похожим содержимым для отчёта
+func (p *Parser) noteContent(path string, matches []similarity.Match, skipped bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for n, m := range matches {
+		kind := metadata.KindPartial
+		switch {
+		case m.Exact:
+			kind = metadata.KindExact
+		case m.Similarity >= float64(p.cfg.Duplicates.ContentSimilarity)/100:
+			kind = metadata.KindDuplicate
+		}
+		p.contentPairs = append(p.contentPairs, metadata.ContentPair{
+			Path:        path,
+			Other:       m.Path,
+			Similarity:  m.Similarity,
+			Containment: m.Containment,
+			Kind:        kind,
+			Skipped:     skipped && n == 0,
+		})
+	}
+}
+
+// ContentDuplicates возвращает книги с похожим содержимым, найденные
+// за время работы парсера, упорядоченные по путям
+func (p *Parser) ContentDuplicates() []metadata.ContentPair {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pairs := append([]metadata.ContentPair(nil), p.contentPairs...)
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Path != pairs[j].Path {
+			return pairs[i].Path < pairs[j].Path
+		}
+		return pairs[i].Other < pairs[j].Other
+	})
+	return pairs
+}
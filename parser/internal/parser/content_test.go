@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/metadata"
+)
+
+func TestSkipPolicyKeepsExactCopies(t *testing.T) {
+	dir := t.TempDir()
+	ms := newMemStore()
+	st := openTestState(t)
+	newParser := func(policy string) *Parser {
+		cfg := testConfig()
+		cfg.Duplicates.Policy = policy
+		cfg.Duplicates.ContentSimilarity = 80
+		cfg.Duplicates.SkipSimilarity = 80
+		p := NewParser(cfg, entry.New(ms, "library", "", nil, 100))
+		if _, err := p.LoadSignatures(st); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// Первый запуск индексирует и почти точную копию
+	writeDocx(t, filepath.Join(dir, "Жанр_Автор — Книга.docx"), "Текст книги", 10)
+	near := filepath.Join(dir, "Жанр_Автор — Почти.docx")
+	writeDocx(t, near, "Текст книги", 11)
+	indexDir(t, newParser(PolicyIndex), st, dir)
+
+	// Второй запуск с политикой skip: побайтовая копия проиндексированной
+	// книги делит с ней UUID, новая почти точная копия не индексируется
+	writeDocx(t, filepath.Join(dir, "Жанр_Автор — Почти (копия).docx"), "Текст книги", 11)
+	skipped := filepath.Join(dir, "Жанр_Автор — Третья.docx")
+	writeDocx(t, skipped, "Текст книги", 12)
+	p := newParser(PolicySkip)
+	indexDir(t, p, st, dir)
+
+	if n, ok := ms.count(sourceUUID(t, st, near)); n == 0 || !ok {
+		t.Errorf("copied book: %d chunks, catalogue row %v, want it kept", n, ok)
+	}
+	if n, ok := ms.count(sourceUUID(t, st, skipped)); n != 0 || ok {
+		t.Errorf("near copy: %d chunks, catalogue row %v, want deleted", n, ok)
+	}
+
+	want := []metadata.ContentPair{
+		{Path: filepath.Join(dir, "Жанр_Автор — Почти (копия).docx"), Other: near, Kind: metadata.KindExact},
+		{Path: skipped, Kind: metadata.KindDuplicate, Skipped: true},
+	}
+	for _, w := range want {
+		found := false
+		for _, pair := range p.ContentDuplicates() {
+			if pair.Path == w.Path && (w.Other == "" || pair.Other == w.Other) && pair.Kind == w.Kind && pair.Skipped == w.Skipped {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no pair %+v in %+v", w, p.ContentDuplicates())
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/deadletter"
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// sendToDeadLetter сохраняет недописанные параграфы книги в очередь недоставленных
+// и возвращает их количество. path — путь книги в каталоге, по нему replay
+// узнаёт, не заменена ли книга новой версией файла. Если хранилище сообщило, какие документы отклонены,
+// в очередь попадают только они, иначе весь пакет.
+func (p *Parser) sendToDeadLetter(titleList *book.TitleList, path string, pars []entry.Entry, cause error) int {
+	docs := p.storage.Documents(pars)
+	var werr *storage.WriteError
+	if errors.As(cause, &werr) {
+		docs = werr.Docs
+	}
+	if p.deadLetter == nil {
+		return len(docs)
+	}
+
+	rec, err := deadletter.NewRecord(deadletter.Book{
+		SourceUUID: titleList.SourceUUID.String(),
+		Source:     titleList.Source,
+		Path:       path,
+		Genre:      titleList.Genre,
+		Author:     titleList.Author,
+		Title:      titleList.Title,
+	}, docs, cause)
+	if err != nil {
+		log.Printf("error preparing dead letter record for %v: %v", titleList.Source, err)
+		return len(docs)
+	}
+	file, err := p.deadLetter.Put(rec)
+	if err != nil {
+		log.Printf("error writing dead letter record for %v: %v", titleList.Source, err)
+		return len(docs)
+	}
+	log.Printf("%d documents of %v written to dead letter queue: %v", len(docs), titleList.Source, file)
+	return len(docs)
+}
+
+// noteResult запоминает количество записанных параграфов книги
+// и книги, записанные не полностью, для итогового отчёта
+func (p *Parser) noteResult(res *Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.written == nil {
+		p.written = make(map[uuid.UUID]int)
+	}
+	// Одинаковые файлы дают одну книгу с теми же ID параграфов
+	p.written[res.SourceUUID] = res.Chunks - res.Failed
+	if res.Failed > 0 {
+		p.partial = append(p.partial, *res)
+	}
+}
+
+// WrittenChunks возвращает количество записанных параграфов каждой книги,
+// обработанной парсером, по её SourceUUID
+func (p *Parser) WrittenChunks() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make(map[string]int, len(p.written))
+	for id, n := range p.written {
+		out[id.String()] = n
+	}
+	return out
+}
+
+// PartialBooks возвращает книги, часть параграфов которых не удалось записать
+func (p *Parser) PartialBooks() []Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Result(nil), p.partial...)
+}
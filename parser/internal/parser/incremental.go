@@ -0,0 +1,255 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/state"
+)
+
+// ParseIncremental обрабатывает файл с учётом локального состояния индексации.
+// Неизменённые файлы пропускаются, у изменённых перед повторной индексацией
+// удаляются старые параграфы. Возвращает nil, если файл был пропущен.
+// Файлы метаданных пропускаются: они читаются вместе с книгами.
+func (p *Parser) ParseIncremental(ctx context.Context, st *state.Store, file os.DirEntry, path string) (*Result, error) {
+	if book.IsMetadataFile(file.Name()) {
+		return nil, nil
+	}
+	fp, err := filepath.Abs(filepath.Join(path, file.Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, ok, err := st.Get(fp)
+	if err != nil {
+		return nil, err
+	}
+	sidecar := p.sidecarFingerprint(fp)
+	if ok && rec.Unchanged(info, sidecar) {
+		return nil, nil
+	}
+
+	// Размер или время изменились, сверяем содержимое. Книгу с изменёнными
+	// метаданными индексируем заново: они важнее разобранных из имени файла.
+	hash, err := book.Fingerprint(fp)
+	if err != nil {
+		return nil, err
+	}
+	if ok && (rec.Status == state.StatusDone || rec.Status == state.StatusDuplicate) && rec.ContentHash == hash && rec.Sidecar == sidecar {
+		rec.Touch(info)
+		return nil, st.Put(rec)
+	}
+
+	id := book.SourceUUID(hash)
+	if ok && rec.SourceUUID == id.String() {
+		// Изменились только метаданные: связи книги с прежними авторами
+		// и категориями удаляются, параграфы записываются заново
+		if err := p.resetBook(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	// Пока файл обрабатывается, параграфы его новой версии не удаляются
+	// при изменении другого файла с тем же содержимым
+	defer p.acquire(id)()
+
+	// Файл изменился, удаляем параграфы предыдущей версии
+	if ok && rec.SourceUUID != "" && rec.SourceUUID != id.String() {
+		if err := p.releaseBook(ctx, st, fp, rec.SourceUUID); err != nil {
+			return nil, err
+		}
+	}
+
+	res, parseErr := p.Parse(ctx, file, path)
+	if err := record(st, fp, hash, sidecar, info, res, parseErr); err != nil {
+		return res, err
+	}
+	return res, parseErr
+}
+
+// Reindex принудительно переиндексирует один файл: удаляет его прежние параграфы
+// по UUID из состояния, если их не делит другой файл, и по UUID текущего
+// содержимого, затем обрабатывает файл заново. st может быть nil, тогда
+// состояние не обновляется.
+func (p *Parser) Reindex(ctx context.Context, st *state.Store, filePath string) (*Result, error) {
+	fp, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := book.Fingerprint(fp)
+	if err != nil {
+		return nil, err
+	}
+	id := book.SourceUUID(hash)
+	if st != nil {
+		rec, ok, err := st.Get(fp)
+		if err != nil {
+			return nil, err
+		}
+		if ok && rec.SourceUUID != "" && rec.SourceUUID != id.String() {
+			if err := p.releaseBook(ctx, st, fp, rec.SourceUUID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.resetBook(ctx, id); err != nil {
+		return nil, err
+	}
+
+	res, parseErr := p.Parse(ctx, fs.FileInfoToDirEntry(info), filepath.Dir(fp))
+	if st != nil {
+		if err := record(st, fp, hash, p.sidecarFingerprint(fp), info, res, parseErr); err != nil {
+			return res, err
+		}
+	}
+	return res, parseErr
+}
+
+// sidecarFingerprint возвращает отпечаток метаданных книги fp. Ошибка чтения
+// метаданных даёт свой отпечаток, чтобы исправленный файл метаданных подхватился.
+func (p *Parser) sidecarFingerprint(fp string) string {
+	sidecar, err := p.sidecars.Find(fp)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return sidecar.Fingerprint()
+}
+
+// record сохраняет в состоянии итог обработки файла
+func record(st *state.Store, fp, hash, sidecar string, info os.FileInfo, res *Result, parseErr error) error {
+	rec := state.Record{Path: fp, ContentHash: hash, Sidecar: sidecar}
+	rec.Touch(info)
+	if res != nil {
+		rec.SourceUUID = res.SourceUUID.String()
+		rec.Chunks = res.Chunks
+		rec.MinHash = res.MinHash
+	}
+	rec.Status = state.StatusDone
+	rec.IndexedAt = time.Now().Unix()
+	if res != nil && res.DuplicateOf != "" {
+		rec.Status = state.StatusDuplicate
+		rec.DuplicateOf = res.DuplicateOf
+	}
+	if res != nil && res.Failed > 0 {
+		rec.Status = state.StatusPartial
+		rec.Error = fmt.Sprintf("%d of %d chunks in dead letter queue", res.Failed, res.Chunks)
+	}
+	if parseErr != nil {
+		rec.Status = state.StatusFailed
+		rec.Error = parseErr.Error()
+	}
+	return st.Put(rec)
+}
+
+// PurgeMissing удаляет из индекса и состояния книги, файлы которых исчезли из тома.
+// seen содержит абсолютные пути всех файлов, найденных при текущем обходе.
+func (p *Parser) PurgeMissing(ctx context.Context, st *state.Store, volume string, seen map[string]struct{}) (int, error) {
+	root, err := filepath.Abs(volume)
+	if err != nil {
+		return 0, err
+	}
+	root += string(filepath.Separator)
+
+	// Книга удаляется, только если её UUID не записан для оставшихся файлов:
+	// переименованного файла или другой копии с тем же содержимым
+	var missing []state.Record
+	kept := make(map[string]bool)
+	err = st.ForEach(func(rec state.Record) error {
+		if _, ok := seen[rec.Path]; ok || !strings.HasPrefix(rec.Path, root) {
+			kept[rec.SourceUUID] = true
+			return nil
+		}
+		missing = append(missing, rec)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := make(map[string]bool)
+	for _, rec := range missing {
+		if id := rec.SourceUUID; id != "" && !kept[id] && !deleted[id] {
+			if err := p.deleteBook(ctx, id); err != nil {
+				return 0, err
+			}
+			deleted[id] = true
+		}
+		if err := st.Delete(rec.Path); err != nil {
+			return 0, err
+		}
+		log.Printf("purged missing file: %v", rec.Path)
+	}
+
+	return len(missing), nil
+}
+
+// acquire отмечает книгу id как обрабатываемую, возвращённая функция снимает отметку
+func (p *Parser) acquire(id uuid.UUID) func() {
+	p.ids.Lock()
+	p.inflight[id]++
+	p.ids.Unlock()
+
+	return func() {
+		p.ids.Lock()
+		defer p.ids.Unlock()
+		if p.inflight[id]--; p.inflight[id] <= 0 {
+			delete(p.inflight, id)
+		}
+	}
+}
+
+// releaseBook удаляет параграфы и строку каталога прежней версии файла fp,
+// если книга sourceUUID не записана в состоянии для другого файла и не
+// обрабатывается сейчас: файлы с одинаковым содержимым делят один UUID.
+func (p *Parser) releaseBook(ctx context.Context, st *state.Store, fp, sourceUUID string) error {
+	p.ids.Lock()
+	defer p.ids.Unlock()
+
+	if id, err := uuid.Parse(sourceUUID); err == nil && p.inflight[id] > 0 {
+		return nil
+	}
+	shared, err := st.Referenced(sourceUUID, fp)
+	if err != nil || shared {
+		return err
+	}
+	return p.deleteBook(ctx, sourceUUID)
+}
+
+// resetBook удаляет книгу id перед повторной записью под тем же UUID, если
+// её не записывает сейчас другой файл с тем же содержимым
+func (p *Parser) resetBook(ctx context.Context, id uuid.UUID) error {
+	p.ids.Lock()
+	defer p.ids.Unlock()
+
+	if p.inflight[id] > 0 {
+		return nil
+	}
+	return p.storage.DeleteBook(ctx, id)
+}
+
+func (p *Parser) deleteBook(ctx context.Context, sourceUUID string) error {
+	id, err := uuid.Parse(sourceUUID)
+	if err != nil {
+		return fmt.Errorf("invalid source uuid %q: %w", sourceUUID, err)
+	}
+	return p.storage.DeleteBook(ctx, id)
+}
@@ -0,0 +1,391 @@
+package parser
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/state"
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// memStore хранилище в памяти: параграфы, строки каталога и связи по UUID книги
+type memStore struct {
+	mu         sync.Mutex
+	chunks     map[int64]string // ID параграфа → UUID книги
+	books      map[string]entry.Book
+	authors    map[int64]entry.BookAuthor
+	categories map[int64]entry.BookCategory
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		chunks:     make(map[int64]string),
+		books:      make(map[string]entry.Book),
+		authors:    make(map[int64]entry.BookAuthor),
+		categories: make(map[int64]entry.BookCategory),
+	}
+}
+
+func (m *memStore) Write(ctx context.Context, docs []storage.Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range docs {
+		switch doc := d.Doc.(type) {
+		case entry.Entry:
+			m.chunks[*d.ID] = doc.SourceUUID.String()
+		case entry.Book:
+			m.books[doc.SourceUUID] = doc
+		case entry.BookAuthor:
+			m.authors[*d.ID] = doc
+		case entry.BookCategory:
+			m.categories[*d.ID] = doc
+		}
+	}
+	return nil
+}
+
+func (m *memStore) DeleteBySourceUUID(ctx context.Context, sourceUUID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, u := range m.chunks {
+		if u == sourceUUID.String() {
+			delete(m.chunks, id)
+		}
+	}
+	delete(m.books, sourceUUID.String())
+	for id, l := range m.authors {
+		if l.SourceUUID == sourceUUID.String() {
+			delete(m.authors, id)
+		}
+	}
+	for id, l := range m.categories {
+		if l.SourceUUID == sourceUUID.String() {
+			delete(m.categories, id)
+		}
+	}
+	return nil
+}
+
+func (m *memStore) DeleteBySource(ctx context.Context, source string) error {
+	return nil
+}
+
+// links возвращает ID авторов и категорий, связанных с книгой
+func (m *memStore) links(sourceUUID string) (authors, categories []int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, l := range m.authors {
+		if l.SourceUUID == sourceUUID {
+			authors = append(authors, l.AuthorID)
+		}
+	}
+	for _, l := range m.categories {
+		if l.SourceUUID == sourceUUID {
+			categories = append(categories, l.CategoryID)
+		}
+	}
+	return authors, categories
+}
+
+// count возвращает количество параграфов книги и наличие её строки каталога
+func (m *memStore) count(sourceUUID string) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, u := range m.chunks {
+		if u == sourceUUID {
+			n++
+		}
+	}
+	_, ok := m.books[sourceUUID]
+	return n, ok
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		BatchSize:  100,
+		MinParSize: 50,
+		OptParSize: 200,
+		MaxParSize: 400,
+		Duplicates: config.Duplicates{TitleSimilarity: 100, ContentSimilarity: 90, PartialContainment: 50, Policy: PolicyIndex, SkipSimilarity: 98},
+	}
+}
+
+func newTestParser(t *testing.T) (*Parser, *memStore) {
+	t.Helper()
+	ms := newMemStore()
+	return NewParser(testConfig(), entry.New(ms, "library", "", nil, 100)), ms
+}
+
+// writeDocx создаёт минимальный docx с n параграфами текста text
+func writeDocx(t *testing.T, path, text string, n int) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&body, "<w:p><w:r><w:t>%s, параграф %d, в котором достаточно слов для отдельного фрагмента текста.</w:t></w:r></w:p>", text, i)
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>%s</w:body></w:document>`, body.String())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// indexDir обрабатывает каталог так же, как команда index с файлом состояния
+func indexDir(t *testing.T, p *Parser, st *state.Store, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]struct{})
+	for _, e := range entries {
+		seen[filepath.Join(dir, e.Name())] = struct{}{}
+		if _, err := p.ParseIncremental(context.Background(), st, e, dir); err != nil {
+			t.Fatalf("%s: %v", e.Name(), err)
+		}
+	}
+	if _, err := p.PurgeMissing(context.Background(), st, dir, seen); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openTestState(t *testing.T) *state.Store {
+	t.Helper()
+	st, err := state.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func sourceUUID(t *testing.T, st *state.Store, path string) string {
+	t.Helper()
+	rec, ok, err := st.Get(path)
+	if err != nil || !ok {
+		t.Fatalf("no state for %s: %v", path, err)
+	}
+	return rec.SourceUUID
+}
+
+func TestIncrementalSharedContent(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, dir string) // файлы первого запуска
+		change func(t *testing.T, dir string) // изменения перед вторым запуском
+		check  string                         // файл, книга которого должна остаться в индексе
+	}{
+		{
+			name: "rename",
+			setup: func(t *testing.T, dir string) {
+				writeDocx(t, filepath.Join(dir, "Жанр_Автор — Книга.docx"), "Текст книги", 10)
+			},
+			change: func(t *testing.T, dir string) {
+				os.Rename(filepath.Join(dir, "Жанр_Автор — Книга.docx"), filepath.Join(dir, "Жанр_Автор — Книга (новое имя).docx"))
+			},
+			check: "Жанр_Автор — Книга (новое имя).docx",
+		},
+		{
+			name: "duplicate copy deleted",
+			setup: func(t *testing.T, dir string) {
+				writeDocx(t, filepath.Join(dir, "Жанр_Автор — Книга.docx"), "Текст книги", 10)
+				writeDocx(t, filepath.Join(dir, "Жанр_Автор — Копия.docx"), "Текст книги", 10)
+			},
+			change: func(t *testing.T, dir string) {
+				os.Remove(filepath.Join(dir, "Жанр_Автор — Копия.docx"))
+			},
+			check: "Жанр_Автор — Книга.docx",
+		},
+		{
+			name: "duplicate copy modified",
+			setup: func(t *testing.T, dir string) {
+				writeDocx(t, filepath.Join(dir, "Жанр_Автор — Книга.docx"), "Текст книги", 10)
+				writeDocx(t, filepath.Join(dir, "Жанр_Автор — Копия.docx"), "Текст книги", 10)
+			},
+			change: func(t *testing.T, dir string) {
+				writeDocx(t, filepath.Join(dir, "Жанр_Автор — Копия.docx"), "Другой текст", 12)
+			},
+			check: "Жанр_Автор — Книга.docx",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p, ms := newTestParser(t)
+			st := openTestState(t)
+
+			tt.setup(t, dir)
+			indexDir(t, p, st, dir)
+			tt.change(t, dir)
+			indexDir(t, p, st, dir)
+
+			id := sourceUUID(t, st, filepath.Join(dir, tt.check))
+			if n, ok := ms.count(id); n == 0 || !ok {
+				t.Errorf("book of %s: %d chunks, catalogue row %v, want it kept", tt.check, n, ok)
+			}
+		})
+	}
+}
+
+func TestIncrementalDeletesUnsharedBook(t *testing.T) {
+	dir := t.TempDir()
+	p, ms := newTestParser(t)
+	st := openTestState(t)
+
+	path := filepath.Join(dir, "Жанр_Автор — Книга.docx")
+	writeDocx(t, path, "Текст книги", 10)
+	indexDir(t, p, st, dir)
+	old := sourceUUID(t, st, path)
+
+	// Изменённая книга заменяет прежнюю версию
+	writeDocx(t, path, "Новый текст", 10)
+	indexDir(t, p, st, dir)
+	if n, ok := ms.count(old); n != 0 || ok {
+		t.Errorf("previous version: %d chunks, catalogue row %v, want deleted", n, ok)
+	}
+
+	// Удалённая книга удаляется из индекса
+	id := sourceUUID(t, st, path)
+	os.Remove(path)
+	indexDir(t, p, st, dir)
+	if n, ok := ms.count(id); n != 0 || ok {
+		t.Errorf("deleted file: %d chunks, catalogue row %v, want deleted", n, ok)
+	}
+}
+
+func TestIncrementalSidecarChange(t *testing.T) {
+	type meta struct{ title, author, genre string }
+	tests := []struct {
+		name  string
+		file  string // файл метаданных
+		write func(m meta) string
+	}{
+		{
+			name: "yaml",
+			file: "Жанр_Автор — Книга.yaml",
+			write: func(m meta) string {
+				return "title: " + m.title + "\nauthor: " + m.author + "\ngenre: " + m.genre + "\n"
+			},
+		},
+		{
+			name: "metadata.csv",
+			file: "metadata.csv",
+			write: func(m meta) string {
+				return "file,title,author,genre\nЖанр_Автор — Книга.docx," + m.title + "," + m.author + "," + m.genre + "\n"
+			},
+		},
+	}
+
+	versions := []meta{
+		{"Первое название", "Иванов И.", "Проза"},
+		{"Второе название", "Петров П.", "Поэзия"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "Жанр_Автор — Книга.docx")
+			writeDocx(t, path, "Текст книги", 10)
+			st := openTestState(t)
+			ms := newMemStore()
+
+			for _, v := range versions {
+				if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.write(v)), 0644); err != nil {
+					t.Fatal(err)
+				}
+				// Каждый запуск команды index создаёт новый парсер
+				indexDir(t, NewParser(testConfig(), entry.New(ms, "library", "", nil, 100)), st, dir)
+			}
+
+			id := sourceUUID(t, st, path)
+			b, ok := ms.books[id]
+			if !ok || b.Title != "Второе название" {
+				t.Errorf("book after sidecar change: %q, indexed %v, want the new title", b.Title, ok)
+			}
+			authors, categories := ms.links(id)
+			if !reflect.DeepEqual(authors, []int64{entry.AuthorID("Петров П.")}) {
+				t.Errorf("author links %v, want only the new author", authors)
+			}
+			if !reflect.DeepEqual(categories, []int64{entry.CategoryID([]string{"Поэзия"})}) {
+				t.Errorf("category links %v, want only the new genre", categories)
+			}
+		})
+	}
+}
+
+func TestReindexKeepsOtherBooks(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string) (target, other string) // переиндексируемый файл и файл, книга которого должна остаться
+	}{
+		{
+			name: "same name in another folder",
+			setup: func(t *testing.T, dir string) (string, string) {
+				for _, sub := range []string{"a", "b"} {
+					if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+				target := filepath.Join(dir, "a", "Жанр_Автор — Книга.docx")
+				other := filepath.Join(dir, "b", "Жанр_Автор — Книга.docx")
+				writeDocx(t, target, "Текст первой книги", 10)
+				writeDocx(t, other, "Текст второй книги", 10)
+				return target, other
+			},
+		},
+		{
+			name: "byte-identical copy",
+			setup: func(t *testing.T, dir string) (string, string) {
+				target := filepath.Join(dir, "Жанр_Автор — Книга.docx")
+				other := filepath.Join(dir, "Жанр_Автор — Копия.docx")
+				writeDocx(t, target, "Текст книги", 10)
+				writeDocx(t, other, "Текст книги", 10)
+				return target, other
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p, ms := newTestParser(t)
+			st := openTestState(t)
+
+			target, other := tt.setup(t, dir)
+			for _, path := range []string{target, other} {
+				if _, err := p.Reindex(context.Background(), st, path); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Изменённый файл переиндексируется, книга другого файла остаётся
+			writeDocx(t, target, "Новый текст", 12)
+			if _, err := p.Reindex(context.Background(), st, target); err != nil {
+				t.Fatal(err)
+			}
+			if n, ok := ms.count(sourceUUID(t, st, other)); n == 0 || !ok {
+				t.Errorf("book of %s: %d chunks, catalogue row %v, want it kept", other, n, ok)
+			}
+		})
+	}
+}
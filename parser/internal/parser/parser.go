@@ -3,26 +3,33 @@ package parser
 import (
 	"archive/tar"
 	"context"
-	"encoding/csv"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/checkpoint"
 	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/deadletter"
 	"github.com/terratensor/library/parser/internal/library/book"
 	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/library/mapping"
+	"github.com/terratensor/library/parser/internal/library/similarity"
 	"github.com/terratensor/library/parser/internal/metadata"
 	"github.com/terratensor/library/parser/internal/parser/brokendocx"
 	"github.com/terratensor/library/parser/internal/parser/docc"
-	"gopkg.in/yaml.v3"
+	"github.com/terratensor/library/parser/internal/report"
+	"github.com/terratensor/library/parser/internal/storage"
 )
 
 type Parser struct {
@@ -33,9 +40,24 @@ type Parser struct {
 	authors    map[string]entry.Author
 	categories map[string]entry.Category
 	titles     map[string]entry.Title
-	mu         sync.Mutex        // To protect concurrent access to maps
-	genresMap  map[string]string // Маппинг жанров
-	foldersMap map[string]string // Маппинг папок
+	mu         sync.Mutex               // To protect concurrent access to maps
+	genres     *mapping.Mapper          // правила сопоставления жанров
+	folders    *mapping.Mapper          // правила сопоставления папок
+	aliases    *book.Aliases            // Псевдонимы авторов
+	sidecars   *book.SidecarReader      // файлы метаданных рядом с книгами
+	duplicates *metadata.DuplicateIndex // дубликаты по названиям среди книг, проиндексированных за время работы парсера
+	content    *similarity.Index        // сигнатуры содержимого проиндексированных книг
+	deadLetter *deadletter.Queue        // очередь недописанных пакетов, nil если не задана в конфиге
+	partial    []Result                 // книги, записанные не полностью за время работы парсера
+	written    map[uuid.UUID]int        // записанные параграфы каждой книги за время работы парсера
+	ids        sync.Mutex               // удаление книг и регистрация обрабатываемых, см. releaseBook
+	inflight   map[uuid.UUID]int        // книги, обрабатываемые инкрементально в данный момент
+
+	// итоги обработки файлов для отчётов, защищены mu
+	contentPairs []metadata.ContentPair // книги с похожим содержимым
+	books        []report.BookStats
+	failures     []report.FileError
+	lints        []book.FilenameLint // имена файлов не по шаблону
 }
 
 // Глобальная переменная для хранения скомпилированного регулярного выражения
@@ -46,6 +68,19 @@ type Reader interface {
 	Read() (string, error)
 }
 
+// Result итог обработки одной книги
+type Result struct {
+	SourceUUID  uuid.UUID
+	Source      string
+	Path        string // путь книги в каталоге, см. bookPath
+	ContentHash string
+	Chunks      int         // количество параграфов книги
+	Failed      int         // параграфы, которые не удалось записать и которые отправлены в очередь недоставленных
+	Book        *entry.Book // строка каталога книг со статистикой по параграфам
+	MinHash     []byte      // сигнатура содержимого книги, см. similarity.MinHash
+	DuplicateOf string      // книга, почти точной копией которой признана эта и поэтому не проиндексирована
+}
+
 // FileInfo содержит информацию о файле для обработки
 type FileInfo struct {
 	TempPath  string // временный путь к файлу
@@ -59,53 +94,53 @@ func NewParser(cfg *config.Config, storage *entry.Entries) *Parser {
 		reBase64 = regexp.MustCompile(`(?:[A-Za-z0-9+/]{40,}={0,2}|iVBORw0KGgo[^"]+)`)
 	}
 
-	// Загружаем маппинг жанров из CSV
-	genresMap := make(map[string]string)
+	// Загружаем правила сопоставления жанров и папок
+	var genres, folders *mapping.Mapper
 	if cfg.GenresMapPath != "" {
-		file, err := os.Open(cfg.GenresMapPath)
+		m, err := mapping.LoadCSV(cfg.GenresMapPath)
 		if err != nil {
-			log.Printf("Warning: could not open genres map file: %v", err)
+			log.Printf("Warning: could not load genres map: %v", err)
 		} else {
-			defer file.Close()
-
-			reader := csv.NewReader(file)
-			reader.Comma = ','
-			reader.FieldsPerRecord = 2 // Ожидаем ровно 2 колонки
-			reader.LazyQuotes = true   // Для обработки строк в кавычках
+			genres = m
+		}
+	}
+	if cfg.FoldersMapPath != "" {
+		m, err := mapping.LoadYAML(cfg.FoldersMapPath)
+		if err != nil {
+			log.Printf("Warning: could not load folders map: %v", err)
+		} else {
+			folders = m
+		}
+	}
 
-			records, err := reader.ReadAll()
-			if err != nil {
-				log.Printf("Warning: could not read genres map file: %v", err)
-			} else {
-				for _, record := range records {
-					if len(record) == 2 {
-						original := strings.TrimSpace(record[0])
-						mapped := strings.TrimSpace(record[1])
-						genresMap[original] = mapped
-					}
-				}
-			}
+	// Загружаем псевдонимы авторов
+	var aliases *book.Aliases
+	if cfg.AliasesPath != "" {
+		a, err := book.LoadAliases(cfg.AliasesPath)
+		if err != nil {
+			log.Printf("Warning: could not load authors aliases: %v", err)
+		} else {
+			aliases = a
 		}
 	}
 
-	// Загружаем маппинг папок
-	foldersMap := make(map[string]string)
-	if cfg.FoldersMapPath != "" {
-		data, err := os.ReadFile(cfg.FoldersMapPath)
+	// Очередь пакетов, которые не удалось записать в хранилище
+	var deadLetter *deadletter.Queue
+	if cfg.DeadLetterPath != "" {
+		q, err := deadletter.Open(cfg.DeadLetterPath)
 		if err != nil {
-			log.Printf("Warning: could not open folders map file: %v", err)
+			log.Printf("Warning: could not open dead letter queue: %v", err)
 		} else {
-			var config struct {
-				Mappings map[string]string `yaml:"mappings"`
-			}
-			if err := yaml.Unmarshal(data, &config); err != nil {
-				log.Printf("Warning: could not parse folders map file: %v", err)
-			} else {
-				foldersMap = config.Mappings
-			}
+			deadLetter = q
 		}
 	}
 
+	switch cfg.Duplicates.Policy {
+	case PolicyIndex, PolicySkip:
+	default:
+		log.Printf("Warning: unknown duplicates policy %q, near copies will be indexed", cfg.Duplicates.Policy)
+	}
+
 	return &Parser{
 		cfg:        cfg,
 		storage:    storage,
@@ -113,14 +148,28 @@ func NewParser(cfg *config.Config, storage *entry.Entries) *Parser {
 		authors:    make(map[string]entry.Author),
 		categories: make(map[string]entry.Category),
 		titles:     make(map[string]entry.Title),
-		genresMap:  genresMap,
-		foldersMap: foldersMap,
+		genres:     genres,
+		folders:    folders,
+		aliases:    aliases,
+		sidecars:   book.NewSidecarReader(),
+		duplicates: metadata.NewDuplicateIndex(float64(cfg.Duplicates.TitleSimilarity) / 100),
+		content:    similarity.NewIndex(),
+		inflight:   make(map[uuid.UUID]int),
+		deadLetter: deadLetter,
 	}
 }
 
-// ProcessTar обрабатывает tar-архив параллельно
-func (p *Parser) ProcessTar(ctx context.Context, tarStream io.Reader, workers int) error {
-	tr := tar.NewReader(tarStream)
+// ProcessTar обрабатывает tar-архив параллельно.
+// Если передана контрольная точка cp, прогресс сохраняется после каждой книги,
+// а уже обработанные члены архива пропускаются.
+func (p *Parser) ProcessTar(ctx context.Context, tarStream io.Reader, workers int, cp *checkpoint.Checkpoint) error {
+	cr := &countingReader{r: tarStream}
+	if cp != nil {
+		if err := p.resumeTar(ctx, cr, cp); err != nil {
+			return err
+		}
+	}
+
 	tasks := make(chan FileInfo, workers)
 	errCh := make(chan error, 1)
 	var wg sync.WaitGroup
@@ -131,22 +180,54 @@ func (p *Parser) ProcessTar(ctx context.Context, tarStream io.Reader, workers in
 		go func() {
 			defer wg.Done()
 			for task := range tasks {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					if err := p.processFile(ctx, task); err != nil {
-						select {
-						case errCh <- err:
-						default:
-						}
+				if ctx.Err() != nil {
+					os.Remove(task.TempPath)
+					continue
+				}
+				err := p.processFile(ctx, task)
+				if err != nil && !isFatal(ctx, err) {
+					// Книга с ошибкой разбора отмечается и не мешает обработке остальных
+					log.Printf("Warning: %v", err)
+					if cp != nil {
+						err = cp.Fail(task.OrigName)
+					} else {
+						err = nil
+					}
+				} else if err == nil && cp != nil {
+					err = cp.Done(task.OrigName)
+				}
+				if err != nil {
+					select {
+					case errCh <- err:
+					default:
 					}
 				}
 			}
 		}()
 	}
 
-	defer close(tasks)
+	err := p.readTar(ctx, tar.NewReader(cr), cr, tasks, errCh, cp)
+	close(tasks)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+	}
+
+	// Архив обработан полностью, контрольная точка больше не нужна
+	if cp != nil {
+		return cp.Remove()
+	}
+	return nil
+}
+
+// readTar читает члены архива и передаёт книги worker-ам
+func (p *Parser) readTar(ctx context.Context, tr *tar.Reader, cr *countingReader, tasks chan<- FileInfo, errCh <-chan error, cp *checkpoint.Checkpoint) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -154,40 +235,64 @@ func (p *Parser) ProcessTar(ctx context.Context, tarStream io.Reader, workers in
 		case err := <-errCh:
 			return err
 		default:
-			hdr, err := tr.Next()
-			if err == io.EOF {
-				wg.Wait()
-				return nil
-			}
-			if err != nil {
-				return err
-			}
+		}
 
-			if hdr.Typeflag == tar.TypeReg {
-				ext := filepath.Ext(hdr.Name)
-				if ext != ".docx" && ext != ".pdf" && ext != ".epub" {
-					continue
-				}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-				tmpFile, err := os.CreateTemp("", "doc_*.tmp")
-				if err != nil {
-					return err
-				}
+		member := checkpoint.Member{
+			Name:   hdr.Name,
+			Offset: cr.n,
+			End:    cr.n + blockAlign(hdr.Size),
+		}
 
-				if _, err := io.Copy(tmpFile, tr); err != nil {
-					tmpFile.Close()
-					os.Remove(tmpFile.Name())
-					return err
-				}
-				tmpFile.Close()
+		ext := filepath.Ext(hdr.Name)
+		isBook := hdr.Typeflag == tar.TypeReg && (ext == ".docx" || ext == ".pdf" || ext == ".epub")
+		if !isBook || (cp != nil && cp.IsCompleted(hdr.Name)) {
+			if cp != nil {
+				cp.Skip(member)
+			}
+			continue
+		}
 
-				tasks <- FileInfo{
-					TempPath:  tmpFile.Name(),
-					OrigName:  hdr.Name,
-					Extension: ext,
-				}
+		// Ридеры проверяют расширение файла, поэтому временный файл получает расширение члена
+		tmpFile, err := os.CreateTemp("", "doc_*"+ext)
+		if err != nil {
+			return err
+		}
+
+		// Хэш считаем при копировании, чтобы заранее знать UUID книги
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(tmpFile, h), tr); err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+			return err
+		}
+		tmpFile.Close()
+
+		if cp != nil {
+			member.SourceUUID = book.SourceUUID(hex.EncodeToString(h.Sum(nil))).String()
+			if err := cp.Begin(member); err != nil {
+				os.Remove(tmpFile.Name())
+				return err
 			}
 		}
+
+		select {
+		case tasks <- FileInfo{
+			TempPath:  tmpFile.Name(),
+			OrigName:  hdr.Name,
+			Extension: ext,
+		}:
+		case <-ctx.Done():
+			os.Remove(tmpFile.Name())
+			return ctx.Err()
+		}
 	}
 }
 
@@ -201,14 +306,15 @@ func (p *Parser) processFile(ctx context.Context, info FileInfo) error {
 	}
 
 	// Модифицированный Parse с поддержкой оригинального имени
-	return p.ParseWithOrigName(ctx, file, filepath.Dir(info.TempPath), info.OrigName)
+	_, err := p.ParseWithOrigName(ctx, file, filepath.Dir(info.TempPath), info.OrigName)
+	return err
 }
 
 // ParseWithOrigName - модифицированная версия Parse с поддержкой оригинального имени
-func (p *Parser) ParseWithOrigName(ctx context.Context, file os.DirEntry, path, origName string) error {
+func (p *Parser) ParseWithOrigName(ctx context.Context, file os.DirEntry, path, origName string) (*Result, error) {
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return nil, ctx.Err()
 	default:
 	}
 
@@ -221,16 +327,7 @@ func (p *Parser) ParseWithOrigName(ctx context.Context, file os.DirEntry, path,
 	// titleList.SourceUUID = uuid.New()
 	// titleList.Source = filename
 
-	switch extension {
-	case ".docx":
-		return p.parseDocx(ctx, fp, filename)
-	case ".pdf":
-		return p.parsePDF(ctx, fp, filename)
-	case ".epub":
-		return p.parseEPUB(ctx, fp, filename)
-	default:
-		return fmt.Errorf("unsupported file format: %s", extension)
-	}
+	return p.parseFile(ctx, fp, filename, extension)
 }
 
 // dirEntry реализует os.DirEntry для временных файлов
@@ -248,9 +345,14 @@ func (p *Parser) processModels(ctx context.Context, titleList *book.TitleList) e
 	// Process author
 	if titleList.Author != "" {
 		p.mu.Lock()
-		if _, exists := p.authors[titleList.Author]; !exists {
-			author := entry.NewAuthorFromTitleList(titleList)
-			p.authors[titleList.Author] = *author
+		for _, author := range entry.NewAuthorsFromTitleList(titleList) {
+			// Записи одного автора разного вида сводятся в одну строку
+			key := strconv.FormatInt(*author.ID, 10)
+			if existing, exists := p.authors[key]; exists {
+				existing.Merge(author)
+				author = existing
+			}
+			p.authors[key] = author
 		}
 		p.mu.Unlock()
 	}
@@ -258,9 +360,10 @@ func (p *Parser) processModels(ctx context.Context, titleList *book.TitleList) e
 	// Process category
 	if titleList.Genre != "" {
 		p.mu.Lock()
-		if _, exists := p.categories[titleList.Genre]; !exists {
-			category := entry.NewCategoryFromTitleList(titleList)
-			p.categories[titleList.Genre] = *category
+		for _, category := range entry.NewCategoriesFromTitleList(titleList) {
+			if _, exists := p.categories[category.Path]; !exists {
+				p.categories[category.Path] = category
+			}
 		}
 		p.mu.Unlock()
 	}
@@ -278,6 +381,12 @@ func (p *Parser) processModels(ctx context.Context, titleList *book.TitleList) e
 	return nil
 }
 
+// UnmappedGenres возвращает жанры книг, для которых не нашлось правила
+// сопоставления, с количеством книг
+func (p *Parser) UnmappedGenres() map[string]int {
+	return p.genres.Unmapped()
+}
+
 // Add this new method to store all collected models
 func (p *Parser) StoreModels(ctx context.Context, mp *metadata.Processor) error {
 	authors := mp.GetAuthors()
@@ -306,10 +415,10 @@ func (p *Parser) StoreModels(ctx context.Context, mp *metadata.Processor) error
 	return nil
 }
 
-func (p *Parser) Parse(ctx context.Context, file os.DirEntry, path string) error {
+func (p *Parser) Parse(ctx context.Context, file os.DirEntry, path string) (*Result, error) {
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return nil, ctx.Err()
 	default:
 	}
 
@@ -322,94 +431,279 @@ func (p *Parser) Parse(ctx context.Context, file os.DirEntry, path string) error
 	// titleList.SourceUUID = uuid.New()
 	// titleList.Source = filename
 
+	return p.parseFile(ctx, fp, filename, extension)
+}
+
+// parseFile разбирает файл по его расширению и запоминает ошибку для отчёта
+func (p *Parser) parseFile(ctx context.Context, fp, filename, extension string) (*Result, error) {
+	var res *Result
+	var err error
 	switch extension {
 	case ".docx":
-		return p.parseDocx(ctx, fp, filename)
+		res, err = p.parseDocx(ctx, fp, filename)
 	case ".pdf":
-		return p.parsePDF(ctx, fp, filename)
+		res, err = p.parsePDF(ctx, fp, filename)
 	case ".epub":
-		return p.parseEPUB(ctx, fp, filename)
+		res, err = p.parseEPUB(ctx, fp, filename)
 	default:
-		return fmt.Errorf("unsupported file format: %s", extension)
+		err = fmt.Errorf("unsupported file format: %s", extension)
+	}
+	if err != nil && ctx.Err() == nil {
+		p.noteError(bookPath(fp, filename), err)
 	}
+	return res, err
 }
 
-func (p *Parser) parseDocx(ctx context.Context, filePath, filename string) error {
+func (p *Parser) parseDocx(ctx context.Context, filePath, filename string) (*Result, error) {
 	// Передаем полный путь к файлу
-	titleList := book.NewTitleList(filePath, p.genresMap, p.foldersMap)
-	titleList.SourceUUID = uuid.New()
-	titleList.Source = filename
+	titleList, err := p.newTitleList(filePath, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	res := newResult(titleList, 0)
+	res.Path = bookPath(filePath, filename)
+	chunks, err := p.readDocx(filePath, filename, func(r Reader) (int, error) {
+		return p.runBuilder(ctx, r, filename, titleList, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Chunks = chunks
+	finishBook(filePath, res)
+	skipped, err := p.checkContent(ctx, res)
+	if err != nil {
+		return res, err
+	}
+	p.noteResult(res)
+	if !skipped {
+		if err := p.saveBook(ctx, res); err != nil {
+			return res, err
+		}
+	}
+	p.noteBook(res)
+	return res, nil
+}
 
+// readDocx открывает docx и передаёт ридер в run. Если обработка не удалась
+// и включён broken_docx_mode, повторяет её ридером повреждённых docx.
+func (p *Parser) readDocx(filePath, filename string, run func(r Reader) (int, error)) (int, error) {
 	// Пытаемся обработать как обычный docx
 	r, err := docc.NewReader(filePath, p.reBase64)
 	if err != nil {
-		return fmt.Errorf("%v, %v", filename, err)
+		return 0, fmt.Errorf("%v, %v", filename, err)
 	}
 	defer r.Close()
 
-	err = p.runBuilder(ctx, r, filename, titleList)
+	chunks, err := run(r)
 	if err != nil {
 		if p.cfg.BrokenDocxMode {
 			log.Printf("Failed to parse as normal DOCX, trying broken DOCX parser: %v", err)
-			return p.parseBrokenDocx(ctx, filePath, filename, titleList)
+			return p.readBrokenDocx(filePath, filename, run)
 		}
-		return fmt.Errorf("%v, %v", filename, err)
+		return 0, fmt.Errorf("%v, %v", filename, err)
 	}
-	return nil
+	return chunks, nil
 }
 
-func (p *Parser) parseBrokenDocx(ctx context.Context, filePath, filename string, titleList *book.TitleList) error {
+func (p *Parser) readBrokenDocx(filePath, filename string, run func(r Reader) (int, error)) (int, error) {
 	br, err := brokendocx.NewReader(filePath, p.reBase64)
 	if err != nil {
-		return fmt.Errorf("failed to create broken DOCX reader: %v", err)
+		return 0, fmt.Errorf("failed to create broken DOCX reader: %v", err)
 	}
 	defer br.Close()
 
 	log.Printf("Using broken DOCX parser for: %v", filename)
-	err = p.runBuilder(ctx, br, filename, titleList)
+	chunks, err := run(br)
 	if err != nil {
-		return fmt.Errorf("broken DOCX parser failed for %v: %v", filename, err)
+		return 0, fmt.Errorf("broken DOCX parser failed for %v: %v", filename, err)
 	}
-	return nil
+	return chunks, nil
 }
 
-func (p *Parser) parsePDF(ctx context.Context, filePath, filename string) error {
+func (p *Parser) parsePDF(ctx context.Context, filePath, filename string) (*Result, error) {
 	if !p.cfg.PDFMode {
-		return fmt.Errorf("PDF processing is disabled in config")
+		return nil, fmt.Errorf("PDF processing is disabled in config")
 	}
 
-	titleList := book.NewTitleList(filePath, p.genresMap, p.foldersMap)
-	titleList.SourceUUID = uuid.New()
-	titleList.Source = filename
+	if _, err := p.newTitleList(filePath, filename); err != nil {
+		return nil, err
+	}
 
 	// Заглушка - возвращаем ошибку, что функционал еще не реализован
-	return fmt.Errorf("PDF parser is not implemented yet")
+	return nil, fmt.Errorf("PDF parser is not implemented yet")
 }
 
-func (p *Parser) parseEPUB(ctx context.Context, filePath, filename string) error {
+func (p *Parser) parseEPUB(ctx context.Context, filePath, filename string) (*Result, error) {
 	if !p.cfg.EPUBMode {
-		return fmt.Errorf("EPUB processing is disabled in config")
+		return nil, fmt.Errorf("EPUB processing is disabled in config")
 	}
 
-	titleList := book.NewTitleList(filePath, p.genresMap, p.foldersMap)
-	titleList.SourceUUID = uuid.New()
-	titleList.Source = filename
+	if _, err := p.newTitleList(filePath, filename); err != nil {
+		return nil, err
+	}
 
 	// Заглушка - возвращаем ошибку, что функционал еще не реализован
-	return fmt.Errorf("EPUB parser is not implemented yet")
+	return nil, fmt.Errorf("EPUB parser is not implemented yet")
+}
+
+// newTitleList разбирает имя файла, дополняет его файлом метаданных книги
+// и вычисляет отпечаток её содержимого. Файлы из tar-архива распаковываются
+// во временный каталог, поэтому файлы метаданных для них не ищутся.
+func (p *Parser) newTitleList(filePath, filename string) (*book.TitleList, error) {
+	titleList := book.NewTitleList(filePath, p.genres, p.folders, p.aliases)
+	// Для файлов из tar-архива метаданные не ищутся и имена не проверяются:
+	// на диске они временные, а рядом с ними лежат чужие файлы
+	var sidecar *book.Sidecar
+	if filepath.Base(filePath) == filepath.Base(filename) {
+		var err error
+		if sidecar, err = p.sidecars.Find(filePath); err != nil {
+			log.Printf("Warning: %v, %v", filename, err)
+		}
+		if lint := book.LintFilename(filePath, titleList.Folder, sidecar); lint != nil {
+			lint.Path = bookPath(filePath, filename)
+			p.noteLint(*lint)
+		}
+	}
+	titleList.ApplySidecar(sidecar, p.genres, p.aliases)
+	if err := titleList.SetFingerprint(filePath); err != nil {
+		return nil, fmt.Errorf("%v, %v", filename, err)
+	}
+	titleList.Source = filename
+	return titleList, nil
+}
+
+// finishBook дополняет строку каталога книг сведениями о файле
+// и статистикой по параграфам после разбиения всего текста
+func finishBook(filePath string, res *Result) {
+	b := res.Book
+	if b == nil {
+		return
+	}
+	b.Path = bookPath(filePath, b.Source)
+	if info, err := os.Stat(filePath); err == nil {
+		b.FileSize = info.Size()
+	}
+	b.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(b.Source)), ".")
+	b.IndexedAt = time.Now().Unix()
+	b.Finish()
+}
+
+// saveBook записывает строку каталога книг после записи её параграфов
+func (p *Parser) saveBook(ctx context.Context, res *Result) error {
+	b := res.Book
+	if b == nil {
+		return nil
+	}
+	if err := p.storage.SaveBook(ctx, b); err != nil {
+		return &storageError{fmt.Errorf("%v: error saving book: %w", b.Source, err)}
+	}
+	p.duplicates.Add(b.Title, b.Volume, metadata.DuplicateFile{
+		Path:       b.Path,
+		Size:       b.FileSize,
+		Format:     b.Format,
+		OCRQuality: b.OCRQuality,
+	})
+	return nil
+}
+
+// bookPath путь книги в каталоге. Файлы из tar-архива обрабатываются
+// во временном каталоге, путём для них служит имя внутри архива.
+func bookPath(filePath, source string) string {
+	if filepath.Base(filePath) == filepath.Base(source) {
+		if abs, err := filepath.Abs(filePath); err == nil {
+			return abs
+		}
+	}
+	return source
 }
 
-func (p *Parser) runBuilder(ctx context.Context, r Reader, filename string, titleList *book.TitleList) error {
+// Duplicates возвращает кластеры дубликатов по названиям среди книг,
+// проиндексированных за время работы парсера
+func (p *Parser) Duplicates() []metadata.Cluster {
+	return p.duplicates.Clusters()
+}
+
+func newResult(titleList *book.TitleList, chunks int) *Result {
+	return &Result{
+		SourceUUID:  titleList.SourceUUID,
+		Source:      titleList.Source,
+		ContentHash: titleList.ContentHash,
+		Chunks:      chunks,
+	}
+}
+
+// runBuilder разбивает текст книги на параграфы и отдаёт их хранилищу пакетами,
+// возвращает количество параграфов. При асинхронной записи чтение книги не ждёт
+// записи каждого пакета, результаты проверяются в конце книги. Недописанные
+// параграфы отправляются в очередь недоставленных, их количество сохраняется в res.Failed.
+func (p *Parser) runBuilder(ctx context.Context, r Reader, filename string, titleList *book.TitleList, res *Result) (int, error) {
 
 	// Process models first
 	if err := p.processModels(ctx, titleList); err != nil {
-		return err
+		return 0, err
 	}
 
-	// position номер параграфа в индексе
-	position := 1
+	// При повторной обработке ридером повреждённых docx считаем заново
+	res.Failed = 0
+	res.Book = entry.NewBook(titleList)
+
+	type submitted struct {
+		pars    entry.PrepareParagraphs
+		pending storage.Pending
+	}
+	var batches []submitted
 
 	var pars entry.PrepareParagraphs
+	flush := func() error {
+		pending, err := p.storage.Submit(ctx, pars)
+		if err != nil {
+			return &storageError{err}
+		}
+		batches = append(batches, submitted{pars: pars, pending: pending})
+		// очищаем slice
+		pars = nil
+		return nil
+	}
+
+	chunks, err := p.chunk(ctx, r, filename, titleList, func(e entry.Entry) error {
+		pars = append(pars, e)
+		res.Book.Add(e)
+
+		// Записываем пакетам по batchSize параграфов
+		if len(pars) == p.cfg.BatchSize-1 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Если параграфов меньше batchSize, то записываем оставшиеся параграфы
+	if len(pars) > 0 {
+		if err := flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Книга считается обработанной только после записи всех её пакетов
+	for _, b := range batches {
+		if err := b.pending.Wait(); err != nil {
+			log.Printf("log bulk insert error query: %v \r\n", err)
+			res.Failed += p.sendToDeadLetter(titleList, res.Path, b.pars, err)
+		}
+	}
+
+	return chunks, nil
+}
+
+// chunk разбивает текст, прочитанный из r, на параграфы по границам min_par_size,
+// opt_par_size и max_par_size и передаёт каждый готовый параграф в emit.
+// Возвращает количество параграфов.
+func (p *Parser) chunk(ctx context.Context, r Reader, filename string, titleList *book.TitleList, emit func(entry.Entry) error) (int, error) {
+	// position номер параграфа в индексе
+	position := 1
 
 	// var b билдер
 	// var textBuilder билдер для текста прочитанного из docx файла
@@ -420,12 +714,11 @@ func (p *Parser) runBuilder(ctx context.Context, r Reader, filename string, titl
 		bufBuilder,
 		longParBuilder strings.Builder
 
-	batchSizeCount := 0
 	for {
 		// Используем select для выхода по истечении контекста, прерывание выполнения ctrl+c
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return 0, ctx.Err()
 		default:
 		}
 
@@ -436,7 +729,7 @@ func (p *Parser) runBuilder(ctx context.Context, r Reader, filename string, titl
 			if err == io.EOF {
 				break
 			} else if err != nil {
-				return fmt.Errorf("%v, %v", filename, err)
+				return 0, fmt.Errorf("%v, %v", filename, err)
 			}
 			// Если строка пустая, то пропускаем
 			// и переходим к следующей итерации цикла
@@ -518,41 +811,25 @@ func (p *Parser) runBuilder(ctx context.Context, r Reader, filename string, titl
 			textBuilder.Reset()
 		}
 
-		pars = appendParagraph(b, titleList, position, pars, p.cfg.Filters.CutBase64Recursive)
+		if err := emit(newParagraph(b, titleList, position, p.cfg.Filters.CutBase64Recursive)); err != nil {
+			return 0, err
+		}
 
 		b.Reset()
 
 		position++
-		batchSizeCount++
-
-		// Записываем пакетам по batchSize параграфов
-		if batchSizeCount == p.cfg.BatchSize-1 {
-			err := p.storage.Bulk(ctx, pars)
-			if err != nil {
-				log.Printf("log bulk insert error query: %v \r\n", err)
-			}
-			// очищаем slice
-			pars = nil
-			batchSizeCount = 0
-		}
-
 	}
 
 	// Если билдер строки не пустой, записываем оставшийся текст в параграфы и сбрасываем билдер
 	if utf8.RuneCountInString(b.String()) > 0 {
-		pars = appendParagraph(b, titleList, position, pars, p.cfg.Filters.CutBase64Recursive)
-	}
-	b.Reset()
-
-	// Если batchSizeCount меньше batchSize, то записываем оставшиеся параграфы
-	if len(pars) > 0 {
-		err := p.storage.Bulk(ctx, pars)
-		if err != nil {
-			log.Printf("log bulk insert error query: %v \r\n", err)
+		if err := emit(newParagraph(b, titleList, position, p.cfg.Filters.CutBase64Recursive)); err != nil {
+			return 0, err
 		}
+		position++
 	}
+	b.Reset()
 
-	return nil
+	return position - 1, nil
 }
 
 func (p *Parser) splitLongParagraph(longBuilder *strings.Builder, builder *strings.Builder) {
@@ -618,7 +895,8 @@ func processTriples(text string) string {
 	return text
 }
 
-func appendParagraph(b strings.Builder, titleList *book.TitleList, position int, pars entry.PrepareParagraphs, cutBase64Recursive bool) entry.PrepareParagraphs {
+// newParagraph создаёт параграф из текста билдера и вычисляет его характеристики
+func newParagraph(b strings.Builder, titleList *book.TitleList, position int, cutBase64Recursive bool) entry.Entry {
 
 	text := b.String()
 	// Если установлен режмим в конфигурации RecursiveCutBase64, то вырезаем все base64 данные из получившегося параграфа
@@ -627,27 +905,35 @@ func appendParagraph(b strings.Builder, titleList *book.TitleList, position int,
 		text = recursiveCutBase64(text)
 	}
 	parsedParagraph := entry.Entry{
-		SourceUUID: titleList.SourceUUID,
-		Source:     titleList.Source,
-		Genre:      titleList.Genre,
-		Author:     titleList.Author,
-		BookName:   titleList.Title,
-		Content:    text,
-		Chunk:      position,
-		CreatedAt:  time.Now().Unix(),
-		UpdatedAt:  time.Now().Unix(),
-	}
-
+		SourceUUID:  titleList.SourceUUID,
+		Source:      titleList.Source,
+		Genre:       titleList.Genre,
+		Author:      titleList.Author,
+		BookName:    titleList.Title,
+		AuthorIDs:   entry.AuthorIDs(titleList),
+		CategoryIDs: entry.CategoryIDs(titleList),
+		Year:        titleList.Year,
+		Volume:      titleList.Volume,
+		Edition:     titleList.Edition,
+		Series:      titleList.Series,
+		Content:     text,
+		Chunk:       position,
+		Datetime:    entry.YearTimestamp(titleList.Year),
+		CreatedAt:   time.Now().Unix(),
+		UpdatedAt:   time.Now().Unix(),
+	}
+
+	parsedParagraph.SetID()
 	parsedParagraph.CalculateCharCount()
 	parsedParagraph.CalculateWordCount()
 	parsedParagraph.DetectLanguage()
 	parsedParagraph.CalculateOCRQuality()
+	parsedParagraph.CalculateSimHash()
 
 	// log.Printf("parsedParagraph: %v", parsedParagraph)
 	// panic("stop")
 
-	pars = append(pars, parsedParagraph)
-	return pars
+	return parsedParagraph
 }
 
 // Функция для рекурсивного вырезания совпадений по регулярному выражению
@@ -0,0 +1,42 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/library/entry"
+)
+
+// Preview разбивает файл на параграфы тем же ридером и билдером, что и индексация,
+// но ничего не записывает в хранилище
+func (p *Parser) Preview(ctx context.Context, filePath string) (*book.TitleList, []entry.Entry, error) {
+	filename := filepath.Base(filePath)
+
+	extension := strings.ToLower(filepath.Ext(filename))
+	if extension != ".docx" {
+		return nil, nil, fmt.Errorf("unsupported file format: %s", extension)
+	}
+
+	titleList, err := p.newTitleList(filePath, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []entry.Entry
+	_, err = p.readDocx(filePath, filename, func(r Reader) (int, error) {
+		// При повторе ридером повреждённых docx начинаем заново
+		entries = entries[:0]
+		return p.chunk(ctx, r, filename, titleList, func(e entry.Entry) error {
+			entries = append(entries, e)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return titleList, entries, nil
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/report"
+)
+
+// noteBook запоминает статистику обработанной книги для отчёта
+func (p *Parser) noteBook(res *Result) {
+	b := res.Book
+	if b == nil {
+		return
+	}
+	stats := report.BookStats{
+		Path:        b.Path,
+		SourceUUID:  b.SourceUUID,
+		Genre:       b.Genre,
+		Author:      b.Author,
+		Title:       b.Title,
+		Year:        b.Year,
+		Volume:      b.Volume,
+		Format:      b.Format,
+		FileSize:    b.FileSize,
+		Chunks:      res.Chunks,
+		Failed:      res.Failed,
+		CharCount:   b.CharCount,
+		WordCount:   b.WordCount,
+		Language:    b.Language,
+		OCRQuality:  b.OCRQuality,
+		DuplicateOf: res.DuplicateOf,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.books = append(p.books, stats)
+}
+
+// noteError запоминает ошибку обработки файла для отчёта
+func (p *Parser) noteError(path string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures = append(p.failures, report.FileError{Path: path, Error: err.Error()})
+}
+
+// noteLint запоминает имя файла не по шаблону для отчёта
+func (p *Parser) noteLint(lint book.FilenameLint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lints = append(p.lints, lint)
+}
+
+// Report возвращает итоги обработки файлов за время работы парсера:
+// статистику книг, дубликаты, жанры без правил, имена файлов не по шаблону
+// и ошибки. Упорядочивается отчёт при записи, см. report.Save.
+func (p *Parser) Report() *report.Report {
+	r := &report.Report{
+		Command:           "index",
+		Volume:            p.cfg.Volume,
+		Duplicates:        p.Duplicates(),
+		ContentDuplicates: p.ContentDuplicates(),
+		UnmappedGenres:    report.Genres(p.UnmappedGenres()),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r.Books = append([]report.BookStats(nil), p.books...)
+	r.Errors = append([]report.FileError(nil), p.failures...)
+	r.InvalidFilenames = append([]book.FilenameLint(nil), p.lints...)
+	return r
+}
@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"github.com/terratensor/library/parser/internal/checkpoint"
+)
+
+// tarBlockSize размер блока tar-архива
+const tarBlockSize = 512
+
+var errNotSeekable = errors.New("tar stream is not seekable")
+
+// storageError ошибка записи в хранилище или удаления из него. Такая ошибка
+// прерывает обработку архива, ошибки разбора отдельной книги — нет.
+type storageError struct {
+	err error
+}
+
+func (e *storageError) Error() string { return e.err.Error() }
+func (e *storageError) Unwrap() error { return e.err }
+
+// isFatal сообщает, что после ошибки обработки члена архива продолжать нельзя
+func isFatal(ctx context.Context, err error) bool {
+	var serr *storageError
+	return ctx.Err() != nil || errors.As(err, &serr)
+}
+
+// countingReader считает прочитанные байты, чтобы знать смещения членов архива.
+// Seek пробрасывается в исходный поток, если он его поддерживает,
+// тогда tar.Reader пропускает ненужные члены без чтения.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := c.r.(io.Seeker)
+	if !ok {
+		return 0, errNotSeekable
+	}
+	pos, err := s.Seek(offset, whence)
+	if err == nil {
+		c.n = pos
+	}
+	return pos, err
+}
+
+// skipTo перемещает поток на смещение offset от начала архива
+func (c *countingReader) skipTo(offset int64) error {
+	if s, ok := c.r.(io.Seeker); ok {
+		if pos, err := s.Seek(offset, io.SeekStart); err == nil {
+			c.n = pos
+			return nil
+		}
+	}
+	// Поток не поддерживает Seek (например, stdin), дочитываем до нужного места
+	_, err := io.CopyN(io.Discard, c, offset-c.n)
+	return err
+}
+
+// blockAlign округляет размер данных члена до границы блока
+func blockAlign(size int64) int64 {
+	return (size + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+}
+
+// resumeTar удаляет частично записанные книги прерванного запуска
+// и перематывает поток за уже обработанные члены архива
+func (p *Parser) resumeTar(ctx context.Context, cr *countingReader, cp *checkpoint.Checkpoint) error {
+	for _, m := range cp.TakePending() {
+		if m.SourceUUID == "" {
+			continue
+		}
+		if err := p.deleteBook(ctx, m.SourceUUID); err != nil {
+			return err
+		}
+		log.Printf("removed partially indexed book %v (%v)", m.Name, m.SourceUUID)
+	}
+
+	if cp.Offset > 0 {
+		log.Printf("resuming tar archive from offset %d", cp.Offset)
+		if err := cr.skipTo(cp.Offset); err != nil {
+			return err
+		}
+	}
+	return cp.Save()
+}
@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/checkpoint"
+)
+
+// writeTar собирает tar-архив из файлов каталога dir в порядке names
+func writeTar(t *testing.T, path, dir string, names ...string) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessTarResumeWithBrokenMember(t *testing.T) {
+	dir := t.TempDir()
+	writeDocx(t, filepath.Join(dir, "Жанр_Автор — Первая.docx"), "Первая книга", 10)
+	os.WriteFile(filepath.Join(dir, "Жанр_Автор — Битая.docx"), []byte("not a zip archive"), 0644)
+	writeDocx(t, filepath.Join(dir, "Жанр_Автор — Вторая.docx"), "Вторая книга", 10)
+	archive := filepath.Join(dir, "library.tar")
+	writeTar(t, archive, dir, "Жанр_Автор — Первая.docx", "Жанр_Автор — Битая.docx", "Жанр_Автор — Вторая.docx")
+
+	// Прерванный запуск оставил битый член начатым
+	cpPath := filepath.Join(dir, "checkpoint.json")
+	cp := checkpoint.New(cpPath, archive)
+	if err := cp.Begin(checkpoint.Member{Name: "Жанр_Автор — Битая.docx"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Каждый запуск продолжает с контрольной точки предыдущего: битый член
+	// отмечается ошибочным и больше не повторяется, остальные книги записываются
+	wantBooks := []int{2, 0}
+	for run, want := range wantBooks {
+		cp, err := checkpoint.Load(cpPath, archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, ms := newTestParser(t)
+		err = p.ProcessTar(context.Background(), f, 2, cp)
+		f.Close()
+		if err != nil {
+			t.Fatalf("run %d: ProcessTar() error = %v, want broken member skipped", run, err)
+		}
+		if len(ms.books) != want {
+			t.Errorf("run %d: %d books indexed, want %d", run, len(ms.books), want)
+		}
+		if _, ok := cp.Failed["Жанр_Автор — Битая.docx"]; !ok || len(cp.Pending) != 0 {
+			t.Errorf("run %d: failed %v, pending %v", run, cp.Failed, cp.Pending)
+		}
+		// Архив обработан полностью, для следующего запуска сохраняем точку вручную
+		if err := cp.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
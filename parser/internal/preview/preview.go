@@ -0,0 +1,163 @@
+// Package preview выводит результат разбиения книги на параграфы без записи в хранилище.
+// Используется для подбора min_par_size, opt_par_size и max_par_size перед полной переиндексацией.
+package preview
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/library/entry"
+)
+
+// histogramWidth ширина самого длинного столбца гистограммы в символах
+const histogramWidth = 50
+
+// Chunk параграф в выводе предпросмотра
+type Chunk struct {
+	Chunk      int     `json:"chunk"`
+	CharCount  int     `json:"char_count"`
+	WordCount  int     `json:"word_count"`
+	Language   string  `json:"language"`
+	OCRQuality float32 `json:"ocr_quality"`
+	Content    string  `json:"content"`
+}
+
+// Sizes границы размеров параграфа из конфига
+type Sizes struct {
+	Min int
+	Opt int
+	Max int
+}
+
+// WriteJSONL выводит параграфы по одному JSON-объекту на строку
+func WriteJSONL(w io.Writer, entries []entry.Entry) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, e := range entries {
+		err := enc.Encode(Chunk{
+			Chunk:      e.Chunk,
+			CharCount:  e.CharCount,
+			WordCount:  e.WordCount,
+			Language:   e.Language,
+			OCRQuality: e.OCRQuality,
+			Content:    e.Content,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMarkdown выводит параграфы в markdown с аннотацией каждого параграфа
+func WriteMarkdown(w io.Writer, tl *book.TitleList, entries []entry.Entry) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n", tl.Title)
+	fmt.Fprintf(&b, "- Файл: %s\n", tl.Source)
+	fmt.Fprintf(&b, "- Жанр: %s\n", tl.Genre)
+	fmt.Fprintf(&b, "- Автор: %s\n", tl.Author)
+	fmt.Fprintf(&b, "- UUID: %s\n\n", tl.SourceUUID)
+
+	for _, e := range entries {
+		fmt.Fprintf(&b, "## Параграф %d\n\n", e.Chunk)
+		fmt.Fprintf(&b, "`%d символов · %d слов · %s · OCR %.2f`\n\n", e.CharCount, e.WordCount, e.Language, e.OCRQuality)
+		b.WriteString(strings.TrimSpace(e.Content))
+		b.WriteString("\n\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteHistogram выводит сводку и гистограмму размеров параграфов в символах.
+// Корзины шириной bucket символов, корзины с границами из sizes отмечаются.
+func WriteHistogram(w io.Writer, entries []entry.Entry, sizes Sizes, bucket int) error {
+	if bucket <= 0 {
+		bucket = 250
+	}
+	var b strings.Builder
+
+	if len(entries) == 0 {
+		b.WriteString("параграфов нет\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	counts := make([]int, 0, len(entries))
+	languages := make(map[string]int)
+	total, belowMin, aboveMax := 0, 0, 0
+	for _, e := range entries {
+		counts = append(counts, e.CharCount)
+		languages[e.Language]++
+		total += e.CharCount
+		if e.CharCount < sizes.Min {
+			belowMin++
+		}
+		if sizes.Max > 0 && e.CharCount > sizes.Max {
+			aboveMax++
+		}
+	}
+	sort.Ints(counts)
+
+	fmt.Fprintf(&b, "параграфов: %d\n", len(entries))
+	fmt.Fprintf(&b, "символов: min %d, медиана %d, среднее %d, max %d\n",
+		counts[0], counts[len(counts)/2], total/len(counts), counts[len(counts)-1])
+	fmt.Fprintf(&b, "границы: min_par_size %d, opt_par_size %d, max_par_size %d\n", sizes.Min, sizes.Opt, sizes.Max)
+	fmt.Fprintf(&b, "меньше min_par_size: %d, больше max_par_size: %d\n", belowMin, aboveMax)
+
+	langs := make([]string, 0, len(languages))
+	for lang := range languages {
+		langs = append(langs, lang)
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		if languages[langs[i]] != languages[langs[j]] {
+			return languages[langs[i]] > languages[langs[j]]
+		}
+		return langs[i] < langs[j]
+	})
+	b.WriteString("языки:")
+	for _, lang := range langs {
+		fmt.Fprintf(&b, " %s %d", lang, languages[lang])
+	}
+	b.WriteString("\n\n")
+
+	buckets := make([]int, counts[len(counts)-1]/bucket+1)
+	peak := 0
+	for _, n := range counts {
+		buckets[n/bucket]++
+		peak = max(peak, buckets[n/bucket])
+	}
+
+	for i, n := range buckets {
+		from, to := i*bucket, (i+1)*bucket-1
+		bar := strings.Repeat("█", (n*histogramWidth+peak-1)/peak)
+		line := fmt.Sprintf("%6d-%-6d %6d %-*s%s", from, to, n, histogramWidth+1, bar, marks(from, to, sizes))
+		b.WriteString(strings.TrimRight(line, " "))
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// marks подписывает корзину, в которую попадает граница размера параграфа
+func marks(from, to int, sizes Sizes) string {
+	var m []string
+	for _, s := range []struct {
+		name string
+		size int
+	}{{"min", sizes.Min}, {"opt", sizes.Opt}, {"max", sizes.Max}} {
+		if s.size >= from && s.size <= to {
+			m = append(m, s.name)
+		}
+	}
+	if len(m) == 0 {
+		return ""
+	}
+	return "← " + strings.Join(m, ", ")
+}
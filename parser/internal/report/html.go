@@ -0,0 +1,95 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// WriteHTML записывает отчёт в одну HTML-страницу без внешних ресурсов
+func (r *Report) WriteHTML(w io.Writer) error {
+	return page.Execute(w, r)
+}
+
+var page = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct":  func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+	"ocr":  func(f float32) string { return fmt.Sprintf("%.2f", f) },
+	"join": strings.Join,
+	"inc":  func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<title>Отчёт {{.Command}}: {{.Volume}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.15em; margin-top: 2em; }
+table { border-collapse: collapse; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+td.num { text-align: right; }
+tr.canonical td { font-weight: bold; }
+.empty { color: #888; }
+</style>
+</head>
+<body>
+<h1>Отчёт {{.Command}}: {{.Volume}}</h1>
+<p>Схема отчёта: {{.Schema}}</p>
+
+<table>
+<tr><th>Книги</th><td class="num">{{.Summary.Books}}</td></tr>
+<tr><th>Параграфы</th><td class="num">{{.Summary.Chunks}}</td></tr>
+<tr><th><a href="#duplicates">Дубликаты по названиям</a></th><td class="num">{{.Summary.Duplicates}}</td></tr>
+<tr><th><a href="#content">Дубликаты по содержимому</a></th><td class="num">{{.Summary.ContentDuplicates}}</td></tr>
+<tr><th><a href="#genres">Жанры без правил</a></th><td class="num">{{.Summary.UnmappedGenres}}</td></tr>
+<tr><th><a href="#filenames">Имена файлов не по шаблону</a></th><td class="num">{{.Summary.InvalidFilenames}}</td></tr>
+<tr><th><a href="#errors">Ошибки</a></th><td class="num">{{.Summary.Errors}}</td></tr>
+</table>
+
+<h2 id="duplicates">Дубликаты по названиям</h2>
+{{with .Duplicates}}<table>
+<tr><th>#</th><th>Файл</th><th>Название</th><th>Формат</th><th>Размер</th><th>OCR</th><th>Сходство</th></tr>
+{{range $n, $c := .}}{{range .Files}}<tr{{if eq .Path $c.Canonical}} class="canonical"{{end}}><td class="num">{{inc $n}}</td><td>{{.Path}}</td><td>{{.Title}}</td><td>{{.Format}}</td><td class="num">{{.Size}}</td><td class="num">{{if .OCRQuality}}{{ocr .OCRQuality}}{{end}}</td><td class="num">{{pct .Similarity}}</td></tr>
+{{end}}{{end}}</table>
+<p>Рекомендуемый файл кластера выделен.</p>
+{{else}}<p class="empty">Нет</p>
+{{end}}
+<h2 id="content">Дубликаты по содержимому</h2>
+{{with .ContentDuplicates}}<table>
+<tr><th>Файл</th><th>Похож на</th><th>Вид</th><th>Сходство</th><th>Общие параграфы</th><th>Не проиндексирован</th></tr>
+{{range .}}<tr><td>{{.Path}}</td><td>{{.Other}}</td><td>{{.Kind}}</td><td class="num">{{pct .Similarity}}</td><td class="num">{{pct .Containment}}</td><td>{{if .Skipped}}да{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p class="empty">Нет</p>
+{{end}}
+<h2 id="genres">Жанры без правил сопоставления</h2>
+{{with .UnmappedGenres}}<table>
+<tr><th>Жанр</th><th>Книги</th></tr>
+{{range .}}<tr><td>{{.Genre}}</td><td class="num">{{.Books}}</td></tr>
+{{end}}</table>
+{{else}}<p class="empty">Нет</p>
+{{end}}
+<h2 id="filenames">Имена файлов не по шаблону</h2>
+{{with .InvalidFilenames}}<table>
+<tr><th>Файл</th><th>Причины</th><th>Предлагаемое имя</th></tr>
+{{range .}}<tr><td>{{.Path}}</td><td>{{join .Issues ", "}}</td><td>{{.Suggested}}</td></tr>
+{{end}}</table>
+{{else}}<p class="empty">Нет</p>
+{{end}}
+<h2 id="errors">Ошибки</h2>
+{{with .Errors}}<table>
+<tr><th>Файл</th><th>Ошибка</th></tr>
+{{range .}}<tr><td>{{.Path}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{else}}<p class="empty">Нет</p>
+{{end}}
+<h2 id="books">Книги</h2>
+{{with .Books}}<table>
+<tr><th>Файл</th><th>Жанр</th><th>Автор</th><th>Название</th><th>Формат</th><th>Размер</th><th>Параграфы</th><th>Слова</th><th>Язык</th><th>OCR</th><th>Копия</th></tr>
+{{range .}}<tr><td>{{.Path}}</td><td>{{.Genre}}</td><td>{{.Author}}</td><td>{{.Title}}</td><td>{{.Format}}</td><td class="num">{{.FileSize}}</td><td class="num">{{.Chunks}}{{if .Failed}} ({{.Failed}} не записано){{end}}</td><td class="num">{{.WordCount}}</td><td>{{.Language}}</td><td class="num">{{if .OCRQuality}}{{ocr .OCRQuality}}{{end}}</td><td>{{.DuplicateOf}}</td></tr>
+{{end}}</table>
+{{else}}<p class="empty">Нет</p>
+{{end}}</body>
+</html>
+`))
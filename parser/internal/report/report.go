@@ -0,0 +1,153 @@
+// Package report собирает итоги запуска index или metadata — статистику книг,
+// дубликаты, жанры без правил сопоставления, имена файлов не по шаблону
+// и ошибки обработки — и записывает их в JSON, CSV и HTML. Все списки
+// упорядочены, поэтому отчёты двух запусков можно сравнивать diff-ом.
+package report
+
+import (
+	"sort"
+
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/metadata"
+)
+
+// SchemaVersion версия схемы отчёта, увеличивается при несовместимых изменениях
+const SchemaVersion = 1
+
+// Report итоги одного запуска
+type Report struct {
+	Schema            int                    `json:"schema"`
+	Command           string                 `json:"command"` // index или metadata
+	Volume            string                 `json:"volume"`
+	Summary           Summary                `json:"summary"`
+	Books             []BookStats            `json:"books"`
+	Duplicates        []metadata.Cluster     `json:"duplicates"`
+	ContentDuplicates []metadata.ContentPair `json:"content_duplicates"`
+	UnmappedGenres    []Genre                `json:"unmapped_genres"`
+	InvalidFilenames  []book.FilenameLint    `json:"invalid_filenames"`
+	Errors            []FileError            `json:"errors"`
+}
+
+// Summary количество записей в разделах отчёта
+type Summary struct {
+	Books             int   `json:"books"`
+	Chunks            int64 `json:"chunks"`
+	Duplicates        int   `json:"duplicates"` // кластеры дубликатов по названиям
+	ContentDuplicates int   `json:"content_duplicates"`
+	UnmappedGenres    int   `json:"unmapped_genres"`
+	InvalidFilenames  int   `json:"invalid_filenames"`
+	Errors            int   `json:"errors"`
+}
+
+// BookStats статистика одной книги. Поля содержимого заполняются только
+// командой index, metadata разбирает лишь имена файлов.
+type BookStats struct {
+	Path        string  `json:"path"`
+	SourceUUID  string  `json:"source_uuid,omitempty"`
+	Genre       string  `json:"genre"`
+	Author      string  `json:"author"`
+	Title       string  `json:"title"`
+	Year        int     `json:"year,omitempty"`
+	Volume      int     `json:"volume,omitempty"`
+	Format      string  `json:"format"`
+	FileSize    int64   `json:"file_size"`
+	Chunks      int     `json:"chunks"`
+	Failed      int     `json:"failed,omitempty"` // параграфы в очереди недоставленных
+	CharCount   int64   `json:"char_count"`
+	WordCount   int64   `json:"word_count"`
+	Language    string  `json:"language,omitempty"`
+	OCRQuality  float32 `json:"ocr_quality"`
+	DuplicateOf string  `json:"duplicate_of,omitempty"` // книга не проиндексирована как копия другой
+}
+
+// Genre жанр без правила сопоставления и количество его книг
+type Genre struct {
+	Genre string `json:"genre"`
+	Books int    `json:"books"`
+}
+
+// FileError ошибка обработки файла
+type FileError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Genres преобразует счётчики жанров в список по убыванию количества книг
+func Genres(unmapped map[string]int) []Genre {
+	genres := make([]Genre, 0, len(unmapped))
+	for g, n := range unmapped {
+		genres = append(genres, Genre{Genre: g, Books: n})
+	}
+	return genres
+}
+
+// Normalize упорядочивает разделы, заменяет пустые разделы пустыми списками,
+// чтобы в JSON они не становились null, и пересчитывает Summary.
+// Вызывается перед записью отчёта.
+func (r *Report) Normalize() {
+	r.Schema = SchemaVersion
+
+	if r.Books == nil {
+		r.Books = []BookStats{}
+	}
+	sort.Slice(r.Books, func(i, j int) bool { return r.Books[i].Path < r.Books[j].Path })
+
+	if r.Duplicates == nil {
+		r.Duplicates = []metadata.Cluster{}
+	}
+	sort.Slice(r.Duplicates, func(i, j int) bool {
+		if r.Duplicates[i].Key != r.Duplicates[j].Key {
+			return r.Duplicates[i].Key < r.Duplicates[j].Key
+		}
+		return r.Duplicates[i].Canonical < r.Duplicates[j].Canonical
+	})
+
+	if r.ContentDuplicates == nil {
+		r.ContentDuplicates = []metadata.ContentPair{}
+	}
+	sort.Slice(r.ContentDuplicates, func(i, j int) bool {
+		a, b := r.ContentDuplicates[i], r.ContentDuplicates[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Other < b.Other
+	})
+
+	if r.UnmappedGenres == nil {
+		r.UnmappedGenres = []Genre{}
+	}
+	sort.Slice(r.UnmappedGenres, func(i, j int) bool {
+		a, b := r.UnmappedGenres[i], r.UnmappedGenres[j]
+		if a.Books != b.Books {
+			return a.Books > b.Books
+		}
+		return a.Genre < b.Genre
+	})
+
+	if r.InvalidFilenames == nil {
+		r.InvalidFilenames = []book.FilenameLint{}
+	}
+	sort.Slice(r.InvalidFilenames, func(i, j int) bool { return r.InvalidFilenames[i].Path < r.InvalidFilenames[j].Path })
+
+	if r.Errors == nil {
+		r.Errors = []FileError{}
+	}
+	sort.Slice(r.Errors, func(i, j int) bool {
+		if r.Errors[i].Path != r.Errors[j].Path {
+			return r.Errors[i].Path < r.Errors[j].Path
+		}
+		return r.Errors[i].Error < r.Errors[j].Error
+	})
+
+	r.Summary = Summary{
+		Books:             len(r.Books),
+		Duplicates:        len(r.Duplicates),
+		ContentDuplicates: len(r.ContentDuplicates),
+		UnmappedGenres:    len(r.UnmappedGenres),
+		InvalidFilenames:  len(r.InvalidFilenames),
+		Errors:            len(r.Errors),
+	}
+	for _, b := range r.Books {
+		r.Summary.Chunks += int64(b.Chunks)
+	}
+}
@@ -0,0 +1,136 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/lib/logger/handlers/slogdiscard"
+	"github.com/terratensor/library/parser/internal/library/book"
+	"github.com/terratensor/library/parser/internal/metadata"
+)
+
+// sample возвращает отчёт, разделы которого заполнены в порядке,
+// зависящем от reverse, как при параллельной обработке файлов
+func sample(reverse bool) *Report {
+	r := &Report{
+		Command: "index",
+		Volume:  "/volume",
+		Books: []BookStats{
+			{Path: "/volume/a.docx", Title: "А", Format: "docx", Chunks: 10, OCRQuality: 0.95},
+			{Path: "/volume/b.docx", Title: "Б <script>", Format: "docx", Chunks: 5},
+		},
+		Duplicates: []metadata.Cluster{
+			{Key: "б", Canonical: "/volume/b.docx", Files: []metadata.DuplicateFile{{Path: "/volume/b.docx", Similarity: 1}, {Path: "/volume/b2.docx", Similarity: 0.9}}},
+			{Key: "а", Canonical: "/volume/a.docx", Files: []metadata.DuplicateFile{{Path: "/volume/a.docx", Similarity: 1}, {Path: "/volume/a2.docx", Similarity: 1}}},
+		},
+		ContentDuplicates: []metadata.ContentPair{
+			{Path: "/volume/b.docx", Other: "/volume/a.docx", Similarity: 0.5, Kind: metadata.KindPartial},
+			{Path: "/volume/a2.docx", Other: "/volume/a.docx", Similarity: 1, Kind: metadata.KindDuplicate, Skipped: true},
+		},
+		UnmappedGenres: Genres(map[string]int{"Поэзия": 2, "Драма": 2, "Проза": 5}),
+		InvalidFilenames: []book.FilenameLint{
+			{Path: "/volume/c.docx", Issues: []string{book.IssueNoGenre}},
+			{Path: "/volume/b2.docx", Issues: []string{book.IssueExtraSpaces, book.IssueWrongDash}},
+		},
+		Errors: []FileError{
+			{Path: "/volume/z.docx", Error: "zip: not a valid zip file"},
+			{Path: "/volume/y.pdf", Error: "PDF processing is disabled in config"},
+		},
+	}
+	if reverse {
+		r.Books[0], r.Books[1] = r.Books[1], r.Books[0]
+		r.Duplicates[0], r.Duplicates[1] = r.Duplicates[1], r.Duplicates[0]
+		r.ContentDuplicates[0], r.ContentDuplicates[1] = r.ContentDuplicates[1], r.ContentDuplicates[0]
+		r.InvalidFilenames[0], r.InvalidFilenames[1] = r.InvalidFilenames[1], r.InvalidFilenames[0]
+		r.Errors[0], r.Errors[1] = r.Errors[1], r.Errors[0]
+	}
+	return r
+}
+
+func render(t *testing.T, write func(w io.Writer) error) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestReportDeterministic(t *testing.T) {
+	a, b := sample(false), sample(true)
+	a.Normalize()
+	b.Normalize()
+
+	writers := map[string]func(r *Report) func(w io.Writer) error{
+		"json":       func(r *Report) func(w io.Writer) error { return r.WriteJSON },
+		"html":       func(r *Report) func(w io.Writer) error { return r.WriteHTML },
+		"books":      func(r *Report) func(w io.Writer) error { return r.writeBooksCSV },
+		"duplicates": func(r *Report) func(w io.Writer) error { return r.writeDuplicatesCSV },
+		"content":    func(r *Report) func(w io.Writer) error { return r.writeContentDuplicatesCSV },
+		"genres":     func(r *Report) func(w io.Writer) error { return r.writeUnmappedGenresCSV },
+		"errors":     func(r *Report) func(w io.Writer) error { return r.writeErrorsCSV },
+	}
+	for name, w := range writers {
+		if got, want := render(t, w(b)), render(t, w(a)); got != want {
+			t.Errorf("%s differs with input order:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+
+	var genres []string
+	for _, g := range a.UnmappedGenres {
+		genres = append(genres, g.Genre)
+	}
+	if got, want := strings.Join(genres, ","), "Проза,Драма,Поэзия"; got != want {
+		t.Errorf("unmapped genres order = %s, want %s", got, want)
+	}
+	if a.Duplicates[0].Key != "а" || a.Errors[0].Path != "/volume/y.pdf" {
+		t.Errorf("sections not sorted: %+v %+v", a.Duplicates, a.Errors)
+	}
+	want := Summary{Books: 2, Chunks: 15, Duplicates: 2, ContentDuplicates: 2, UnmappedGenres: 3, InvalidFilenames: 2, Errors: 2}
+	if a.Summary != want {
+		t.Errorf("Summary = %+v, want %+v", a.Summary, want)
+	}
+
+	if html := render(t, a.WriteHTML); strings.Contains(html, "<script>") {
+		t.Error("HTML report is not escaped")
+	}
+	csv := render(t, a.writeDuplicatesCSV)
+	if !strings.HasPrefix(csv, "key,canonical,path,") || !strings.Contains(csv, "а,/volume/a.docx,/volume/a2.docx,,,0,0.0000,1.0000\n") {
+		t.Errorf("duplicates CSV:\n%s", csv)
+	}
+}
+
+func TestReportEmpty(t *testing.T) {
+	dir := t.TempDir()
+	if err := Save(dir, &Report{Command: "metadata"}, slogdiscard.NewDiscardLogger()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, JSONFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"books", "duplicates", "content_duplicates", "unmapped_genres", "invalid_filenames", "errors"} {
+		if got := string(sections[name]); got != "[]" {
+			t.Errorf("%s = %s, want []", name, got)
+		}
+	}
+	if got := string(sections["schema"]); got != "1" {
+		t.Errorf("schema = %s, want 1", got)
+	}
+
+	for _, name := range []string{HTMLFile, BooksCSV, DuplicatesCSV, ContentDuplicatesCSV, UnmappedGenresCSV, InvalidFilenamesCSV, ErrorsCSV} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/metadata"
+)
+
+// Имена файлов отчёта в каталоге
+const (
+	JSONFile             = "report.json"
+	HTMLFile             = "report.html"
+	BooksCSV             = "books.csv"
+	DuplicatesCSV        = "duplicates.csv"
+	ContentDuplicatesCSV = "content_duplicates.csv"
+	UnmappedGenresCSV    = "unmapped_genres.csv"
+	InvalidFilenamesCSV  = "invalid_filenames.csv"
+	ErrorsCSV            = "errors.csv"
+)
+
+// Save упорядочивает отчёт и записывает его в каталог dir: report.json,
+// report.html и по CSV на каждый раздел. Файлы прошлого запуска перезаписываются.
+func Save(dir string, r *Report, logger *slog.Logger) error {
+	r.Normalize()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create report dir: %v", err)
+	}
+
+	files := []struct {
+		name  string
+		write func(w io.Writer) error
+	}{
+		{JSONFile, r.WriteJSON},
+		{HTMLFile, r.WriteHTML},
+		{BooksCSV, r.writeBooksCSV},
+		{DuplicatesCSV, r.writeDuplicatesCSV},
+		{ContentDuplicatesCSV, r.writeContentDuplicatesCSV},
+		{UnmappedGenresCSV, r.writeUnmappedGenresCSV},
+		{InvalidFilenamesCSV, func(w io.Writer) error { return metadata.WriteLintCSV(w, r.InvalidFilenames) }},
+		{ErrorsCSV, r.writeErrorsCSV},
+	}
+	for _, f := range files {
+		if err := writeFile(filepath.Join(dir, f.name), f.write); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("report saved",
+		slog.String("dir", dir),
+		slog.Int("books", r.Summary.Books),
+		slog.Int("duplicates", r.Summary.Duplicates),
+		slog.Int("content_duplicates", r.Summary.ContentDuplicates),
+		slog.Int("unmapped_genres", r.Summary.UnmappedGenres),
+		slog.Int("invalid_filenames", r.Summary.InvalidFilenames),
+		slog.Int("errors", r.Summary.Errors))
+	return nil
+}
+
+// writeFile записывает файл целиком: при ошибке записи прежний отчёт не портится
+func writeFile(path string, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return fmt.Errorf("failed to write %s: %v", filepath.Base(path), err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// WriteJSON записывает отчёт в JSON с отступами
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Report) writeBooksCSV(w io.Writer) error {
+	return writeCSV(w, []string{"path", "source_uuid", "genre", "author", "title", "year", "volume", "format",
+		"file_size", "chunks", "failed", "char_count", "word_count", "language", "ocr_quality", "duplicate_of"},
+		len(r.Books), func(i int) []string {
+			b := r.Books[i]
+			return []string{b.Path, b.SourceUUID, b.Genre, b.Author, b.Title, itoa(b.Year), itoa(b.Volume), b.Format,
+				i64toa(b.FileSize), itoa(b.Chunks), itoa(b.Failed), i64toa(b.CharCount), i64toa(b.WordCount),
+				b.Language, ftoa(float64(b.OCRQuality)), b.DuplicateOf}
+		})
+}
+
+// writeDuplicatesCSV одна строка на файл кластера, канонический файл первым
+func (r *Report) writeDuplicatesCSV(w io.Writer) error {
+	type row struct {
+		key, canonical string
+		file           metadata.DuplicateFile
+	}
+	var rows []row
+	for _, c := range r.Duplicates {
+		for _, f := range c.Files {
+			rows = append(rows, row{c.Key, c.Canonical, f})
+		}
+	}
+	return writeCSV(w, []string{"key", "canonical", "path", "title", "format", "file_size", "ocr_quality", "similarity"},
+		len(rows), func(i int) []string {
+			f := rows[i].file
+			return []string{rows[i].key, rows[i].canonical, f.Path, f.Title, f.Format, i64toa(f.Size),
+				ftoa(float64(f.OCRQuality)), ftoa(f.Similarity)}
+		})
+}
+
+func (r *Report) writeContentDuplicatesCSV(w io.Writer) error {
+	return writeCSV(w, []string{"path", "other", "kind", "similarity", "containment", "skipped"},
+		len(r.ContentDuplicates), func(i int) []string {
+			p := r.ContentDuplicates[i]
+			return []string{p.Path, p.Other, p.Kind, ftoa(p.Similarity), ftoa(p.Containment), strconv.FormatBool(p.Skipped)}
+		})
+}
+
+func (r *Report) writeUnmappedGenresCSV(w io.Writer) error {
+	return writeCSV(w, []string{"genre", "books"}, len(r.UnmappedGenres), func(i int) []string {
+		return []string{r.UnmappedGenres[i].Genre, itoa(r.UnmappedGenres[i].Books)}
+	})
+}
+
+func (r *Report) writeErrorsCSV(w io.Writer) error {
+	return writeCSV(w, []string{"path", "error"}, len(r.Errors), func(i int) []string {
+		return []string{r.Errors[i].Path, strings.TrimSpace(r.Errors[i].Error)}
+	})
+}
+
+func writeCSV(w io.Writer, header []string, n int, row func(i int) []string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for i := 0; i < n; i++ {
+		cw.Write(row(i))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func itoa(n int) string     { return strconv.Itoa(n) }
+func i64toa(n int64) string { return strconv.FormatInt(n, 10) }
+
+// ftoa форматирует долю с фиксированной точностью, чтобы CSV не менялся
+// от погрешностей вычислений
+func ftoa(f float64) string { return strconv.FormatFloat(f, 'f', 4, 64) }
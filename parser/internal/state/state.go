@@ -0,0 +1,169 @@
+// Package state хранит локальное состояние индексации в встроенной базе bbolt.
+// По нему парсер определяет, какие файлы не изменились с прошлого запуска,
+// какие нужно переиндексировать и какие исчезли из тома.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	StatusDone    = "done"    // книга полностью проиндексирована
+	StatusPartial = "partial" // часть параграфов в очереди недоставленных, см. команду replay
+	StatusFailed  = "failed"  // обработка завершилась ошибкой
+	// StatusDuplicate книга не проиндексирована как почти точная копия другой, см. duplicates.policy
+	StatusDuplicate = "duplicate"
+)
+
+var filesBucket = []byte("files")
+
+// Record состояние одного файла тома
+type Record struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"mod_time"` // UnixNano
+	ContentHash string `json:"content_hash"`
+	SourceUUID  string `json:"source_uuid"`
+	Chunks      int    `json:"chunks"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	IndexedAt   int64  `json:"indexed_at"`
+	MinHash     []byte `json:"minhash,omitempty"`      // сигнатура содержимого, см. similarity.MinHash
+	DuplicateOf string `json:"duplicate_of,omitempty"` // файл, копией которого книга признана при StatusDuplicate
+	Sidecar     string `json:"sidecar,omitempty"`      // отпечаток метаданных книги, см. book.Sidecar.Fingerprint
+}
+
+// Unchanged сообщает, что файл и его метаданные с отпечатком sidecar не менялись
+// с момента успешной индексации или с момента, когда он был признан копией другой книги
+func (r *Record) Unchanged(info os.FileInfo, sidecar string) bool {
+	return (r.Status == StatusDone || r.Status == StatusDuplicate) &&
+		r.Size == info.Size() &&
+		r.ModTime == info.ModTime().UnixNano() &&
+		r.Sidecar == sidecar
+}
+
+// Touch обновляет размер и время модификации файла
+func (r *Record) Touch(info os.FileInfo) {
+	r.Size = info.Size()
+	r.ModTime = info.ModTime().UnixNano()
+}
+
+type Store struct {
+	db *bolt.DB
+}
+
+// Open открывает или создаёт файл состояния
+func Open(path string) (*Store, error) {
+	const op = "state.Open"
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(filesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get возвращает запись по пути файла, ok == false если записи нет
+func (s *Store) Get(path string) (rec Record, ok bool, err error) {
+	const op = "state.Store.Get"
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(filesBucket).Get([]byte(path))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &rec)
+	})
+	if err != nil {
+		return Record{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+	return rec, ok, nil
+}
+
+// Put сохраняет запись, ключом служит Record.Path
+func (s *Store) Put(rec Record) error {
+	const op = "state.Store.Put"
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).Put([]byte(rec.Path), data)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Delete удаляет запись о файле
+func (s *Store) Delete(path string) error {
+	const op = "state.Store.Delete"
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).Delete([]byte(path))
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Referenced сообщает, что книга sourceUUID записана в состоянии для другого
+// файла, кроме except. Файлы с одинаковым содержимым имеют один UUID
+// и одни параграфы в индексе.
+func (s *Store) Referenced(sourceUUID, except string) (bool, error) {
+	const op = "state.Store.Referenced"
+
+	found := false
+	err := s.ForEach(func(rec Record) error {
+		if rec.SourceUUID == sourceUUID && rec.Path != except {
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return found, nil
+}
+
+// ForEach обходит все записи. Изменять хранилище внутри fn нельзя.
+func (s *Store) ForEach(fn func(rec Record) error) error {
+	const op = "state.Store.ForEach"
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).ForEach(func(_, data []byte) error {
+			var rec Record
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+			return fn(rec)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReferenced(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, rec := range []Record{
+		{Path: "/volume/a.docx", SourceUUID: "u1", Status: StatusDone},
+		{Path: "/volume/b.docx", SourceUUID: "u1", Status: StatusDone},
+		{Path: "/volume/c.docx", SourceUUID: "u2", Status: StatusDone},
+	} {
+		if err := s.Put(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		uuid, except string
+		want         bool
+	}{
+		{"u1", "/volume/a.docx", true},
+		{"u2", "/volume/c.docx", false},
+		{"u3", "", false},
+	}
+	for _, tt := range tests {
+		got, err := s.Referenced(tt.uuid, tt.except)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Referenced(%q, %q) = %v, want %v", tt.uuid, tt.except, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Package async асинхронная стадия записи документов.
+//
+// Парсеры отдают документы в ограниченную очередь и продолжают работу, а отдельная
+// горутина собирает из документов разных книг пакеты, ограниченные количеством
+// документов и размером в байтах. Пакеты записываются несколькими параллельными
+// флашерами. Когда очередь заполнена, Submit блокируется, притормаживая чтение книг.
+package async
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+var ErrClosed = errors.New("async writer is closed")
+
+var (
+	_ storage.Writer    = &Writer{}
+	_ storage.Submitter = &Writer{}
+)
+
+// Stats счётчики работы writer'а
+type Stats struct {
+	Submitted int64         // документов принято в очередь
+	Written   int64         // документов записано
+	Failed    int64         // документов не записано
+	Batches   int64         // отправлено пакетов
+	Bytes     int64         // байт в отправленных пакетах
+	Queued    int           // документов в очереди сейчас
+	FlushTime time.Duration // суммарное время записи пакетов
+}
+
+type doc struct {
+	storage.Document
+	size int
+	t    *ticket
+}
+
+type Writer struct {
+	next storage.Writer
+
+	batchDocs  int
+	batchBytes int
+	interval   time.Duration
+
+	in      chan doc
+	batches chan []doc
+	mu      sync.RWMutex // Submit держит RLock на время отправки в in, Close закрывает in под Lock
+	closed  bool
+	done    chan struct{}
+
+	submitted, written, failed, nbatches, bytes, flushNanos atomic.Int64
+}
+
+// New запускает writer поверх бэкенда next. batchSize используется,
+// если в конфиге writer'а не задан собственный размер пакета.
+func New(next storage.Writer, cfg *config.Writer, batchSize int) *Writer {
+	w := &Writer{
+		next:       next,
+		batchDocs:  cfg.BatchSize,
+		batchBytes: cfg.BatchBytes,
+		interval:   time.Duration(cfg.FlushIntervalMs) * time.Millisecond,
+		in:         make(chan doc, max(cfg.QueueSize, 1)),
+		batches:    make(chan []doc),
+		done:       make(chan struct{}),
+	}
+	if w.batchDocs <= 0 {
+		w.batchDocs = max(batchSize, 1)
+	}
+	if w.interval <= 0 {
+		w.interval = time.Second
+	}
+
+	var flushers sync.WaitGroup
+	for range max(cfg.Flushers, 1) {
+		flushers.Add(1)
+		go func() {
+			defer flushers.Done()
+			for batch := range w.batches {
+				w.flush(batch)
+			}
+		}()
+	}
+	go func() {
+		w.batch()
+		close(w.batches)
+		flushers.Wait()
+		close(w.done)
+	}()
+	return w
+}
+
+// Submit ставит документы в очередь. Блокируется, пока в очереди нет места.
+// Результат записи можно дождаться через возвращённый Pending.
+func (w *Writer) Submit(ctx context.Context, docs []storage.Document) (storage.Pending, error) {
+	const op = "storage.async.Submit"
+
+	// Тело сериализуется сразу: так известен размер для ограничения пакета,
+	// а бэкенд не сериализует документ повторно
+	items := make([]doc, len(docs))
+	for i, d := range docs {
+		data, err := json.Marshal(d.Doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		d.Doc = json.RawMessage(data)
+		items[i] = doc{Document: d, size: len(data)}
+	}
+	t := newTicket(len(items))
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return nil, fmt.Errorf("%s: %w", op, ErrClosed)
+	}
+	for i := range items {
+		items[i].t = t
+		select {
+		case w.in <- items[i]:
+			w.submitted.Add(1)
+		case <-ctx.Done():
+			// Неотправленные документы считаются недописанными
+			for _, d := range items[i:] {
+				t.fail(d.Document, ctx.Err())
+				t.finish()
+			}
+			return t, fmt.Errorf("%s: %w", op, ctx.Err())
+		}
+	}
+	return t, nil
+}
+
+// Write ставит документы в очередь и ждёт их записи
+func (w *Writer) Write(ctx context.Context, docs []storage.Document) error {
+	pending, err := w.Submit(ctx, docs)
+	if err != nil {
+		return err
+	}
+	return pending.Wait()
+}
+
+// Close перестаёт принимать документы, записывает всё, что осталось в очереди,
+// и ждёт завершения флашеров. ctx ограничивает время ожидания.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.in)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("storage.async.Close: %d documents left in queue: %w", len(w.in), ctx.Err())
+	}
+}
+
+// Stats возвращает текущие счётчики
+func (w *Writer) Stats() Stats {
+	return Stats{
+		Submitted: w.submitted.Load(),
+		Written:   w.written.Load(),
+		Failed:    w.failed.Load(),
+		Batches:   w.nbatches.Load(),
+		Bytes:     w.bytes.Load(),
+		Queued:    len(w.in),
+		FlushTime: time.Duration(w.flushNanos.Load()),
+	}
+}
+
+// batch собирает документы из очереди в пакеты и передаёт их флашерам.
+// Неполный пакет отправляется по истечении интервала.
+func (w *Writer) batch() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	var batch []doc
+	size := 0
+	send := func() {
+		if len(batch) > 0 {
+			w.batches <- batch
+			batch, size = nil, 0
+		}
+	}
+
+	for {
+		select {
+		case d, ok := <-w.in:
+			if !ok {
+				send()
+				return
+			}
+			// Пакет не должен превысить лимит байт, кроме случая одного большого документа
+			if len(batch) > 0 && w.batchBytes > 0 && size+d.size > w.batchBytes {
+				send()
+			}
+			batch = append(batch, d)
+			size += d.size
+			if len(batch) >= w.batchDocs {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		}
+	}
+}
+
+// flush записывает пакет и сообщает результат каждому документу
+func (w *Writer) flush(batch []doc) {
+	docs := make([]storage.Document, len(batch))
+	size := 0
+	for i, d := range batch {
+		docs[i] = d.Document
+		size += d.size
+	}
+
+	start := time.Now()
+	// Запись не прерывается отменой контекста парсера, чтобы очередь можно было дописать при остановке
+	err := w.next.Write(context.Background(), docs)
+	w.flushNanos.Add(int64(time.Since(start)))
+	w.nbatches.Add(1)
+	w.bytes.Add(int64(size))
+
+	failed := make([]bool, len(batch))
+	if err != nil {
+		var werr *storage.WriteError
+		if errors.As(err, &werr) && werr.Positions != nil {
+			for _, n := range werr.Positions {
+				if n >= 0 && n < len(failed) {
+					failed[n] = true
+				}
+			}
+		} else {
+			for i := range failed {
+				failed[i] = true
+			}
+		}
+	}
+
+	for i, d := range batch {
+		if failed[i] {
+			w.failed.Add(1)
+			d.t.fail(d.Document, err)
+		} else {
+			w.written.Add(1)
+		}
+		d.t.finish()
+	}
+}
+
+// ticket результат одного вызова Submit
+type ticket struct {
+	mu        sync.Mutex
+	remaining int
+	failed    []storage.Document
+	err       error
+	done      chan struct{}
+}
+
+func newTicket(n int) *ticket {
+	t := &ticket{remaining: n, done: make(chan struct{})}
+	if n == 0 {
+		close(t.done)
+	}
+	return t
+}
+
+func (t *ticket) fail(d storage.Document, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.failed = append(t.failed, d)
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *ticket) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.remaining--
+	if t.remaining == 0 {
+		close(t.done)
+	}
+}
+
+// Wait ждёт записи всех документов и возвращает *storage.WriteError с недописанными
+func (t *ticket) Wait() error {
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.failed) == 0 {
+		return nil
+	}
+	return &storage.WriteError{Docs: t.failed, Err: t.err}
+}
@@ -0,0 +1,109 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// backend записывает пакеты и отклоняет документы с ID из reject
+type backend struct {
+	mu      sync.Mutex
+	batches []int
+	reject  map[int64]bool
+}
+
+func (b *backend) Write(ctx context.Context, docs []storage.Document) error {
+	b.mu.Lock()
+	b.batches = append(b.batches, len(docs))
+	b.mu.Unlock()
+
+	werr := &storage.WriteError{Err: errors.New("rejected")}
+	for i, d := range docs {
+		if d.ID != nil && b.reject[*d.ID] {
+			werr.Docs = append(werr.Docs, d)
+			werr.Positions = append(werr.Positions, i)
+		}
+	}
+	if len(werr.Docs) > 0 {
+		return werr
+	}
+	return nil
+}
+
+func documents(ids ...int64) []storage.Document {
+	docs := make([]storage.Document, len(ids))
+	for i := range ids {
+		docs[i] = storage.Document{Table: "library", ID: &ids[i], Doc: map[string]int64{"chunk": ids[i]}}
+	}
+	return docs
+}
+
+func TestWriterCoalescesAndReportsPerTicket(t *testing.T) {
+	b := &backend{reject: map[int64]bool{5: true}}
+	w := New(b, &config.Writer{Flushers: 2, QueueSize: 100, BatchSize: 4, FlushIntervalMs: 10000}, 0)
+
+	ctx := context.Background()
+	first, err := w.Submit(ctx, documents(1, 2, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := w.Submit(ctx, documents(4, 5, 6, 7, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Close дописывает неполный последний пакет
+	if err := w.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Wait(); err != nil {
+		t.Errorf("first ticket: %v", err)
+	}
+	var werr *storage.WriteError
+	if err := second.Wait(); !errors.As(err, &werr) {
+		t.Fatalf("second ticket: expected *storage.WriteError, got %v", err)
+	}
+	if len(werr.Docs) != 1 || *werr.Docs[0].ID != 5 {
+		t.Errorf("unexpected failed docs: %+v", werr.Docs)
+	}
+
+	// 8 документов из двух книг собираются в два пакета по 4
+	if len(b.batches) != 2 || b.batches[0] != 4 || b.batches[1] != 4 {
+		t.Errorf("got batches %v, want [4 4]", b.batches)
+	}
+
+	st := w.Stats()
+	if st.Submitted != 8 || st.Written != 7 || st.Failed != 1 || st.Batches != 2 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+
+	if _, err := w.Submit(ctx, documents(9)); !errors.Is(err, ErrClosed) {
+		t.Errorf("submit after close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestWriterBatchBytes(t *testing.T) {
+	b := &backend{}
+	// Каждый документ {"chunk":N} занимает 11 байт, в пакет помещаются два
+	w := New(b, &config.Writer{Flushers: 1, QueueSize: 10, BatchSize: 100, BatchBytes: 25, FlushIntervalMs: 10000}, 0)
+
+	pending, err := w.Submit(context.Background(), documents(1, 2, 3, 4, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := pending.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.batches) != 3 || b.batches[0] != 2 || b.batches[2] != 1 {
+		t.Errorf("got batches %v, want [2 2 1]", b.batches)
+	}
+}
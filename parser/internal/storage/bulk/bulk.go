@@ -0,0 +1,63 @@
+// Package bulk формирует строки bulk-запроса Manticore в формате NDJSON.
+// Одни и те же строки отправляются в Manticore и пишутся файловым хранилищем,
+// поэтому выгрузку можно позже загрузить в Manticore без преобразований.
+package bulk
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+type Insert struct {
+	Index string      `json:"index"`
+	ID    *int64      `json:"id,omitempty"`
+	Doc   interface{} `json:"doc"`
+}
+
+// Delete удаление документов по запросу
+type Delete struct {
+	Index string                 `json:"index"`
+	Query map[string]interface{} `json:"query"`
+}
+
+// Root строка bulk-запроса. Документы с известным ID отправляются через replace,
+// чтобы повторная индексация того же файла перезаписывала чанки, а не дублировала их.
+type Root struct {
+	Insert  *Insert `json:"insert,omitempty"`
+	Replace *Insert `json:"replace,omitempty"`
+	Delete  *Delete `json:"delete,omitempty"`
+}
+
+// Line возвращает строку вставки документа
+func Line(index string, id *int64, doc interface{}) ([]byte, error) {
+	action := &Insert{Index: index, ID: id, Doc: doc}
+	if id != nil {
+		return json.Marshal(Root{Replace: action})
+	}
+	return json.Marshal(Root{Insert: action})
+}
+
+// DeleteLine возвращает строку удаления документов, у которых field равно value
+func DeleteLine(index, field, value string) ([]byte, error) {
+	return json.Marshal(Root{Delete: &Delete{
+		Index: index,
+		Query: map[string]interface{}{
+			"equals": map[string]interface{}{field: value},
+		},
+	}})
+}
+
+// Lines возвращает строки вставки пакета документов
+func Lines(docs []storage.Document) ([][]byte, error) {
+	lines := make([][]byte, 0, len(docs))
+	for _, d := range docs {
+		line, err := Line(d.Table, d.ID, d.Doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", d.Table, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
@@ -0,0 +1,188 @@
+package manticore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotAlias is returned when a table expected to be a distributed alias
+// is a regular table.
+var ErrNotAlias = errors.New("table is not a distributed alias")
+
+var localRe = regexp.MustCompile(`local='([^']+)'`)
+
+// VersionTable returns the name of the table built by blue/green reindex version n.
+func VersionTable(alias string, n int) string {
+	return alias + VersionSuffix(n)
+}
+
+// VersionSuffix returns the suffix of all tables of blue/green reindex version n,
+// content and catalogue ones, see config.Manticore.Version.
+func VersionSuffix(n int) string {
+	return fmt.Sprintf("_v%d", n)
+}
+
+// Versions returns the built versions of an alias in ascending order.
+func (c *Client) Versions(ctx context.Context, alias string) ([]int, error) {
+	const op = "storage.manticore.Versions"
+
+	rows, err := c.sql(ctx, fmt.Sprintf("SHOW TABLES LIKE '%v%%'", quote(alias)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(alias) + `_v(\d+)$`)
+
+	var versions []int
+	for _, row := range rows {
+		name, _ := row["Table"].(string)
+		if name == "" {
+			name, _ = row["Index"].(string) // до Manticore 6
+		}
+		if m := re.FindStringSubmatch(name); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			versions = append(versions, n)
+		}
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// AliasTables returns the local tables of a distributed alias, or nil if the
+// alias does not exist. A regular table with the alias name is ErrNotAlias.
+func (c *Client) AliasTables(ctx context.Context, alias string) ([]string, error) {
+	const op = "storage.manticore.AliasTables"
+
+	if !tableExists(ctx, alias) {
+		return nil, nil
+	}
+	rows, err := c.sql(ctx, fmt.Sprintf("SHOW CREATE TABLE %v", alias))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	var create string
+	if len(rows) > 0 {
+		create, _ = rows[0]["Create Table"].(string)
+	}
+	tables, ok := aliasLocals(create)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s: %w", op, alias, ErrNotAlias)
+	}
+	return tables, nil
+}
+
+// aliasLocals parses the local tables from SHOW CREATE TABLE output of a distributed table.
+func aliasLocals(create string) ([]string, bool) {
+	if !strings.Contains(create, "type='distributed'") {
+		return nil, false
+	}
+	var tables []string
+	for _, m := range localRe.FindAllStringSubmatch(create, -1) {
+		tables = append(tables, m[1])
+	}
+	return tables, true
+}
+
+// SwitchAliases points each distributed alias to its tables, e.g. the content
+// and catalogue aliases to the tables of one version. Manticore cannot change
+// the locals of a distributed table in place, so the new aliases are first
+// created under staging names, then one RENAME TABLE statement moves the live
+// aliases aside and the staging ones into their place, see switchStatements.
+// Readers never miss an alias and never see the aliases in different
+// versions. If the server rejects any statement, the live aliases are left
+// untouched and the staging tables are dropped; there is no fallback to
+// dropping and recreating an alias.
+func (c *Client) SwitchAliases(ctx context.Context, aliases map[string][]string) error {
+	const op = "storage.manticore.SwitchAliases"
+
+	live := make(map[string]bool, len(aliases))
+	for alias, tables := range aliases {
+		if _, err := c.AliasTables(ctx, alias); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		live[alias] = tableExists(ctx, alias)
+		for _, t := range tables {
+			if !tableExists(ctx, t) {
+				return fmt.Errorf("%s: %s: table %s does not exist", op, alias, t)
+			}
+		}
+	}
+
+	sw := switchStatements(aliases, live)
+	defer c.dropAll(ctx, sw.cleanup)
+	for _, query := range append(sw.stage, sw.swap) {
+		if _, err := c.sql(ctx, query); err != nil {
+			return fmt.Errorf("%s: %s: %w", op, query, err)
+		}
+	}
+	return nil
+}
+
+// aliasSwitch statements of an alias switch
+type aliasSwitch struct {
+	stage   []string // create the new aliases under staging names
+	swap    string   // one statement moving them into place
+	cleanup []string // tables to drop afterwards, whether the switch succeeded or not
+}
+
+// switchStatements returns the statements repointing aliases in the order of
+// their names. live reports which aliases already exist: they are renamed to
+// <alias>_prev by the same statement that renames <alias>_next to <alias>.
+func switchStatements(aliases map[string][]string, live map[string]bool) aliasSwitch {
+	names := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+
+	var sw aliasSwitch
+	var renames []string
+	for _, alias := range names {
+		next, prev := alias+"_next", alias+"_prev"
+		sw.stage = append(sw.stage,
+			fmt.Sprintf("DROP TABLE IF EXISTS %v", next),
+			fmt.Sprintf("DROP TABLE IF EXISTS %v", prev),
+			createAlias(next, aliases[alias]))
+		if live[alias] {
+			renames = append(renames, fmt.Sprintf("%v TO %v", alias, prev))
+			sw.cleanup = append(sw.cleanup, prev)
+		}
+		renames = append(renames, fmt.Sprintf("%v TO %v", next, alias))
+		sw.cleanup = append(sw.cleanup, next)
+	}
+	sw.swap = "RENAME TABLE " + strings.Join(renames, ", ")
+	return sw
+}
+
+// dropAll drops tables left by an alias switch, errors are only logged.
+func (c *Client) dropAll(ctx context.Context, tables []string) {
+	for _, t := range tables {
+		if err := c.DropTable(ctx, t); err != nil {
+			log.Printf("error dropping %s: %v", t, err)
+		}
+	}
+}
+
+// createAlias returns the statement creating a distributed alias of tables.
+func createAlias(alias string, tables []string) string {
+	locals := make([]string, len(tables))
+	for i, t := range tables {
+		locals[i] = fmt.Sprintf("local='%v'", t)
+	}
+	return fmt.Sprintf("CREATE TABLE %v type='distributed' %v", alias, strings.Join(locals, " "))
+}
+
+// DropTable removes a table if it exists.
+func (c *Client) DropTable(ctx context.Context, name string) error {
+	const op = "storage.manticore.DropTable"
+
+	if _, err := c.sql(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %v", name)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package manticore
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAliasLocals(t *testing.T) {
+	tests := []struct {
+		name   string
+		create string
+		want   []string
+		ok     bool
+	}{
+		{
+			name:   "distributed",
+			create: "CREATE TABLE library type='distributed' local='library_v3' local='library_v3_de'",
+			want:   []string{"library_v3", "library_v3_de"},
+			ok:     true,
+		},
+		{
+			name:   "regular table",
+			create: "CREATE TABLE library (\ncontent text\n) engine='columnar'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := aliasLocals(tt.create)
+			if ok != tt.ok || !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSwitchStatements(t *testing.T) {
+	sw := switchStatements(map[string][]string{
+		"library": {"library_v3", "library_v3_de"},
+		"books":   {"books_v3"},
+	}, map[string]bool{"library": true})
+
+	wantStage := []string{
+		"DROP TABLE IF EXISTS books_next",
+		"DROP TABLE IF EXISTS books_prev",
+		"CREATE TABLE books_next type='distributed' local='books_v3'",
+		"DROP TABLE IF EXISTS library_next",
+		"DROP TABLE IF EXISTS library_prev",
+		"CREATE TABLE library_next type='distributed' local='library_v3' local='library_v3_de'",
+	}
+	if !slices.Equal(sw.stage, wantStage) {
+		t.Errorf("stage = %q, want %q", sw.stage, wantStage)
+	}
+	// Все псевдонимы переключаются одной командой, живой уходит в _prev
+	if want := "RENAME TABLE books_next TO books, library TO library_prev, library_next TO library"; sw.swap != want {
+		t.Errorf("swap = %q, want %q", sw.swap, want)
+	}
+	if want := []string{"books_next", "library_prev", "library_next"}; !slices.Equal(sw.cleanup, want) {
+		t.Errorf("cleanup = %q, want %q", sw.cleanup, want)
+	}
+}
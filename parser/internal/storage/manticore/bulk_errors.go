@@ -0,0 +1,239 @@
+package manticore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	openapiclient "github.com/manticoresoftware/manticoresearch-go"
+)
+
+// Delays between bulk retries grow exponentially from retryBaseDelay up to retryMaxDelay.
+var (
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// permanentTypes are the Elasticsearch-compatible error types Manticore reports
+// for malformed documents. They will not go away on retry even with a 5xx status.
+var permanentTypes = map[string]bool{
+	"action_request_validation_exception": true,
+	"mapper_parsing_exception":            true,
+	"parse_exception":                     true,
+	"illegal_argument_exception":          true,
+}
+
+// permanentMessages match the beginning of Manticore error messages
+// that will not go away on retry.
+var permanentMessages = []*regexp.Regexp{
+	regexp.MustCompile(`^unknown column: '`),
+	regexp.MustCompile(`^(table|index) '[^']*' absent`),
+	regexp.MustCompile(`^unknown local (table|index)`),
+	regexp.MustCompile(`^duplicate id '`),
+	regexp.MustCompile(`^P\d+: syntax error`),
+}
+
+// ItemError describes a bulk line rejected by Manticore.
+type ItemError struct {
+	Line      int    // index of the line in the request
+	Table     string // table from the response, if reported
+	ID        int64  // document ID from the response, if reported
+	Status    int    // HTTP status of the item or of the whole request
+	Reason    string
+	Transient bool   // the failure may succeed on retry
+	Skipped   bool   // not applied because an earlier line of the request failed
+	Payload   []byte // the original bulk line
+}
+
+func (e ItemError) Error() string {
+	return fmt.Sprintf("line %d id %d status %d: %s", e.Line, e.ID, e.Status, e.Reason)
+}
+
+// BulkError lists documents of a bulk request that were not written.
+type BulkError struct {
+	Table string
+	Total int // number of lines in the request
+	Items []ItemError
+	Err   error // the last transport or context error, if any
+}
+
+func (e *BulkError) Error() string {
+	msg := fmt.Sprintf("bulk %s: %d of %d documents failed", e.Table, len(e.Items), e.Total)
+	if len(e.Items) > 0 {
+		msg += ", first: " + e.Items[0].Error()
+	}
+	return msg
+}
+
+func (e *BulkError) Unwrap() error {
+	return e.Err
+}
+
+// sendBulk sends the lines listed in pending and returns the failed ones.
+// A transport error is returned as well so the caller can report it.
+func (c *Client) sendBulk(ctx context.Context, lines [][]byte, pending []int) ([]ItemError, error) {
+	var body strings.Builder
+	for _, n := range pending {
+		body.Write(lines[n])
+		body.WriteString("\n")
+	}
+
+	resp, httpResp, err := c.apiClient.IndexAPI.Bulk(ctx).Body(body.String()).Execute()
+	status := 0
+	if httpResp != nil {
+		status = httpResp.StatusCode
+	}
+
+	if err != nil {
+		// Manticore returns a bulk response with item errors along with a non-2xx status,
+		// the client then reports it as GenericOpenAPIError with the raw body
+		var apiErr *openapiclient.GenericOpenAPIError
+		if errors.As(err, &apiErr) {
+			var parsed openapiclient.BulkResponse
+			if json.Unmarshal(apiErr.Body(), &parsed) == nil && (len(parsed.Items) > 0 || parsed.Error != nil) {
+				return itemErrors(&parsed, lines, pending, status), err
+			}
+		}
+		failed := make([]ItemError, len(pending))
+		for i, n := range pending {
+			failed[i] = ItemError{
+				Line:      n,
+				Status:    status,
+				Reason:    err.Error(),
+				Transient: transientStatus(status),
+				Payload:   lines[n],
+			}
+		}
+		return failed, err
+	}
+
+	if resp == nil || resp.Errors == nil || !*resp.Errors {
+		return nil, nil
+	}
+	return itemErrors(resp, lines, pending, status), nil
+}
+
+// itemErrors matches the response items with the sent lines.
+// Manticore stops at the first failed line, the remaining lines are skipped
+// and may be resent as is.
+func itemErrors(resp *openapiclient.BulkResponse, lines [][]byte, pending []int, status int) []ItemError {
+	var failed []ItemError
+	for i, n := range pending {
+		if i >= len(resp.Items) {
+			item := ItemError{
+				Line:      n,
+				Status:    status,
+				Reason:    "skipped after a failed line",
+				Transient: true,
+				Skipped:   true,
+				Payload:   lines[n],
+			}
+			// The whole request failed before any line was applied
+			if i == 0 && resp.Error != nil && *resp.Error != "" {
+				item.Reason = *resp.Error
+				item.Transient = transientStatus(status) && !permanentError("", item.Reason)
+				item.Skipped = false
+			}
+			failed = append(failed, item)
+			continue
+		}
+
+		for _, v := range resp.Items[i] {
+			result, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			typ, msg := errorParts(result["error"])
+			reason := errorReason(typ, msg)
+			itemStatus := int(toInt64(result["status"]))
+			if reason == "" && itemStatus < 300 {
+				continue
+			}
+			if reason == "" {
+				reason = fmt.Sprintf("status %d", itemStatus)
+			}
+			table, _ := result["_index"].(string)
+			failed = append(failed, ItemError{
+				Line:      n,
+				Table:     table,
+				ID:        toInt64(result["_id"]),
+				Status:    itemStatus,
+				Reason:    reason,
+				Transient: transientStatus(itemStatus) && !permanentError(typ, msg),
+				Payload:   lines[n],
+			})
+		}
+	}
+	return failed
+}
+
+// errorParts extracts the type and the message of an item error reported
+// either as a string or as an object with type and reason.
+func errorParts(v interface{}) (typ, msg string) {
+	switch e := v.(type) {
+	case string:
+		return "", e
+	case map[string]interface{}:
+		typ, _ = e["type"].(string)
+		msg, _ = e["reason"].(string)
+	}
+	return typ, msg
+}
+
+// errorReason joins the type and the message of an item error.
+func errorReason(typ, msg string) string {
+	switch {
+	case typ == "":
+		return msg
+	case msg == "":
+		return typ
+	}
+	return typ + ": " + msg
+}
+
+// transientStatus reports whether a request with the status may succeed on retry.
+// Zero means no response was received at all.
+func transientStatus(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+// permanentError reports whether an error with the type and the message
+// will fail again on retry.
+func permanentError(typ, msg string) bool {
+	if permanentTypes[typ] {
+		return true
+	}
+	for _, re := range permanentMessages {
+		if re.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the retry attempt, half of it is random jitter
+// so that parallel workers do not hit Manticore at the same moment.
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << attempt
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,159 @@
+package manticore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openapiclient "github.com/manticoresoftware/manticoresearch-go"
+)
+
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	delay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = delay })
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	configuration := openapiclient.NewConfiguration()
+	configuration.Servers = openapiclient.ServerConfigurations{{URL: srv.URL}}
+	return &Client{Index: "library", apiClient: openapiclient.NewAPIClient(configuration), maxRetries: 3}
+}
+
+func TestBulkLinesRetriesTransientItems(t *testing.T) {
+	var bodies []string
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.Header().Set("Content-Type", "application/json")
+		if len(bodies) == 1 {
+			io.WriteString(w, `{"errors":true,"items":[
+				{"replace":{"_index":"library","_id":1,"status":201}},
+				{"replace":{"_index":"library","_id":2,"status":503,"error":"temporarily unavailable"}},
+				{"replace":{"_index":"library","_id":3,"status":500,"error":{"type":"action_request_validation_exception","reason":"unknown column: 'foo'"}}}
+			]}`)
+			return
+		}
+		io.WriteString(w, `{"errors":false,"items":[{"replace":{"_index":"library","_id":2,"status":201}}]}`)
+	})
+
+	lines := [][]byte{[]byte(`{"replace":1}`), []byte(`{"replace":2}`), []byte(`{"replace":3}`)}
+	err := client.BulkLines(context.Background(), "library", lines)
+
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("expected *BulkError, got %v", err)
+	}
+	if len(bulkErr.Items) != 1 {
+		t.Fatalf("got %d failed items, want 1: %v", len(bulkErr.Items), bulkErr)
+	}
+	item := bulkErr.Items[0]
+	if item.Line != 2 || item.ID != 3 || item.Transient || string(item.Payload) != `{"replace":3}` {
+		t.Errorf("unexpected failed item: %+v", item)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("got %d requests, want 2", len(bodies))
+	}
+	if strings.TrimSpace(bodies[1]) != `{"replace":2}` {
+		t.Errorf("retry resent %q, want only the transient line", bodies[1])
+	}
+}
+
+func TestBulkLinesResendsSkippedLinesWithoutAttempts(t *testing.T) {
+	requests := 0
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		// The first line of every request is rejected, the rest are skipped
+		io.WriteString(w, `{"errors":true,"items":[
+			{"replace":{"_index":"library","_id":1,"status":400,"error":"unknown column: 'foo'"}}
+		]}`)
+	})
+	client.maxRetries = 0
+	retryBaseDelay = time.Hour // a retry with backoff would not fit the timeout
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lines := [][]byte{[]byte(`{"replace":1}`), []byte(`{"replace":2}`), []byte(`{"replace":3}`)}
+	err := client.BulkLines(ctx, "library", lines)
+
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("expected *BulkError, got %v", err)
+	}
+	if requests != 3 || bulkErr.Err != nil {
+		t.Errorf("got %d requests, %v, want 3 without waiting", requests, bulkErr.Err)
+	}
+	if len(bulkErr.Items) != 3 {
+		t.Fatalf("got %d failed items, want 3: %v", len(bulkErr.Items), bulkErr)
+	}
+	for _, item := range bulkErr.Items {
+		if item.Skipped || item.Transient {
+			t.Errorf("line %d reported as %q, want its own permanent error", item.Line, item.Reason)
+		}
+	}
+}
+
+func TestBulkLinesDoesNotRetryClientErrors(t *testing.T) {
+	requests := 0
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":{"type":"bad request"}}`)
+	})
+
+	lines := [][]byte{[]byte(`{"insert":1}`), []byte(`{"insert":2}`)}
+	err := client.BulkLines(context.Background(), "library", lines)
+
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("expected *BulkError, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+	if len(bulkErr.Items) != 2 || bulkErr.Items[0].Status != http.StatusBadRequest {
+		t.Errorf("unexpected failed items: %+v", bulkErr.Items)
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	tests := []struct {
+		typ, msg string
+		want     bool
+	}{
+		{"", "unknown column: 'foo'", true},
+		{"", "table 'library' absent", true},
+		{"", "index 'library' absent", true},
+		{"", "unknown local table(s) 'library' in search request", true},
+		{"", "duplicate id '42'", true},
+		{"", "P01: syntax error, unexpected identifier near 'foo'", true},
+		{"action_request_validation_exception", "", true},
+		{"mapper_parsing_exception", "failed to parse field", true},
+		{"parse_exception", "", true},
+		{"illegal_argument_exception", "", true},
+		// Known words elsewhere in the message do not make it permanent
+		{"", "temporarily unavailable", false},
+		{"", "cluster node not found, retry later", false},
+		{"", "invalid state: table is being rotated", false},
+		{"", "wrong lock state", false},
+		{"", "failed to parse: connection reset", false},
+		{"", "too many open files: table 'library' absent", false},
+		{"internal_error", "unknown column: 'foo'", true},
+		{"internal_error", "", false},
+	}
+	for _, tt := range tests {
+		if got := permanentError(tt.typ, tt.msg); got != tt.want {
+			t.Errorf("permanentError(%q, %q) = %v, want %v", tt.typ, tt.msg, got, tt.want)
+		}
+	}
+}
@@ -2,15 +2,19 @@ package manticore
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
 
+	"github.com/google/uuid"
 	openapiclient "github.com/manticoresoftware/manticoresearch-go"
 	"github.com/terratensor/library/parser/internal/config"
 	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/storage"
+	"github.com/terratensor/library/parser/internal/storage/bulk"
 )
 
 var _ entry.StorageInterface = &Client{}
@@ -18,45 +22,58 @@ var _ entry.StorageInterface = &Client{}
 var apiClient *openapiclient.APIClient
 
 type Client struct {
-	Index     string
-	apiClient *openapiclient.APIClient
+	Index      string
+	apiClient  *openapiclient.APIClient
+	maxRetries int
+	cfg        *config.Manticore
+	tables     []string // таблицы параграфов: основная и языковые
+	bookTables []string // таблицы, из которых удаляется книга: параграфы, каталог и связи
 }
 
-type Insert struct {
-	Index string `json:"index"`
-	ID    *int64 `json:"id,omitempty"`
-	// Doc   entry.Entry `json:"doc"`
-	Doc interface{} `json:"doc"` // Changed to interface{} to support different types
-}
+// New connects to Manticore and brings the tables to the expected schemas:
+// missing tables are created and missing columns are added. In the "check"
+// migrate mode any difference is reported as an error instead.
+//
+// Parameters:
+// - schemas: Table descriptors of all models stored by the client.
+func New(ctx context.Context, cfg *config.Manticore, schemas []storage.Schema) (*Client, error) {
+	const op = "storage.manticore.New"
+
+	if cfg.Migrate != "" && cfg.Migrate != "auto" && cfg.Migrate != "check" {
+		return nil, fmt.Errorf("%s: unknown migrate mode %q", op, cfg.Migrate)
+	}
 
-type Root struct {
-	Insert Insert `json:"insert"`
+	c := Connect(cfg)
+	plan, err := c.PlanMigrations(ctx, schemas)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !plan.Empty() && cfg.Migrate == "check" {
+		return nil, fmt.Errorf("%s: schema differs from the expected one, run the migrate command: %d tables to create, %d columns to add, %d incompatible",
+			op, len(plan.Create), len(plan.Alter), len(plan.Incompatible))
+	}
+	if err := c.Migrate(ctx, plan); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return c, nil
 }
 
-func New(ctx context.Context, cfg *config.Manticore) (*Client, error) {
-	const op = "storage.manticore.New"
+// Connect creates a client without checking the tables.
+func Connect(cfg *config.Manticore) *Client {
 	// Initialize apiClient
 	configuration := openapiclient.NewConfiguration()
 	configuration.Servers = openapiclient.ServerConfigurations{{URL: serverConfigurationURL(cfg)}}
 	apiClient = openapiclient.NewAPIClient(configuration)
 
-	tables := []string{"authors", "categories", "titles"}
-	mtbl := cfg.Index
-	tables = append(tables, mtbl)
-	engine := cfg.Engine
-
-	// Check if table exists in cache
-	for _, tbl := range tables {
-		exists := tableExists(ctx, tbl)
-		if !exists {
-			// Create table if it doesn't exist
-			if err := createTable(ctx, engine, tbl); err != nil {
-				return nil, fmt.Errorf("%s: %w", op, err)
-			}
-		}
+	return &Client{
+		Index:      cfg.Index,
+		apiClient:  apiClient,
+		maxRetries: cfg.MaxRetries,
+		cfg:        cfg,
+		tables:     entry.ContentTables(cfg.Index, cfg.LanguageCodes()),
+		bookTables: entry.BookTables(cfg.Index, cfg.Version, cfg.LanguageCodes()),
 	}
-
-	return &Client{Index: mtbl, apiClient: apiClient}, nil
 }
 
 // tableExists checks whether a table with the specified name exists in the database.
@@ -76,22 +93,14 @@ func tableExists(ctx context.Context, tableName string) bool {
 	return err == nil
 }
 
-func createTable(ctx context.Context, engine string, tbl string) error {
+func createTable(ctx context.Context, schema storage.Schema, settings config.TableSettings) error {
 	const op = "storage.manticore.createTable"
 
-	settings := fmt.Sprintf(`engine='%v' min_infix_len='3' index_exact_words='1' morphology='stem_en, stem_ru' index_sp='1'`, engine)
-
-	var query string
-	switch tbl {
-	case "authors":
-		query = fmt.Sprintf(`create table %v(name string attribute indexed, entry_type string, role string, description text, avatar_file string attribute indexed, created_at timestamp, updated_at timestamp) %v`, tbl, settings)
-	case "categories":
-		query = fmt.Sprintf(`create table %v(name string attribute indexed, entry_type string, description text, created_at timestamp, updated_at timestamp) %v`, tbl, settings)
-	case "titles":
-		query = fmt.Sprintf(`create table %v(title string attribute indexed, entry_type string, description text, created_at timestamp, updated_at timestamp) %v`, tbl, settings)
-	default:
-		query = fmt.Sprintf(`create table %v(source_uuid string, source string attribute indexed, genre string attribute indexed, author string attribute indexed, title string attribute indexed, content text, language string, chunk int, char_count int, word_count int, ocr_quality float, datetime timestamp, created_at timestamp, updated_at timestamp) %v`, tbl, settings)
+	columns := make([]string, len(schema.Columns))
+	for i, col := range schema.Columns {
+		columns[i] = col.Name + " " + col.Type
 	}
+	query := fmt.Sprintf(`create table %v(%v) %v`, schema.Name, strings.Join(columns, ", "), settingsSQL(settings))
 
 	sqlRequest := apiClient.UtilsAPI.Sql(ctx).Body(query)
 	_, _, err := apiClient.UtilsAPI.SqlExecute(sqlRequest)
@@ -144,98 +153,253 @@ func createTable(ctx context.Context, engine string, tbl string) error {
 // 	return nil
 // }
 
-func (c *Client) Bulk(ctx context.Context, docs interface{}) error {
-	const op = "storage.manticore.Bulk"
-
-	var body strings.Builder
-	var indexName string
-
-	switch v := docs.(type) {
-	case *[]entry.Entry:
-		indexName = c.Index
-		for _, e := range *v {
-			jsonStr, err := json.Marshal(Root{
-				Insert: Insert{
-					Index: indexName,
-					Doc:   e,
-				},
-			})
-			if err != nil {
-				return fmt.Errorf("%s: %w", op, err)
+// Write sends documents to their tables with a single bulk request.
+func (c *Client) Write(ctx context.Context, docs []storage.Document) error {
+	const op = "storage.manticore.Write"
+
+	if len(docs) == 0 {
+		return nil
+	}
+	lines, err := bulk.Lines(docs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := c.BulkLines(ctx, docs[0].Table, lines); err != nil {
+		var bulkErr *BulkError
+		if errors.As(err, &bulkErr) {
+			failed := make([]storage.Document, len(bulkErr.Items))
+			positions := make([]int, len(bulkErr.Items))
+			for i, item := range bulkErr.Items {
+				failed[i] = docs[item.Line]
+				positions[i] = item.Line
 			}
-			body.WriteString(string(jsonStr))
-			body.WriteString(",\n")
+			return fmt.Errorf("%s: %w", op, &storage.WriteError{Docs: failed, Positions: positions, Err: err})
 		}
-	case *[]entry.Author:
-		indexName = "authors"
-		for _, a := range *v {
-			jsonStr, err := json.Marshal(Root{
-				Insert: Insert{
-					Index: indexName,
-					Doc:   a,
-				},
-			})
-			if err != nil {
-				return fmt.Errorf("%s: %w", op, err)
-			}
-			body.WriteString(string(jsonStr))
-			body.WriteString(",\n")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// BulkLines sends ready NDJSON bulk lines, e.g. replayed from a file export.
+//
+// Lines rejected with a transient error (no response, 429, 5xx) are resent
+// with exponential backoff, permanent failures are not retried. Lines skipped
+// after a failed line are resent right away and do not count as an attempt,
+// unless none of the sent lines was applied.
+// If some lines are still not written, a *BulkError listing them is returned.
+//
+// Parameters:
+// - indexName: The table name used in log messages and errors.
+// - lines: Bulk lines without trailing newlines.
+func (c *Client) BulkLines(ctx context.Context, indexName string, lines [][]byte) error {
+	pending := make([]int, len(lines))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	bulkErr := &BulkError{Table: indexName, Total: len(lines)}
+	for attempt := 0; len(pending) > 0; {
+		sent := len(pending)
+		failed, err := c.sendBulk(ctx, lines, pending)
+		if err != nil {
+			bulkErr.Err = err
 		}
-	case *[]entry.Category:
-		indexName = "categories"
-		for _, cat := range *v {
-			jsonStr, err := json.Marshal(Root{
-				Insert: Insert{
-					Index: indexName,
-					Doc:   cat,
-				},
-			})
-			if err != nil {
-				return fmt.Errorf("%s: %w", op, err)
-			}
-			body.WriteString(string(jsonStr))
-			body.WriteString(",\n")
+		if len(failed) == 0 && attempt > 0 {
+			log.Printf("Successfully inserted data into %s after %d attempts", indexName, attempt+1)
+		}
+
+		// Nothing was applied or rejected: skipped lines are retried as transient ones
+		stalled := len(failed) == sent
+		for _, item := range failed {
+			stalled = stalled && item.Skipped
 		}
-	case *[]entry.Title:
-		indexName = "titles"
-		for _, t := range *v {
-			jsonStr, err := json.Marshal(Root{
-				Insert: Insert{
-					Index: indexName,
-					Doc:   t,
-				},
-			})
-			if err != nil {
-				return fmt.Errorf("%s: %w", op, err)
+		pending = pending[:0]
+		var retry, resend []ItemError
+		for _, item := range failed {
+			switch {
+			case item.Skipped && !stalled:
+				resend = append(resend, item)
+				pending = append(pending, item.Line)
+			case item.Transient && attempt < c.maxRetries:
+				retry = append(retry, item)
+				resend = append(resend, item)
+				pending = append(pending, item.Line)
+			default:
+				bulkErr.Items = append(bulkErr.Items, item)
 			}
-			body.WriteString(string(jsonStr))
-			body.WriteString(",\n")
 		}
-	default:
-		return fmt.Errorf("%s: unsupported type %T", op, v)
+		if len(retry) == 0 {
+			continue
+		}
+
+		delay := backoff(attempt)
+		log.Printf("Failed to insert %d of %d documents into %s, retrying in %v... (attempt %d/%d)",
+			len(retry), len(lines), indexName, delay, attempt+1, c.maxRetries)
+		log.Printf("Error: %v", retry[0])
+		attempt++
+		if err := sleep(ctx, delay); err != nil {
+			bulkErr.Err = err
+			bulkErr.Items = append(bulkErr.Items, resend...)
+			break
+		}
 	}
 
-	const maxRetries = 1000
-	const retryDelay = 100 * time.Millisecond
+	if len(bulkErr.Items) > 0 {
+		sort.Slice(bulkErr.Items, func(i, j int) bool { return bulkErr.Items[i].Line < bulkErr.Items[j].Line })
+		return bulkErr
+	}
+	return nil
+}
+
+// DeleteBySourceUUID removes all chunks of a book from the content tables
+// and its rows from the books catalogue and link tables.
+func (c *Client) DeleteBySourceUUID(ctx context.Context, sourceUUID uuid.UUID) error {
+	const op = "storage.manticore.DeleteBySourceUUID"
 
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		_, _, err := c.apiClient.IndexAPI.Bulk(ctx).Body(body.String()).Execute()
-		if err == nil {
-			if attempt > 0 {
-				log.Printf("Successfully inserted data into %s after %d attempts", indexName, attempt+1)
+	for _, table := range c.bookTables {
+		query := fmt.Sprintf("DELETE FROM %v WHERE source_uuid='%v'", table, sourceUUID)
+		if _, err := c.sql(ctx, query); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+// DeleteBySource removes all chunks of a book by its source file name.
+func (c *Client) DeleteBySource(ctx context.Context, source string) error {
+	const op = "storage.manticore.DeleteBySource"
+
+	for _, table := range c.bookTables {
+		query := fmt.Sprintf("DELETE FROM %v WHERE source='%v'", table, quote(source))
+		if _, err := c.sql(ctx, query); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+// ContentTables returns the main table and the language content tables.
+func (c *Client) ContentTables() []string {
+	return c.tables
+}
+
+// Count returns the number of documents in a table matching an optional SQL condition.
+func (c *Client) Count(ctx context.Context, table, where string) (int64, error) {
+	const op = "storage.manticore.Count"
+
+	query := fmt.Sprintf("SELECT COUNT(*) AS n FROM %v", table)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := c.sql(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return toInt64(rows[0]["n"]), nil
+}
+
+// CountBooks returns the number of distinct books in the content tables.
+func (c *Client) CountBooks(ctx context.Context) (int64, error) {
+	const op = "storage.manticore.CountBooks"
+
+	rows, err := c.sql(ctx, fmt.Sprintf("SELECT COUNT(DISTINCT source_uuid) AS n FROM %v", strings.Join(c.tables, ", ")))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return toInt64(rows[0]["n"]), nil
+}
+
+// chunkCountsPage is the number of books per ChunkCounts query.
+var chunkCountsPage = 10000
+
+// ChunkCounts returns the number of chunks per source_uuid in the content tables.
+// Books are read in pages ordered by source_uuid, so the result is complete
+// regardless of the number of books in the index.
+func (c *Client) ChunkCounts(ctx context.Context) (map[string]int64, error) {
+	const op = "storage.manticore.ChunkCounts"
+
+	counts := make(map[string]int64)
+	last := ""
+	for {
+		rows, err := c.sql(ctx, chunkCountsQuery(c.tables, last, chunkCountsPage))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		after := last
+		for _, row := range rows {
+			if id, ok := row["source_uuid"].(string); ok {
+				counts[id] = toInt64(row["n"])
+				last = max(last, id)
 			}
-			break
 		}
-		if attempt < maxRetries-1 {
-			log.Printf("Failed to insert data into %s, retrying... (attempt %d/%d)", indexName, attempt+1, maxRetries)
-			log.Printf("Error: %v", err)
-			time.Sleep(retryDelay)
+		if len(rows) < chunkCountsPage || last == after {
+			return counts, nil
+		}
+	}
+}
+
+// chunkCountsQuery returns the query of the page of chunk counts following the book after.
+func chunkCountsQuery(tables []string, after string, limit int) string {
+	return fmt.Sprintf("SELECT source_uuid, COUNT(*) AS n FROM %v WHERE source_uuid > '%v' GROUP BY source_uuid ORDER BY source_uuid ASC LIMIT %d OPTION max_matches=%d",
+		strings.Join(tables, ", "), quote(after), limit, limit)
+}
+
+// sql executes a raw SQL query and returns the rows of the first result set.
+//
+// Manticore reports query errors inside the response body with HTTP 200,
+// so the "error" field of every result set is checked as well.
+func (c *Client) sql(ctx context.Context, query string) ([]map[string]interface{}, error) {
+	resp, _, err := c.apiClient.UtilsAPI.Sql(ctx).Body(query).RawResponse(true).Execute()
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.ArrayOfMapmapOfStringAny == nil {
+		return nil, nil
+	}
+
+	var rows []map[string]interface{}
+	for n, result := range *resp.ArrayOfMapmapOfStringAny {
+		if msg, ok := result["error"].(string); ok && msg != "" {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		if n > 0 {
 			continue
 		}
-		return fmt.Errorf("%s: failed after %d attempts: %w", op, maxRetries, err)
+		if data, ok := result["data"].([]interface{}); ok {
+			for _, row := range data {
+				if m, ok := row.(map[string]interface{}); ok {
+					rows = append(rows, m)
+				}
+			}
+		}
 	}
+	return rows, nil
+}
 
-	return nil
+// quote escapes a string value for use inside single quotes in SQL.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `'`, `\'`)
+}
+
+// toInt64 converts a numeric value decoded from a JSON response.
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	}
+	return 0
 }
 
 // serverConfigurationURL generates the server configuration URL based on the provided Manticore configuration.
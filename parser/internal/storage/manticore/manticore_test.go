@@ -0,0 +1,59 @@
+package manticore
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestChunkCountsPages(t *testing.T) {
+	page := chunkCountsPage
+	chunkCountsPage = 2
+	t.Cleanup(func() { chunkCountsPage = page })
+
+	books := []string{"a", "b", "c", "d", "e"}
+	afterRe := regexp.MustCompile(`source_uuid > '([^']*)'.* LIMIT (\d+)`)
+	queries := 0
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query, err := url.QueryUnescape(string(body))
+		if err != nil {
+			query = string(body)
+		}
+		m := afterRe.FindStringSubmatch(query)
+		if m == nil {
+			t.Fatalf("unexpected query %q", query)
+		}
+		queries++
+		limit, _ := strconv.Atoi(m[2])
+		var data []map[string]any
+		for _, id := range books {
+			if id > m[1] && len(data) < limit {
+				data = append(data, map[string]any{"source_uuid": id, "n": queries})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]map[string]any{{"data": data, "total": len(data), "error": "", "warning": ""}})
+	})
+	client.tables = []string{"library"}
+
+	counts, err := client.ChunkCounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != len(books) {
+		t.Errorf("got %d books, want %d: %v", len(counts), len(books), counts)
+	}
+	if queries != 3 {
+		t.Errorf("got %d queries, want 3", queries)
+	}
+	// n — номер страницы, на которой пришла книга
+	if counts["a"] != 1 || counts["c"] != 2 || counts["e"] != 3 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
@@ -0,0 +1,271 @@
+package manticore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// ErrIncompatibleSchema is returned when a live table cannot be migrated
+// to the expected schema by adding columns.
+var ErrIncompatibleSchema = errors.New("incompatible schema")
+
+// metaSchema is the table with the applied schema version of every table.
+var metaSchema = storage.Schema{
+	Name:    "schema_meta",
+	Version: 1,
+	Columns: []storage.Column{
+		{Name: "table_name", Type: "string"},
+		{Name: "version", Type: "int"},
+		{Name: "checksum", Type: "string"},
+		{Name: "applied_at", Type: "timestamp"},
+	},
+}
+
+// Change is a column that has to be added to a live table.
+type Change struct {
+	Table  string
+	Column storage.Column
+}
+
+// SQL returns the statement that applies the change.
+func (c Change) SQL() string {
+	return fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", c.Table, c.Column.Name, c.Column.Type)
+}
+
+// Plan describes what has to be done to bring the live schema to the expected one.
+type Plan struct {
+	Create       []storage.Schema // missing tables
+	Alter        []Change         // missing columns of existing tables
+	Extra        []string         // live columns unknown to the parser, reported only
+	Incompatible []string         // differences that cannot be migrated automatically
+	Versions     map[string]int   // applied versions from schema_meta
+	schemas      []storage.Schema
+}
+
+// Empty reports whether the live schema matches the expected one.
+func (p *Plan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Alter) == 0 && len(p.Incompatible) == 0
+}
+
+// liveColumn is a row of DESCRIBE output. A "string attribute indexed" column
+// is described twice: as a text field and as a string attribute.
+type liveColumn struct {
+	Name string
+	Type string
+}
+
+// PlanMigrations compares the live tables with the expected schemas.
+func (c *Client) PlanMigrations(ctx context.Context, schemas []storage.Schema) (*Plan, error) {
+	const op = "storage.manticore.PlanMigrations"
+
+	schemas = append([]storage.Schema{metaSchema}, schemas...)
+	plan := &Plan{schemas: schemas}
+
+	versions, err := c.appliedVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	plan.Versions = versions
+
+	for _, schema := range schemas {
+		if !tableExists(ctx, schema.Name) {
+			plan.Create = append(plan.Create, schema)
+			continue
+		}
+
+		if v, ok := versions[schema.Name]; ok && v > schema.Version {
+			plan.Incompatible = append(plan.Incompatible, fmt.Sprintf(
+				"%s: schema version %d in Manticore is newer than %d expected by the parser", schema.Name, v, schema.Version))
+			continue
+		}
+
+		live, err := c.describe(ctx, schema.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		alter, extra, incompatible := diffSchema(schema, live)
+		plan.Alter = append(plan.Alter, alter...)
+		plan.Extra = append(plan.Extra, extra...)
+		plan.Incompatible = append(plan.Incompatible, incompatible...)
+	}
+	return plan, nil
+}
+
+// Migrate creates missing tables, adds missing columns and records the applied
+// schema versions. Incompatible differences are returned as ErrIncompatibleSchema
+// before anything is changed.
+func (c *Client) Migrate(ctx context.Context, plan *Plan) error {
+	const op = "storage.manticore.Migrate"
+
+	if len(plan.Incompatible) > 0 {
+		return fmt.Errorf("%s: %w:\n  %s", op, ErrIncompatibleSchema, strings.Join(plan.Incompatible, "\n  "))
+	}
+
+	for _, schema := range plan.Create {
+		log.Printf("creating table %v (schema version %d)", schema.Name, schema.Version)
+		if err := createTable(ctx, schema, tableSettings(c.cfg, schema.Name)); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	for _, change := range plan.Alter {
+		log.Printf("migrating: %v", change.SQL())
+		if _, err := c.sql(ctx, change.SQL()); err != nil {
+			return fmt.Errorf("%s: %s: %w", op, change.SQL(), err)
+		}
+	}
+	for _, extra := range plan.Extra {
+		log.Printf("schema drift: column %v is not used by the parser", extra)
+	}
+
+	for _, schema := range plan.schemas {
+		if v, ok := plan.Versions[schema.Name]; ok && v == schema.Version && !plan.changed(schema.Name) {
+			continue
+		}
+		if err := c.recordVersion(ctx, schema); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+func (p *Plan) changed(table string) bool {
+	for _, s := range p.Create {
+		if s.Name == table {
+			return true
+		}
+	}
+	for _, ch := range p.Alter {
+		if ch.Table == table {
+			return true
+		}
+	}
+	return false
+}
+
+// diffSchema returns columns to add, live columns unknown to the schema
+// and columns whose type differs.
+func diffSchema(schema storage.Schema, live []liveColumn) (alter []Change, extra, incompatible []string) {
+	liveTypes := make(map[string]map[string]bool)
+	for _, col := range live {
+		if liveTypes[col.Name] == nil {
+			liveTypes[col.Name] = make(map[string]bool)
+		}
+		liveTypes[col.Name][baseType(col.Type)] = true
+	}
+
+	expected := make(map[string]bool, len(schema.Columns))
+	for _, col := range schema.Columns {
+		expected[col.Name] = true
+		types, ok := liveTypes[col.Name]
+		if !ok {
+			alter = append(alter, Change{Table: schema.Name, Column: col})
+			continue
+		}
+		want := baseType(col.Type)
+		if !types[want] {
+			incompatible = append(incompatible, fmt.Sprintf(
+				"%s.%s: expected %s, found %s", schema.Name, col.Name, col.Type, typeList(types)))
+		}
+	}
+
+	for _, col := range live {
+		if col.Name == "id" || expected[col.Name] {
+			continue
+		}
+		name := schema.Name + "." + col.Name
+		if len(extra) == 0 || extra[len(extra)-1] != name {
+			extra = append(extra, name)
+		}
+	}
+	return alter, extra, incompatible
+}
+
+// baseType normalizes a column type of CREATE TABLE or DESCRIBE output.
+func baseType(t string) string {
+	fields := strings.Fields(strings.ToLower(t))
+	if len(fields) == 0 {
+		return ""
+	}
+	switch fields[0] {
+	case "int", "integer", "uint":
+		return "uint"
+	case "multi", "mva":
+		return "mva"
+	case "multi64", "mva64":
+		return "mva64"
+	}
+	return fields[0]
+}
+
+func typeList(types map[string]bool) string {
+	list := make([]string, 0, len(types))
+	for t := range types {
+		list = append(list, t)
+	}
+	return strings.Join(list, "/")
+}
+
+func (c *Client) describe(ctx context.Context, table string) ([]liveColumn, error) {
+	rows, err := c.sql(ctx, fmt.Sprintf("DESCRIBE %v", table))
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]liveColumn, 0, len(rows))
+	for _, row := range rows {
+		name, _ := row["Field"].(string)
+		typ, _ := row["Type"].(string)
+		if name != "" {
+			cols = append(cols, liveColumn{Name: name, Type: typ})
+		}
+	}
+	return cols, nil
+}
+
+// appliedVersions reads schema_meta, an absent table means nothing was recorded yet.
+func (c *Client) appliedVersions(ctx context.Context) (map[string]int, error) {
+	versions := make(map[string]int)
+	if !tableExists(ctx, metaSchema.Name) {
+		return versions, nil
+	}
+	rows, err := c.sql(ctx, fmt.Sprintf("SELECT table_name, version FROM %v LIMIT 1000", metaSchema.Name))
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if name, ok := row["table_name"].(string); ok {
+			versions[name] = int(toInt64(row["version"]))
+		}
+	}
+	return versions, nil
+}
+
+func (c *Client) recordVersion(ctx context.Context, schema storage.Schema) error {
+	query := fmt.Sprintf("REPLACE INTO %v (id, table_name, version, checksum, applied_at) VALUES (%d, '%v', %d, '%v', %d)",
+		metaSchema.Name, tableID(schema.Name), quote(schema.Name), schema.Version, checksum(schema), time.Now().Unix())
+	_, err := c.sql(ctx, query)
+	return err
+}
+
+// checksum identifies the column set of a schema version.
+func checksum(schema storage.Schema) string {
+	h := sha256.New()
+	for _, col := range schema.Columns {
+		fmt.Fprintf(h, "%s %s;", col.Name, col.Type)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// tableID is a stable positive document ID of a table row in schema_meta.
+func tableID(name string) int64 {
+	sum := sha256.Sum256([]byte(name))
+	return int64(binary.BigEndian.Uint64(sum[:8])&0x7fffffffffffffff) | 1
+}
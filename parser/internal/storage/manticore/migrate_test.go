@@ -0,0 +1,95 @@
+package manticore
+
+import (
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+func TestDiffSchema(t *testing.T) {
+	schema := storage.Schema{
+		Name:    "authors",
+		Version: 2,
+		Columns: []storage.Column{
+			{Name: "name", Type: "string attribute indexed"},
+			{Name: "books", Type: "int"},
+			{Name: "aliases", Type: "multi64"},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		live         []liveColumn
+		alter        []string
+		extra        []string
+		incompatible int
+	}{
+		{
+			name: "up to date",
+			live: []liveColumn{
+				{Name: "id", Type: "bigint"},
+				{Name: "name", Type: "text"},
+				{Name: "name", Type: "string"},
+				{Name: "books", Type: "uint"},
+				{Name: "aliases", Type: "mva64"},
+			},
+		},
+		{
+			name: "missing column",
+			live: []liveColumn{
+				{Name: "id", Type: "bigint"},
+				{Name: "name", Type: "text"},
+				{Name: "name", Type: "string"},
+				{Name: "books", Type: "uint"},
+			},
+			alter: []string{"ALTER TABLE authors ADD COLUMN aliases multi64"},
+		},
+		{
+			name: "type mismatch and extra column",
+			live: []liveColumn{
+				{Name: "id", Type: "bigint"},
+				{Name: "name", Type: "text"},
+				{Name: "name", Type: "string"},
+				{Name: "books", Type: "string"},
+				{Name: "aliases", Type: "mva64"},
+				{Name: "legacy", Type: "text"},
+				{Name: "legacy", Type: "string"},
+			},
+			extra:        []string{"authors.legacy"},
+			incompatible: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alter, extra, incompatible := diffSchema(schema, tt.live)
+
+			if len(alter) != len(tt.alter) {
+				t.Fatalf("got %d changes, want %d", len(alter), len(tt.alter))
+			}
+			for i, ch := range alter {
+				if ch.SQL() != tt.alter[i] {
+					t.Errorf("got %q, want %q", ch.SQL(), tt.alter[i])
+				}
+			}
+			if len(extra) != len(tt.extra) || (len(extra) > 0 && extra[0] != tt.extra[0]) {
+				t.Errorf("got extra %v, want %v", extra, tt.extra)
+			}
+			if len(incompatible) != tt.incompatible {
+				t.Errorf("got incompatible %v, want %d", incompatible, tt.incompatible)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	a := storage.Schema{Name: "t", Columns: []storage.Column{{Name: "a", Type: "text"}}}
+	b := storage.Schema{Name: "t", Columns: []storage.Column{{Name: "a", Type: "string"}}}
+
+	if checksum(a) == checksum(b) {
+		t.Error("checksum does not depend on column types")
+	}
+	if checksum(a) != checksum(a) {
+		t.Error("checksum is not stable")
+	}
+}
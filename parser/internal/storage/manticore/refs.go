@@ -0,0 +1,201 @@
+package manticore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/storage"
+)
+
+// refTable maps a metadata table to the chunk attribute referencing its rows.
+type refTable struct {
+	table  string // metadata table without the version suffix, see Client.catalogue
+	values func(entry.BookRef) []string
+	used   func(value string) string // condition on the content tables matching chunks that reference value
+	match  func(value string) string // condition on the metadata table matching the row of value
+	row    func(value string) storage.Document
+}
+
+var refTables = []refTable{
+	{
+		// Author rows are written with the book by SaveBook: their names are
+		// resolved through the aliases file, so they are matched by ID only.
+		table:  entry.AuthorTable.Name,
+		values: func(r entry.BookRef) []string { return formatIDs(r.AuthorIDs) },
+		used:   func(v string) string { return fmt.Sprintf("ANY(author_ids)=%v", v) },
+		match:  func(v string) string { return fmt.Sprintf("id=%v", v) },
+	},
+	{
+		// Category rows are written with the book by SaveBook as well: the ID
+		// depends on the whole taxonomy path, which chunks store only as IDs.
+		table:  entry.CategoryTable.Name,
+		values: func(r entry.BookRef) []string { return formatIDs(r.CategoryIDs) },
+		used:   func(v string) string { return fmt.Sprintf("ANY(category_ids)=%v", v) },
+		match:  func(v string) string { return fmt.Sprintf("id=%v", v) },
+	},
+	{
+		table:  entry.TitleTable.Name,
+		values: func(r entry.BookRef) []string { return []string{r.Title} },
+		used:   func(v string) string { return fmt.Sprintf("title='%v'", quote(v)) },
+		match:  func(v string) string { return fmt.Sprintf("title='%v'", quote(v)) },
+		row: func(v string) storage.Document {
+			return entry.TitleTable.Documents([]entry.Title{*entry.NewTitle(v, "book")})[0]
+		},
+	},
+}
+
+// BookRefs returns the distinct author, genre and title values of the chunks
+// matching field = value, e.g. source_uuid or source, with the author and category IDs.
+func (c *Client) BookRefs(ctx context.Context, field, value string) ([]entry.BookRef, error) {
+	const op = "storage.manticore.BookRefs"
+
+	query := fmt.Sprintf("SELECT author, genre, title, author_ids, category_ids FROM %v WHERE %v='%v' GROUP BY author, genre, title LIMIT 100",
+		strings.Join(c.tables, ", "), field, quote(value))
+	rows, err := c.sql(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	refs := make([]entry.BookRef, 0, len(rows))
+	for _, row := range rows {
+		author, _ := row["author"].(string)
+		genre, _ := row["genre"].(string)
+		title, _ := row["title"].(string)
+		refs = append(refs, entry.BookRef{Author: author, Genre: genre, Title: title, AuthorIDs: toInt64List(row["author_ids"]), CategoryIDs: toInt64List(row["category_ids"])})
+	}
+	return refs, nil
+}
+
+// AddRefs inserts the title rows missing for refs
+// and returns the number of inserted rows.
+func (c *Client) AddRefs(ctx context.Context, refs []entry.BookRef) (int, error) {
+	const op = "storage.manticore.AddRefs"
+
+	var docs []storage.Document
+	for _, rt := range refTables {
+		if rt.row == nil {
+			continue
+		}
+		for _, v := range distinct(refs, rt.values) {
+			n, err := c.Count(ctx, c.catalogue(rt.table), rt.match(v))
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", op, err)
+			}
+			if n == 0 {
+				doc := rt.row(v)
+				doc.Table = c.catalogue(doc.Table)
+				docs = append(docs, doc)
+			}
+		}
+	}
+	if err := c.Write(ctx, docs); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return len(docs), nil
+}
+
+// CollectGarbage removes the rows of authors, categories and titles of refs
+// that are no longer referenced by any chunk and returns the number of removed values.
+func (c *Client) CollectGarbage(ctx context.Context, refs []entry.BookRef) (int, error) {
+	const op = "storage.manticore.CollectGarbage"
+
+	removed := 0
+	for _, rt := range refTables {
+		for _, v := range distinct(refs, rt.values) {
+			n, err := c.Count(ctx, strings.Join(c.tables, ", "), rt.used(v))
+			if err != nil {
+				return removed, fmt.Errorf("%s: %w", op, err)
+			}
+			if n > 0 {
+				continue
+			}
+			query := fmt.Sprintf("DELETE FROM %v WHERE %v", c.catalogue(rt.table), rt.match(v))
+			if _, err := c.sql(ctx, query); err != nil {
+				return removed, fmt.Errorf("%s: %w", op, err)
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// distinct returns the non-empty distinct values of refs.
+func distinct(refs []entry.BookRef, values func(entry.BookRef) []string) []string {
+	seen := make(map[string]struct{}, len(refs))
+	var out []string
+	for _, r := range refs {
+		for _, v := range values(r) {
+			if _, ok := seen[v]; ok || v == "" {
+				continue
+			}
+			seen[v] = struct{}{}
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// formatIDs returns ids as refTable values.
+func formatIDs(ids []int64) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, strconv.FormatInt(id, 10))
+	}
+	return out
+}
+
+// toInt64List converts a multi64 value of SQL JSON output, returned either
+// as a comma separated string or as an array.
+func toInt64List(v any) []int64 {
+	var ids []int64
+	switch v := v.(type) {
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			ids = append(ids, toInt64(item))
+		}
+	}
+	return ids
+}
+
+// Authors returns the stored author rows with the given IDs, see entry.AuthorReader.
+func (c *Client) Authors(ctx context.Context, ids []int64) ([]entry.Author, error) {
+	const op = "storage.manticore.Authors"
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	query := fmt.Sprintf("SELECT id, name, entry_type, role, aliases, description, avatar_file, created_at, updated_at FROM %v WHERE id IN (%v) LIMIT %d",
+		c.catalogue(entry.AuthorTable.Name), strings.Join(formatIDs(ids), ","), len(ids))
+	rows, err := c.sql(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	authors := make([]entry.Author, 0, len(rows))
+	for _, row := range rows {
+		id := toInt64(row["id"])
+		a := entry.Author{ID: &id, CreatedAt: toInt64(row["created_at"]), UpdatedAt: toInt64(row["updated_at"])}
+		a.Name, _ = row["name"].(string)
+		a.EntryType, _ = row["entry_type"].(string)
+		a.Role, _ = row["role"].(string)
+		a.Aliases, _ = row["aliases"].(string)
+		a.Description, _ = row["description"].(string)
+		a.Avatar_file, _ = row["avatar_file"].(string)
+		authors = append(authors, a)
+	}
+	return authors, nil
+}
+
+// catalogue returns the name of a catalogue table in the version the client writes to.
+func (c *Client) catalogue(table string) string {
+	return table + c.cfg.Version
+}
@@ -0,0 +1,69 @@
+package manticore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/library/entry"
+)
+
+// defaultSettings are the table settings used when nothing is configured.
+var defaultSettings = config.TableSettings{
+	"min_infix_len":     "3",
+	"index_exact_words": "1",
+	"morphology":        "stem_en, stem_ru",
+	"index_sp":          "1",
+}
+
+// tableSettings returns the CREATE TABLE settings of a table. Layers are applied
+// in order: defaults and engine, manticore.settings, settings of the main table
+// and of the language for language content tables, then the table's own settings
+// (of the unversioned name first for tables of a version built by rebuild).
+func tableSettings(cfg *config.Manticore, name string) config.TableSettings {
+	settings := make(config.TableSettings, len(defaultSettings)+1)
+	merge := func(layer config.TableSettings) {
+		for k, v := range layer {
+			settings[k] = v
+		}
+	}
+
+	merge(defaultSettings)
+	settings["engine"] = cfg.Engine
+	merge(cfg.Settings)
+	for lang, layer := range cfg.Languages {
+		if name == entry.LanguageTable(cfg.Index, lang) {
+			merge(cfg.Tables[cfg.Index])
+			merge(layer)
+		}
+	}
+	// Таблицы версии, построенной rebuild, получают параметры таблицы без версии
+	if base := strings.TrimSuffix(name, cfg.Version); base != name {
+		merge(cfg.Tables[base])
+	}
+	merge(cfg.Tables[name])
+
+	// Пустое значение отключает параметр, например morphology: ""
+	for k, v := range settings {
+		if v == "" {
+			delete(settings, k)
+		}
+	}
+	return settings
+}
+
+// settingsSQL formats settings as the options of CREATE TABLE in a stable order.
+func settingsSQL(settings config.TableSettings) string {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	options := make([]string, len(keys))
+	for i, k := range keys {
+		options[i] = fmt.Sprintf("%v='%v'", k, quote(settings[k]))
+	}
+	return strings.Join(options, " ")
+}
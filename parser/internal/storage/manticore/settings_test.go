@@ -0,0 +1,62 @@
+package manticore
+
+import (
+	"testing"
+
+	"github.com/terratensor/library/parser/internal/config"
+)
+
+func TestTableSettings(t *testing.T) {
+	cfg := &config.Manticore{
+		Engine:   "columnar",
+		Index:    "library",
+		Settings: config.TableSettings{"morphology": "lemmatize_ru_all, stem_en"},
+		Tables: map[string]config.TableSettings{
+			"library":    {"stopwords": "/opt/stopwords/ru.txt"},
+			"library_de": {"wordforms": "/opt/wordforms/de.txt"},
+			"authors":    {"min_infix_len": ""},
+		},
+		Languages: map[string]config.TableSettings{
+			"de": {"morphology": "lemmatize_de_all"},
+			"uk": {"morphology": "lemmatize_uk_all"},
+		},
+	}
+
+	tests := []struct {
+		table string
+		want  string
+	}{
+		{
+			table: "library",
+			want:  "engine='columnar' index_exact_words='1' index_sp='1' min_infix_len='3' morphology='lemmatize_ru_all, stem_en' stopwords='/opt/stopwords/ru.txt'",
+		},
+		{
+			table: "library_de",
+			want:  "engine='columnar' index_exact_words='1' index_sp='1' min_infix_len='3' morphology='lemmatize_de_all' stopwords='/opt/stopwords/ru.txt' wordforms='/opt/wordforms/de.txt'",
+		},
+		{
+			table: "authors",
+			want:  "engine='columnar' index_exact_words='1' index_sp='1' morphology='lemmatize_ru_all, stem_en'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			if got := settingsSQL(tableSettings(cfg, tt.table)); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+
+	// Таблицы версии, построенной rebuild, получают параметры таблицы без версии
+	version := *cfg
+	version.Index, version.Version = "library_v3", "_v3"
+	for _, tt := range []struct{ table, want string }{
+		{"library_v3", tests[0].want},
+		{"authors_v3", tests[2].want},
+	} {
+		if got := settingsSQL(tableSettings(&version, tt.table)); got != tt.want {
+			t.Errorf("%s: got  %s\nwant %s", tt.table, got, tt.want)
+		}
+	}
+}
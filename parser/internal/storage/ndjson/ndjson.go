@@ -0,0 +1,244 @@
+// Package ndjson хранилище, которое вместо отправки в Manticore пишет строки
+// bulk-запросов в NDJSON-файлы. Позволяет парсить на машине без Manticore
+// и позже загрузить выгрузку командой load.
+package ndjson
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/terratensor/library/parser/internal/config"
+	"github.com/terratensor/library/parser/internal/library/entry"
+	"github.com/terratensor/library/parser/internal/storage"
+	"github.com/terratensor/library/parser/internal/storage/bulk"
+)
+
+var _ entry.StorageInterface = &Sink{}
+
+type Sink struct {
+	Index string
+
+	tables    []string // таблицы параграфов, из которых удаляются книги
+	dir       string
+	prefix    string
+	gzip      bool
+	shardSize int
+
+	mu    sync.Mutex
+	shard int // номер текущего файла
+	docs  int // строк в текущем файле
+	f     *os.File
+	gz    *gzip.Writer
+	w     *bufio.Writer
+}
+
+// New создаёт каталог выгрузки. Имена файлов содержат таблицу и время запуска,
+// поэтому повторный запуск не перезаписывает предыдущую выгрузку.
+func New(cfg *config.FileSink, index string, languages []string) (*Sink, error) {
+	const op = "storage.ndjson.New"
+
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Sink{
+		Index:     index,
+		tables:    entry.BookTables(index, "", languages),
+		dir:       cfg.Dir,
+		prefix:    fmt.Sprintf("%s-%s", index, time.Now().Format("20060102-150405")),
+		gzip:      cfg.Gzip,
+		shardSize: cfg.ShardSize,
+	}, nil
+}
+
+// Write дописывает строки вставки документов в текущий файл выгрузки
+func (s *Sink) Write(ctx context.Context, docs []storage.Document) error {
+	const op = "storage.ndjson.Write"
+
+	lines, err := bulk.Lines(docs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := s.write(lines...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteBySourceUUID записывает удаление параграфов книги, оно выполнится при загрузке
+func (s *Sink) DeleteBySourceUUID(ctx context.Context, sourceUUID uuid.UUID) error {
+	return s.delete("source_uuid", sourceUUID.String())
+}
+
+// DeleteBySource записывает удаление параграфов книги по имени файла
+func (s *Sink) DeleteBySource(ctx context.Context, source string) error {
+	return s.delete("source", source)
+}
+
+func (s *Sink) delete(field, value string) error {
+	const op = "storage.ndjson.delete"
+
+	lines := make([][]byte, len(s.tables))
+	for i, table := range s.tables {
+		line, err := bulk.DeleteLine(table, field, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		lines[i] = line
+	}
+	if err := s.write(lines...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Close дописывает буферы и закрывает текущий файл
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeShard()
+}
+
+func (s *Sink) write(lines ...[]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, line := range lines {
+		if s.w == nil || (s.shardSize > 0 && s.docs >= s.shardSize) {
+			if err := s.openShard(); err != nil {
+				return err
+			}
+		}
+		if _, err := s.w.Write(line); err != nil {
+			return err
+		}
+		if err := s.w.WriteByte('\n'); err != nil {
+			return err
+		}
+		s.docs++
+	}
+	return nil
+}
+
+func (s *Sink) openShard() error {
+	if err := s.closeShard(); err != nil {
+		return err
+	}
+
+	s.shard++
+	name := fmt.Sprintf("%s-%05d.ndjson", s.prefix, s.shard)
+	if s.gzip {
+		name += ".gz"
+	}
+
+	f, err := os.Create(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	s.f = f
+	s.docs = 0
+
+	var w io.Writer = f
+	if s.gzip {
+		s.gz = gzip.NewWriter(f)
+		w = s.gz
+	}
+	s.w = bufio.NewWriterSize(w, 1<<20)
+	return nil
+}
+
+func (s *Sink) closeShard() error {
+	if s.w == nil {
+		return nil
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if s.gz != nil {
+		if err := s.gz.Close(); err != nil {
+			return err
+		}
+	}
+	err := s.f.Close()
+	s.f, s.gz, s.w = nil, nil, nil
+	return err
+}
+
+// Files возвращает файлы выгрузки по пути к файлу или каталогу в порядке записи
+func Files(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && (strings.HasSuffix(e.Name(), ".ndjson") || strings.HasSuffix(e.Name(), ".ndjson.gz")) {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ReadLines читает файл выгрузки и передаёт строки в fn пакетами не больше batchSize
+func ReadLines(path string, batchSize int, fn func(lines [][]byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	sc := bufio.NewScanner(r)
+	// Параграфы бывают длинными, строка может занимать несколько мегабайт
+	sc.Buffer(make([]byte, 0, 1<<20), 64<<20)
+
+	var batch [][]byte
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		batch = append(batch, append([]byte(nil), sc.Bytes()...))
+		if len(batch) >= batchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Package storage типизированный слой записи в хранилище.
+//
+// Каждая модель описывается дескриптором Table[T]: имя таблицы, схема и способ
+// получения ID документа. Repository[T] превращает модели в Document и пишет их
+// пакетами через Writer, поэтому бэкендам (Manticore, NDJSON-выгрузка) не нужно
+// знать о конкретных типах. Новая модель добавляется объявлением своей таблицы.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Column колонка таблицы, Type задаётся в синтаксисе Manticore,
+// например "text", "string attribute indexed", "timestamp"
+type Column struct {
+	Name string
+	Type string
+}
+
+// Schema имя, версия и колонки таблицы. Version увеличивается при каждом
+// изменении Columns, по ней миграции определяют, что схема в хранилище устарела.
+type Schema struct {
+	Name    string
+	Version int
+	Columns []Column
+}
+
+// Table дескриптор таблицы модели T
+type Table[T any] struct {
+	Schema
+	// ID возвращает ID документа. Документы с ID заменяются при повторной записи,
+	// без ID — получают ID от хранилища. Если функция не задана, ID всегда назначает хранилище.
+	ID func(doc *T) *int64
+	// Route возвращает таблицу документа, если она отличается от Name,
+	// например для параграфов, разложенных по языкам. Пустая строка — таблица Name.
+	Route func(doc *T) string
+}
+
+// NewTable создаёт дескриптор таблицы
+func NewTable[T any](name string, version int, columns []Column, id func(doc *T) *int64) *Table[T] {
+	return &Table[T]{
+		Schema: Schema{Name: name, Version: version, Columns: columns},
+		ID:     id,
+	}
+}
+
+// WithName возвращает копию дескриптора с другим именем таблицы,
+// например для основной таблицы, имя которой задаётся в конфиге
+func (t *Table[T]) WithName(name string) *Table[T] {
+	c := *t
+	c.Name = name
+	return &c
+}
+
+// WithRoute возвращает копию дескриптора, раскладывающую документы по таблицам функцией route
+func (t *Table[T]) WithRoute(route func(doc *T) string) *Table[T] {
+	c := *t
+	c.Route = route
+	return &c
+}
+
+// Documents превращает модели в документы для записи
+func (t *Table[T]) Documents(docs []T) []Document {
+	out := make([]Document, len(docs))
+	for i := range docs {
+		var id *int64
+		if t.ID != nil {
+			id = t.ID(&docs[i])
+		}
+		table := t.Name
+		if t.Route != nil {
+			if name := t.Route(&docs[i]); name != "" {
+				table = name
+			}
+		}
+		out[i] = Document{Table: table, ID: id, Doc: docs[i]}
+	}
+	return out
+}
+
+// Document документ, готовый к записи. ID передаётся отдельно от тела,
+// поэтому поле ID моделей в тело документа не сериализуется.
+type Document struct {
+	Table string
+	ID    *int64
+	Doc   interface{}
+}
+
+// Writer бэкенд, в который пишутся документы
+type Writer interface {
+	Write(ctx context.Context, docs []Document) error
+}
+
+// Pending результат асинхронной записи
+type Pending interface {
+	// Wait ждёт записи документов, при ошибке возвращает *WriteError с недописанными
+	Wait() error
+}
+
+// Submitter бэкенд, принимающий документы без ожидания их записи
+type Submitter interface {
+	Submit(ctx context.Context, docs []Document) (Pending, error)
+}
+
+// written результат синхронной записи
+type written struct {
+	err error
+}
+
+func (w written) Wait() error {
+	return w.err
+}
+
+// Repository пишет модели T в таблицу пакетами не больше batchSize документов
+type Repository[T any] struct {
+	table     *Table[T]
+	w         Writer
+	batchSize int
+}
+
+// NewRepository создаёт репозиторий. При batchSize <= 0 документы пишутся одним пакетом.
+func NewRepository[T any](w Writer, table *Table[T], batchSize int) *Repository[T] {
+	return &Repository[T]{table: table, w: w, batchSize: batchSize}
+}
+
+// Table возвращает дескриптор таблицы репозитория
+func (r *Repository[T]) Table() *Table[T] {
+	return r.table
+}
+
+// Save записывает модели в таблицу. Ошибка одного пакета не прерывает запись остальных,
+// все недописанные документы возвращаются в *WriteError.
+func (r *Repository[T]) Save(ctx context.Context, docs []T) error {
+	const op = "storage.Repository.Save"
+
+	size := r.batchSize
+	if size <= 0 {
+		size = len(docs)
+	}
+
+	var failed []Document
+	var firstErr error
+	for start := 0; start < len(docs); start += size {
+		end := min(start+size, len(docs))
+		batch := r.table.Documents(docs[start:end])
+
+		err := ctx.Err()
+		if err == nil {
+			err = r.w.Write(ctx, batch)
+		}
+		if err == nil {
+			continue
+		}
+
+		var werr *WriteError
+		if errors.As(err, &werr) {
+			failed = append(failed, werr.Docs...)
+			err = werr.Err
+		} else {
+			failed = append(failed, batch...)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%s: %s: %w", op, r.table.Name, &WriteError{Docs: failed, Err: firstErr})
+	}
+	return nil
+}
+
+// Submit отдаёт модели бэкенду без ожидания записи, если он это поддерживает,
+// иначе записывает их синхронно. Ошибка возвращается, только если документы
+// не удалось даже поставить в очередь.
+func (r *Repository[T]) Submit(ctx context.Context, docs []T) (Pending, error) {
+	s, ok := r.w.(Submitter)
+	if !ok {
+		return written{err: r.Save(ctx, docs)}, nil
+	}
+	return s.Submit(ctx, r.table.Documents(docs))
+}
+
+// WriteError документы, которые не удалось записать. Бэкенд, знающий,
+// какие именно документы пакета отклонены, возвращает только их.
+type WriteError struct {
+	Docs      []Document
+	Positions []int // индексы Docs в переданном Write пакете, если бэкенд их знает
+	Err       error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("%d documents not written: %v", len(e.Docs), e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
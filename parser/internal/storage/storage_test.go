@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+type doc struct {
+	ID   *int64
+	Name string
+}
+
+type recorder struct {
+	batches [][]Document
+}
+
+func (r *recorder) Write(ctx context.Context, docs []Document) error {
+	r.batches = append(r.batches, docs)
+	return nil
+}
+
+func TestRepositorySave(t *testing.T) {
+	table := NewTable("docs", 1, []Column{{Name: "name", Type: "string"}}, func(d *doc) *int64 { return d.ID })
+
+	id := int64(42)
+	docs := []doc{{ID: &id, Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}}
+
+	tests := []struct {
+		name      string
+		batchSize int
+		want      []int
+	}{
+		{name: "batches", batchSize: 2, want: []int{2, 2, 1}},
+		{name: "single batch", batchSize: 0, want: []int{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			if err := NewRepository(rec, table, tt.batchSize).Save(context.Background(), docs); err != nil {
+				t.Fatal(err)
+			}
+			if len(rec.batches) != len(tt.want) {
+				t.Fatalf("got %d batches, want %d", len(rec.batches), len(tt.want))
+			}
+			for i, batch := range rec.batches {
+				if len(batch) != tt.want[i] {
+					t.Errorf("batch %d: got %d docs, want %d", i, len(batch), tt.want[i])
+				}
+			}
+
+			first := rec.batches[0][0]
+			if first.Table != "docs" || first.ID == nil || *first.ID != id {
+				t.Errorf("unexpected first document: %+v", first)
+			}
+			if rec.batches[0][1].ID != nil {
+				t.Errorf("document without ID got ID %d", *rec.batches[0][1].ID)
+			}
+		})
+	}
+}
+
+func TestTableWithName(t *testing.T) {
+	table := NewTable[doc]("docs", 1, nil, nil)
+	renamed := table.WithName("library")
+
+	if renamed.Name != "library" || table.Name != "docs" {
+		t.Errorf("got %q and %q", renamed.Name, table.Name)
+	}
+	if d := renamed.Documents([]doc{{Name: "a"}}); d[0].Table != "library" || d[0].ID != nil {
+		t.Errorf("unexpected document: %+v", d[0])
+	}
+}
+
+func TestTableWithRoute(t *testing.T) {
+	table := NewTable[doc]("docs", 1, nil, nil).WithRoute(func(d *doc) string {
+		if d.Name == "de" {
+			return "docs_de"
+		}
+		return ""
+	})
+
+	d := table.Documents([]doc{{Name: "ru"}, {Name: "de"}})
+	if d[0].Table != "docs" || d[1].Table != "docs_de" {
+		t.Errorf("got tables %q and %q", d[0].Table, d[1].Table)
+	}
+}